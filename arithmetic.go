@@ -0,0 +1,47 @@
+package easyfl
+
+import "fmt"
+
+// ArithmeticProfile defines behavior of uint64 arithmetic functions 'add', 'sub' and 'mul' when the result
+// does not fit 8 bytes
+type ArithmeticProfile byte
+
+const (
+	// ArithmeticDefault is the behavior of libraries without the profile: 'add' and 'mul' wrap modulo 2^64,
+	// 'sub' panics on underflow
+	ArithmeticDefault = ArithmeticProfile(iota)
+	// ArithmeticStrict overflow and underflow panic
+	ArithmeticStrict
+	// ArithmeticWrapping results are taken modulo 2^64, like in EVM
+	ArithmeticWrapping
+)
+
+// LibraryOption configures the library at construction
+type LibraryOption func(lib *Library)
+
+func (p ArithmeticProfile) String() string {
+	switch p {
+	case ArithmeticDefault:
+		return "default"
+	case ArithmeticStrict:
+		return "strict"
+	case ArithmeticWrapping:
+		return "wrapping"
+	}
+	return fmt.Sprintf("arithmetic(%d)", byte(p))
+}
+
+// WithArithmeticProfile sets arithmetic profile of the library. It can't be changed after construction,
+// because bytecode evaluates differently under different profiles. Profile other than ArithmeticDefault
+// is part of the library hash
+func WithArithmeticProfile(p ArithmeticProfile) LibraryOption {
+	Assert(p <= ArithmeticWrapping, "wrong arithmetic profile: %s", p)
+	return func(lib *Library) {
+		lib.arithmetic = p
+	}
+}
+
+// ArithmeticProfile returns arithmetic profile of the library
+func (lib *Library) ArithmeticProfile() ArithmeticProfile {
+	return lib.arithmetic
+}
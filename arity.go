@@ -0,0 +1,55 @@
+package easyfl
+
+import "fmt"
+
+// PinVarargArity makes vararg embedded function a function with fixed number of parameters in this library
+// build, for example 'blake2b' with exactly one argument. The implementation is not changed. Calls with other
+// number of arguments are rejected by the compiler and by the bytecode parser.
+// Bodies of extended functions and semantics annotations, which call the function with another number of arguments,
+// make pinning fail. Pinned arity is part of the library hash
+func (lib *Library) PinVarargArity(sym string, arity int) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	if isEmbedded, _ := fd.isEmbeddedOrShort(); !isEmbedded || fd.requiredNumParams >= 0 {
+		return fmt.Errorf("only vararg embedded function can be pinned to fixed arity: '%s'", sym)
+	}
+	if arity < 0 || arity > 15 {
+		return fmt.Errorf("wrong arity %d of '%s': must be from 0 to 15", arity, sym)
+	}
+	var wrongCall func(e *Expression) bool
+	wrongCall = func(e *Expression) bool {
+		if !IsDataPrefix(e.CallPrefix) && !isParameterReference(e.CallPrefix) &&
+			lib.descriptorOfCall(e.CallPrefix) == fd && len(e.Args) != arity {
+			return true
+		}
+		for _, arg := range e.Args {
+			if wrongCall(arg) {
+				return true
+			}
+		}
+		return false
+	}
+	descriptors, bodies := lib.extendedBodies()
+	for i, body := range bodies {
+		if wrongCall(body) {
+			return fmt.Errorf("can't pin arity of '%s' to %d: '%s' calls it with other number of arguments",
+				sym, arity, descriptors[i].sym)
+		}
+	}
+	for _, d := range lib.descriptorsByFunCode() {
+		if d.semantics == nil {
+			continue
+		}
+		for _, cond := range []*Expression{d.semantics.pre, d.semantics.post} {
+			if cond != nil && wrongCall(cond) {
+				return fmt.Errorf("can't pin arity of '%s' to %d: semantics annotation of '%s' calls it with other number of arguments",
+					sym, arity, d.sym)
+			}
+		}
+	}
+	fd.requiredNumParams = arity
+	lib.invalidateHash()
+	return nil
+}
@@ -0,0 +1,96 @@
+package easyfl
+
+import (
+	"sync"
+)
+
+type (
+	// BatchItem is one expression in the bytecode form with the values of its parameters
+	BatchItem struct {
+		Bytecode []byte
+		Args     [][]byte
+	}
+
+	// BatchResult is result of evaluation of the BatchItem
+	BatchResult struct {
+		Result []byte
+		Err    error
+	}
+)
+
+// EvalBatch evaluates many expressions in the same global data context. Each distinct bytecode is parsed
+// only once per batch. If numWorkers > 1, items are evaluated in parallel by numWorkers goroutines, so
+// the GlobalData must be safe for concurrent reading. Metered global data is evaluated only with numWorkers <= 1,
+// otherwise each item gets ErrGasMeterConcurrent.
+// Results are returned in the order of items. Never panics
+func (lib *Library) EvalBatch(glb GlobalData, items []BatchItem, numWorkers int) []BatchResult {
+	ret := make([]BatchResult, len(items))
+	if numWorkers > 1 && gasMeterOf(glb) != nil {
+		for i := range ret {
+			ret[i].Err = ErrGasMeterConcurrent
+		}
+		return ret
+	}
+	exprs := make([]*Expression, len(items))
+
+	parsed := make(map[string]*Expression)
+	parseErr := make(map[string]error)
+	for i := range items {
+		key := string(items[i].Bytecode)
+		if expr, found := parsed[key]; found {
+			exprs[i] = expr
+			continue
+		}
+		if err, found := parseErr[key]; found {
+			ret[i].Err = err
+			continue
+		}
+		var expr *Expression
+		err := CatchPanicOrError(func() error {
+			var err error
+			expr, err = lib.ExpressionFromBytecode(items[i].Bytecode)
+			return err
+		})
+		if err != nil {
+			parseErr[key] = err
+			ret[i].Err = err
+			continue
+		}
+		parsed[key] = expr
+		exprs[i] = expr
+	}
+
+	evalItem := func(i int) {
+		if exprs[i] == nil {
+			return
+		}
+		ret[i].Err = CatchPanicOrError(func() error {
+			ret[i].Result = EvalExpression(glb, exprs[i], items[i].Args...)
+			return nil
+		})
+	}
+
+	if numWorkers <= 1 {
+		for i := range items {
+			evalItem(i)
+		}
+		return ret
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				evalItem(i)
+			}
+		}()
+	}
+	for i := range items {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return ret
+}
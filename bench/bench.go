@@ -0,0 +1,195 @@
+// Package bench measures performance of the EasyFL compiler and evaluator on the fixed corpus of scripts.
+// Each script is measured in three phases: compilation of the source, parsing of the bytecode and
+// evaluation of the bytecode. Results of two runs, for example of two releases, are compared with Diff.
+// The same benchmarks run with 'go test -bench . -benchmem' in this package
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+type (
+	// Script is a script of the corpus with arguments of its evaluation
+	Script struct {
+		Name   string
+		Source string
+		Args   [][]byte
+	}
+
+	// Benchmark is one measured phase of one script
+	Benchmark struct {
+		// <phase>/<script name>
+		Name string
+		F    func(b *testing.B)
+	}
+
+	// Result is the measurement of one benchmark
+	Result struct {
+		Name        string `json:"name"`
+		NsPerOp     int64  `json:"ns_per_op"`
+		BytesPerOp  int64  `json:"bytes_per_op"`
+		AllocsPerOp int64  `json:"allocs_per_op"`
+	}
+
+	// Delta compares results of the benchmark in two runs. Ratios are new/old, 1 means no change
+	Delta struct {
+		Name       string
+		Old, New   Result
+		NsRatio    float64
+		BytesRatio float64
+	}
+)
+
+// phases of the benchmarks
+const (
+	PhaseCompile = "compile"
+	PhaseParse   = "parse"
+	PhaseEval    = "eval"
+)
+
+// Corpus is the fixed set of scripts. Scripts are only added to it, so that results of different
+// releases can be compared
+var Corpus = []Script{
+	{Name: "data", Source: "0x0102030405060708"},
+	{Name: "params", Source: "concat($0, $1, $2)", Args: [][]byte{{1}, {2}, {3}}},
+	{Name: "arithmetics", Source: "add(mul(div(u32/27, u16/4), 4), mod(u32/27, 4))"},
+	{Name: "comparison", Source: "and(lessOrEqualThan($0, $1), greaterThan($1, $0), equalUint(max($0, $1), $1))",
+		Args: [][]byte{{1}, {2}}},
+	{Name: "cascade", Source: "firstCaseIndex(equal($0, 1), equal($0, 2), equal($0, 3), equal($0, 4), equal($0, 5))",
+		Args: [][]byte{{5}}},
+	{Name: "nested", Source: "if(equal(len($0), u64/3), concat(slice($0, 0, 1), tail($0, 1), byte($0, 2)), fail(1))",
+		Args: [][]byte{{1, 2, 3}}},
+	{Name: "hash", Source: "equal(blake2b($0), chainHash(nil, $0))", Args: [][]byte{make([]byte, 100)}},
+	{Name: "chain", Source: "validChainLink(chainHash3($0, 1, 2, 3), chainHash2($0, 1, 2), 3)", Args: [][]byte{{0}}},
+	{Name: "dynamic", Source: "eval(bytecode(concat(1, 2)))"},
+}
+
+// Benchmarks returns benchmarks of all phases for all scripts of the corpus. The library must contain
+// the base library
+func Benchmarks(lib *easyfl.Library) ([]Benchmark, error) {
+	ret := make([]Benchmark, 0, 3*len(Corpus))
+	for _, s := range Corpus {
+		s := s
+		_, _, code, err := lib.CompileExpression(s.Source)
+		if err != nil {
+			return nil, fmt.Errorf("bench: script '%s': %v", s.Name, err)
+		}
+		if _, err = lib.EvalFromBytecode(nil, code, s.Args...); err != nil {
+			return nil, fmt.Errorf("bench: script '%s': %v", s.Name, err)
+		}
+		ret = append(ret,
+			Benchmark{Name: PhaseCompile + "/" + s.Name, F: func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _, _, _ = lib.CompileExpression(s.Source)
+				}
+			}},
+			Benchmark{Name: PhaseParse + "/" + s.Name, F: func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _ = lib.ExpressionFromBytecode(code)
+				}
+			}},
+			Benchmark{Name: PhaseEval + "/" + s.Name, F: func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					_, _ = lib.EvalFromBytecode(nil, code, s.Args...)
+				}
+			}},
+		)
+	}
+	return ret, nil
+}
+
+// Run runs all benchmarks with testing.Benchmark
+func Run(lib *easyfl.Library) ([]Result, error) {
+	benchmarks, err := Benchmarks(lib)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Result, len(benchmarks))
+	for i, bm := range benchmarks {
+		r := testing.Benchmark(bm.F)
+		ret[i] = Result{
+			Name:        bm.Name,
+			NsPerOp:     r.NsPerOp(),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+			AllocsPerOp: r.AllocsPerOp(),
+		}
+	}
+	return ret, nil
+}
+
+// WriteResults writes results in JSON
+func WriteResults(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// ReadResults reads results written by WriteResults
+func ReadResults(r io.Reader) ([]Result, error) {
+	var ret []Result
+	if err := json.NewDecoder(r).Decode(&ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Diff compares results of the benchmarks present in both runs. Deltas are sorted by name
+func Diff(oldResults, newResults []Result) []Delta {
+	old := make(map[string]Result)
+	for _, r := range oldResults {
+		old[r.Name] = r
+	}
+	ret := make([]Delta, 0)
+	for _, r := range newResults {
+		o, found := old[r.Name]
+		if !found {
+			continue
+		}
+		ret = append(ret, Delta{
+			Name:       r.Name,
+			Old:        o,
+			New:        r,
+			NsRatio:    ratio(r.NsPerOp, o.NsPerOp),
+			BytesRatio: ratio(r.BytesPerOp, o.BytesPerOp),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// Regressions returns deltas where time or memory per operation grew by more than the threshold,
+// for example 0.1 means 10%
+func Regressions(deltas []Delta, threshold float64) []Delta {
+	ret := make([]Delta, 0)
+	for _, d := range deltas {
+		if d.NsRatio > 1+threshold || d.BytesRatio > 1+threshold {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func (d Delta) String() string {
+	return fmt.Sprintf("%s: %d -> %d ns/op (%+.1f%%), %d -> %d B/op (%+.1f%%)", d.Name,
+		d.Old.NsPerOp, d.New.NsPerOp, 100*(d.NsRatio-1),
+		d.Old.BytesPerOp, d.New.BytesPerOp, 100*(d.BytesRatio-1))
+}
+
+// ratio is new/old. Growth from 0 is infinite, no change from 0 is 1
+func ratio(n, o int64) float64 {
+	if o == 0 {
+		if n == 0 {
+			return 1
+		}
+		return float64(n + 1)
+	}
+	return float64(n) / float64(o)
+}
@@ -0,0 +1,72 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBenchmarks(t *testing.T) {
+	lib := easyfl.NewBase()
+	benchmarks, err := Benchmarks(lib)
+	require.NoError(t, err)
+	require.EqualValues(t, 3*len(Corpus), len(benchmarks))
+	names := make(map[string]bool)
+	for _, bm := range benchmarks {
+		require.False(t, names[bm.Name], bm.Name)
+		names[bm.Name] = true
+	}
+	require.True(t, names["eval/cascade"])
+
+	// corpus scripts need the base library
+	_, err = Benchmarks(easyfl.New())
+	require.Error(t, err)
+}
+
+func TestDiff(t *testing.T) {
+	oldResults := []Result{
+		{Name: "eval/a", NsPerOp: 100, BytesPerOp: 64, AllocsPerOp: 2},
+		{Name: "eval/b", NsPerOp: 100, BytesPerOp: 0},
+		{Name: "eval/removed", NsPerOp: 100},
+	}
+	newResults := []Result{
+		{Name: "eval/b", NsPerOp: 105, BytesPerOp: 32, AllocsPerOp: 1},
+		{Name: "eval/a", NsPerOp: 90, BytesPerOp: 64, AllocsPerOp: 2},
+		{Name: "eval/added", NsPerOp: 100},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteResults(&buf, oldResults))
+	back, err := ReadResults(&buf)
+	require.NoError(t, err)
+	require.EqualValues(t, oldResults, back)
+
+	deltas := Diff(oldResults, newResults)
+	require.EqualValues(t, 2, len(deltas))
+	require.EqualValues(t, "eval/a", deltas[0].Name)
+	require.InDelta(t, 0.9, deltas[0].NsRatio, 1e-9)
+	require.InDelta(t, 1, deltas[0].BytesRatio, 1e-9)
+	require.EqualValues(t, "eval/b", deltas[1].Name)
+	require.InDelta(t, 1.05, deltas[1].NsRatio, 1e-9)
+	// allocation from nothing is a regression
+	require.True(t, deltas[1].BytesRatio > 1)
+	t.Logf("%s", deltas[0])
+	require.True(t, strings.Contains(deltas[0].String(), "100 -> 90 ns/op (-10.0%)"))
+
+	require.EqualValues(t, 1, len(Regressions(deltas, 0.1)))
+	require.EqualValues(t, "eval/b", Regressions(deltas, 0.1)[0].Name)
+	require.EqualValues(t, 0, len(Regressions(Diff(oldResults, oldResults), 0)))
+}
+
+func BenchmarkCorpus(b *testing.B) {
+	benchmarks, err := Benchmarks(easyfl.NewBase())
+	require.NoError(b, err)
+	for _, bm := range benchmarks {
+		b.Run(bm.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			bm.F(b)
+		})
+	}
+}
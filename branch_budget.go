@@ -0,0 +1,46 @@
+package easyfl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBranchBudget is returned when one evaluation evaluates more failed branches of 'or', 'firstCaseIndex'
+// and 'cond' than allowed by the library. Failed branch is an evaluated argument-condition which is false
+type ErrBranchBudget struct {
+	Limit int
+	// correlation ID of the evaluation, if any. See Correlated
+	CorrelationID string
+}
+
+func (e *ErrBranchBudget) Error() string {
+	return prefixCorrelationID(e.CorrelationID, fmt.Sprintf("more than %d failed branches evaluated", e.Limit))
+}
+
+// SetMaxFailedBranches limits number of failed branches evaluated by 'or', 'firstCaseIndex' and 'cond'
+// within one evaluation. It bounds worst-case work of scripts structured as long case cascades.
+// 0 means no limit
+func (lib *Library) SetMaxFailedBranches(n int) {
+	lib.maxFailedBranches = n
+}
+
+// countFailedBranch counts the failed branch and panics when the budget is exhausted
+func (lib *Library) countFailedBranch(par *CallParams) {
+	if lib.maxFailedBranches <= 0 {
+		return
+	}
+	st := par.ctx.state
+	st.failedBranches++
+	if st.failedBranches > lib.maxFailedBranches {
+		panic(&ErrBranchBudget{Limit: lib.maxFailedBranches, CorrelationID: st.correlationID})
+	}
+}
+
+// panicIfBranchBudget propagates exhausted budget of dynamically evaluated bytecode as is, so that it is not
+// wrapped by each nested level
+func panicIfBranchBudget(err error) {
+	var errBudget *ErrBranchBudget
+	if errors.As(err, &errBudget) {
+		panic(err)
+	}
+}
@@ -0,0 +1,81 @@
+package easyfl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+type (
+	// ScriptBundle is a human-readable description of the script in the bytecode form
+	ScriptBundle struct {
+		LibraryHash [32]byte
+		Bytecode    []byte
+		Source      string
+		// all sub-expressions, in the depth-first order
+		Breakdown []BundleNode
+		Functions []FunctionInfo
+	}
+
+	// BundleNode is a sub-expression of the script
+	BundleNode struct {
+		Depth    int
+		Source   string
+		Bytecode []byte
+	}
+)
+
+// DecompileToBundle decompiles the bytecode into the bundle
+func (lib *Library) DecompileToBundle(code []byte) (*ScriptBundle, error) {
+	expr, err := lib.ExpressionFromBytecode(code)
+	if err != nil {
+		return nil, err
+	}
+	functions, err := lib.UsedFunctions(code)
+	if err != nil {
+		return nil, err
+	}
+	ret := &ScriptBundle{
+		LibraryHash: lib.LibraryHash(),
+		Bytecode:    code,
+		Source:      ExpressionToSource(expr),
+		Breakdown:   make([]BundleNode, 0),
+		Functions:   functions,
+	}
+	ret.addNodes(expr, 0)
+	return ret, nil
+}
+
+func (b *ScriptBundle) addNodes(expr *Expression, depth int) {
+	b.Breakdown = append(b.Breakdown, BundleNode{
+		Depth:    depth,
+		Source:   ExpressionToSource(expr),
+		Bytecode: ExpressionToBytecode(expr),
+	})
+	for _, arg := range expr.Args {
+		b.addNodes(arg, depth+1)
+	}
+}
+
+// YAML returns bundle in YAML form
+func (b *ScriptBundle) YAML() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "library_hash: %s\n", hex.EncodeToString(b.LibraryHash[:]))
+	fmt.Fprintf(&buf, "bytecode: %s\n", hex.EncodeToString(b.Bytecode))
+	fmt.Fprintf(&buf, "source: %s\n", strconv.Quote(b.Source))
+	buf.WriteString("breakdown:\n")
+	for _, n := range b.Breakdown {
+		fmt.Fprintf(&buf, "  - depth: %d\n", n.Depth)
+		fmt.Fprintf(&buf, "    source: %s\n", strconv.Quote(n.Source))
+		fmt.Fprintf(&buf, "    bytecode: %s\n", hex.EncodeToString(n.Bytecode))
+	}
+	buf.WriteString("functions:\n")
+	for _, fi := range b.Functions {
+		fmt.Fprintf(&buf, "  - sym: %s\n", strconv.Quote(fi.Sym))
+		fmt.Fprintf(&buf, "    funCode: %d\n", fi.FunCode)
+		fmt.Fprintf(&buf, "    numParams: %d\n", fi.NumParams)
+		fmt.Fprintf(&buf, "    embedded: %v\n", fi.IsEmbedded)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,94 @@
+package easyfl
+
+import (
+	"fmt"
+)
+
+// Bytecode parameters.
+// '$$i' in the body of extended function is the bytecode of the i-th argument of the call, as it is written
+// in the caller's bytecode, instead of its value. The argument is not evaluated.
+// If the argument is itself a parameter reference of the caller, for example 'f: bytecode($0)', its
+// bytecode is not known inside the body: it is a value passed from outside. Such calls are rejected by the compiler.
+// Embedded functions take bytecode of their arguments with CallParams.BytecodeArg.
+//
+// Arguments of the evaluation itself, passed to EvalFromSource, EvalFromBytecode, 'eval' and 'applyN', are values
+// evaluated before it, so their bytecode is not known either. '$$i' of such argument is the inline data of the value,
+// or nil if the value is too long for the inline data. EvalFromSource rejects sources which take bytecode of arguments.
+// The compiler can't reject them in general, because the same source compiled with CompileExpression may be the body
+// of the function. Instead, it rejects the call which passes the parameter reference as the argument taken with '$$i':
+// that is the only way the value can reach '$$i' in the body of the function
+
+// BytecodeArg returns bytecode of the n-th argument of the call, as it is written in the bytecode.
+// The argument is not evaluated. Panics if the argument is a parameter reference, because
+// its bytecode is not known
+func (p *CallParams) BytecodeArg(n byte) []byte {
+	if n >= p.Arity() {
+		p.TracePanic("BytecodeArg: argument index %d is out of range, arity is %d", n, p.Arity())
+	}
+	if p.direct.d != nil {
+		fragment := p.direct.d.fragment(p.direct.argPos(n))
+		if isParameterReference(fragment) {
+			p.TracePanic("BytecodeArg: argument %d is parameter reference '%s', its bytecode is not known", n, paramRefs[fragment[0]].sym)
+		}
+		return fragment
+	}
+	arg := p.args[n]
+	if isParameterReference(arg.CallPrefix) {
+		p.TracePanic("BytecodeArg: argument %d is parameter reference '%s', its bytecode is not known", n, arg.FunctionName)
+	}
+	if IsDataPrefix(arg.CallPrefix) {
+		return arg.CallPrefix
+	}
+	return arg.EvalFunc.bytecode
+}
+
+func isParameterReference(callPrefix []byte) bool {
+	return len(callPrefix) == 1 && callPrefix[0] <= LastEmbeddedReserved
+}
+
+// bytecodeParamsOf returns bit mask of parameters referenced as '$$i' in the expression
+func bytecodeParamsOf(expr *Expression) uint16 {
+	if isParameterReference(expr.CallPrefix) {
+		if expr.CallPrefix[0]&BytecodeParameterFlag != 0 {
+			return 1 << (expr.CallPrefix[0] &^ BytecodeParameterFlag)
+		}
+		return 0
+	}
+	var ret uint16
+	for _, arg := range expr.Args {
+		ret |= bytecodeParamsOf(arg)
+	}
+	return ret
+}
+
+// checkNoBytecodeParams checks that the expression, evaluated with values of arguments, does not take their bytecode
+func checkNoBytecodeParams(expr *Expression) error {
+	params := bytecodeParamsOf(expr)
+	for i := 0; params != 0; i++ {
+		if params&(1<<i) != 0 {
+			return fmt.Errorf("$$%d can't be used in the expression evaluated with values of arguments: bytecode of the argument is not known", i)
+		}
+	}
+	return nil
+}
+
+// checkBytecodeArgs checks that arguments, bytecode of which is taken by the called function, are not parameter references
+func (lib *Library) checkBytecodeArgs(expr *Expression, localLib ...*LocalLibrary) error {
+	if IsDataPrefix(expr.CallPrefix) || isParameterReference(expr.CallPrefix) {
+		return nil
+	}
+	if fd := lib.descriptorOfCall(expr.CallPrefix, localLib...); fd != nil && fd.bytecodeParams != 0 {
+		for i, arg := range expr.Args {
+			if fd.bytecodeParams&(1<<i) != 0 && isParameterReference(arg.CallPrefix) {
+				return fmt.Errorf("'%s' takes bytecode of the argument %d with $$%d, it can't be parameter reference '%s'",
+					fd.sym, i, i, arg.FunctionName)
+			}
+		}
+	}
+	for _, arg := range expr.Args {
+		if err := lib.checkBytecodeArgs(arg, localLib...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package easyfl
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// CanonicalBytecode re-encodes the bytecode the way the compiler would produce it. The parser accepts
+// some non-canonical forms, for example long call prefix of a short embedded function. Inline data and
+// parameter references have only one encoding, so they are left as is
+func (lib *Library) CanonicalBytecode(code []byte) ([]byte, error) {
+	expr, err := lib.ExpressionFromBytecode(code)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = lib.writeCanonicalBytecode(&buf, expr); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (lib *Library) writeCanonicalBytecode(w io.Writer, expr *Expression) error {
+	prefix := expr.CallPrefix
+	isParam := len(prefix) == 1 && prefix[0] <= LastEmbeddedReserved
+	if !IsDataPrefix(prefix) && !isParam {
+		fi, err := lib.functionByName(expr.FunctionName)
+		if err != nil {
+			return err
+		}
+		if prefix, err = fi.callPrefix(byte(len(expr.Args))); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	for _, arg := range expr.Args {
+		if err := lib.writeCanonicalBytecode(w, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BytecodeID is a hash of the canonical form of the bytecode. Bytecodes with the same ID are the same script
+func (lib *Library) BytecodeID(code []byte) ([32]byte, error) {
+	canonical, err := lib.CanonicalBytecode(code)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return blake2b.Sum256(canonical), nil
+}
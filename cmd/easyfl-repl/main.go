@@ -0,0 +1,40 @@
+// Command easyfl-repl evaluates EasyFL expressions interactively.
+// Each line is an expression or a command. For the expression it prints the bytecode, the source decompiled
+// from the bytecode, the trace, if enabled, and the result of the evaluation.
+// Extended functions are loaded from the file in the format of Library.ExtendMany:
+//
+//	easyfl-repl -lib functions.easyfl
+//
+// Type ':help' for the list of commands
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+func main() {
+	libFile := flag.String("lib", "", "file with definitions of extended functions, in the format of Library.ExtendMany")
+	trace := flag.Bool("trace", false, "print trace of each evaluation")
+	flag.Parse()
+
+	lib := easyfl.NewBase()
+	if *libFile != "" {
+		source, err := os.ReadFile(*libFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't read library: %v\n", err)
+			os.Exit(1)
+		}
+		if err = lib.ExtendMany(string(source)); err != nil {
+			fmt.Fprintf(os.Stderr, "can't load library %s: %v\n", *libFile, err)
+			os.Exit(1)
+		}
+	}
+	r := newREPL(lib, os.Stdout)
+	r.trace = *trace
+	r.prompt = "> "
+	r.run(os.Stdin)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+const helpText = `expression         compile and evaluate the expression with the arguments
+:args [hex ...]    set arguments $0, $1, ... of the expressions. Without hex data clears them
+:trace on|off      print trace of the evaluation
+:decompile hex     decompile the bytecode
+:help              print this help
+:quit              exit
+`
+
+type repl struct {
+	lib    *easyfl.Library
+	out    io.Writer
+	prompt string
+	trace  bool
+	args   [][]byte
+}
+
+func newREPL(lib *easyfl.Library, out io.Writer) *repl {
+	return &repl{lib: lib, out: out}
+}
+
+// run reads lines from in until ':quit' or the end of the input
+func (r *repl) run(in io.Reader) {
+	sc := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(r.out, r.prompt)
+		if !sc.Scan() {
+			return
+		}
+		if !r.line(strings.TrimSpace(sc.Text())) {
+			return
+		}
+	}
+}
+
+// line processes one line of the input. Returns false on ':quit'
+func (r *repl) line(s string) bool {
+	if s == "" {
+		return true
+	}
+	if !strings.HasPrefix(s, ":") {
+		r.eval(s)
+		return true
+	}
+	cmd, rest, _ := strings.Cut(s, " ")
+	rest = strings.TrimSpace(rest)
+	switch cmd {
+	case ":quit", ":q":
+		return false
+	case ":help":
+		fmt.Fprint(r.out, helpText)
+	case ":trace":
+		switch rest {
+		case "on":
+			r.trace = true
+		case "off":
+			r.trace = false
+		default:
+			fmt.Fprintf(r.out, "error: expected ':trace on' or ':trace off'\n")
+		}
+	case ":args":
+		args := make([][]byte, 0)
+		for _, a := range strings.Fields(rest) {
+			data, err := parseHex(a)
+			if err != nil {
+				fmt.Fprintf(r.out, "error: wrong argument '%s': %v\n", a, err)
+				return true
+			}
+			args = append(args, data)
+		}
+		r.args = args
+	case ":decompile":
+		code, err := parseHex(rest)
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+			return true
+		}
+		src, err := r.lib.DecompileBytecode(code)
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+			return true
+		}
+		fmt.Fprintf(r.out, "source:   %s\n", src)
+	default:
+		fmt.Fprintf(r.out, "error: unknown command '%s'. Type ':help'\n", cmd)
+	}
+	return true
+}
+
+func (r *repl) eval(source string) {
+	_, numParams, code, warnings, err := r.lib.CompileExpressionWithWarnings(source)
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(r.out, "warning: %s\n", w.Message)
+	}
+	fmt.Fprintf(r.out, "bytecode: %s\n", hex.EncodeToString(code))
+	if src, err := r.lib.DecompileBytecode(code); err == nil {
+		fmt.Fprintf(r.out, "source:   %s\n", src)
+	}
+	if numParams > len(r.args) {
+		fmt.Fprintf(r.out, "error: expression takes %d arguments, %d are set with ':args'\n", numParams, len(r.args))
+		return
+	}
+	var glb easyfl.GlobalData
+	var log *easyfl.GlobalDataLog
+	if r.trace {
+		log = easyfl.NewGlobalDataLog(nil)
+		glb = log
+	}
+	res, err := r.lib.EvalFromBytecode(glb, code, r.args[:numParams]...)
+	if log != nil {
+		for _, s := range log.Log() {
+			fmt.Fprintf(r.out, "trace:    %s\n", s)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(r.out, "result:   0x%s\n", hex.EncodeToString(res))
+}
+
+func parseHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+}
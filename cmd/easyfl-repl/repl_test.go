@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/stretchr/testify/require"
+)
+
+func session(t *testing.T, lib *easyfl.Library, input string) string {
+	var out bytes.Buffer
+	newREPL(lib, &out).run(strings.NewReader(input))
+	return out.String()
+}
+
+func TestREPL(t *testing.T) {
+	lib := easyfl.NewBase()
+	require.NoError(t, lib.ExtendMany("func cat3: concat($0, $1, $2)"))
+
+	out := session(t, lib, "cat3(1, 2, 0x03)\n")
+	require.Contains(t, out, "source:   cat3(1,2,3)")
+	require.Contains(t, out, "result:   0x010203")
+	require.NotContains(t, out, "trace:")
+
+	out = session(t, lib, ":trace on\nconcat(1, 2)\n")
+	require.Contains(t, out, "trace:")
+	require.Contains(t, out, "result:   0x0102")
+
+	out = session(t, lib, "concat($0, $1)\n:args 0x01 02\nconcat($0, $1)\n:args\nconcat($0)\n")
+	require.Equal(t, 2, strings.Count(out, "error: expression takes"))
+	require.Contains(t, out, "result:   0x0102")
+
+	out = session(t, lib, ":decompile 0x8101\nnoSuchFunction(1)\n:what\n:quit\nconcat(1)\n")
+	require.Contains(t, out, "source:   1")
+	require.Contains(t, out, "error: ")
+	require.Contains(t, out, "unknown command ':what'")
+	require.NotContains(t, out, "result:")
+
+	out = session(t, lib, "concat($0)\n:args 0x01\nconcat($0, 0x)\n")
+	require.Contains(t, out, "warning: ")
+	out = session(t, lib, "fail(1)\n")
+	require.Contains(t, out, "error: ")
+}
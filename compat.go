@@ -0,0 +1,98 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type (
+	// CompatReport is the result of checking corpus of bytecodes against two versions of the library
+	CompatReport struct {
+		Items []CompatItem
+		// number of items which differ or fail in the new version
+		NumIncompatible int
+	}
+
+	// CompatItem is the check result of one bytecode of the corpus
+	CompatItem struct {
+		Index     int
+		OldSource string
+		NewSource string
+		// not empty if bytecode is not compatible. Contains the reason
+		Problem string
+		// true if the bytecode was evaluated with both libraries
+		Evaluated bool
+	}
+)
+
+// CheckBytecodeCompat checks if each bytecode of the corpus decompiles to the same source with the new library
+// as with the old one. If evaluate == true, bytecodes without parameters are also evaluated with both libraries
+// and results, including failures, must be the same. Bytecodes which do not decompile with the old library
+// are an error, because the corpus is expected to be valid
+func CheckBytecodeCompat(oldLib, newLib *Library, corpus [][]byte, evaluate bool) (*CompatReport, error) {
+	ret := &CompatReport{
+		Items: make([]CompatItem, len(corpus)),
+	}
+	for i, code := range corpus {
+		item := &ret.Items[i]
+		item.Index = i
+		var err error
+		if item.OldSource, err = oldLib.DecompileBytecode(code); err != nil {
+			return nil, fmt.Errorf("CheckBytecodeCompat: corpus item #%d is not valid with the old library: %v", i, err)
+		}
+		if item.NewSource, err = newLib.DecompileBytecode(code); err != nil {
+			item.Problem = fmt.Sprintf("can't decompile: %v", err)
+		} else if item.OldSource != item.NewSource {
+			item.Problem = "decompiles differently"
+		} else if evaluate && !oldLib.usesParameters(code) {
+			item.Evaluated = true
+			item.Problem = compareEvaluation(oldLib, newLib, code)
+		}
+		if item.Problem != "" {
+			ret.NumIncompatible++
+		}
+	}
+	return ret, nil
+}
+
+// usesParameters returns true if the bytecode can't be evaluated without arguments
+func (lib *Library) usesParameters(code []byte) bool {
+	_, _, maxParam, err := lib.expressionFromBytecode(code)
+	return err != nil || maxParam != 0xff
+}
+
+func compareEvaluation(oldLib, newLib *Library, code []byte) string {
+	resOld, errOld := oldLib.EvalFromBytecode(nil, code)
+	resNew, errNew := newLib.EvalFromBytecode(nil, code)
+	switch {
+	case errOld != nil && errNew != nil:
+		if errOld.Error() != errNew.Error() {
+			return fmt.Sprintf("fails differently: '%v' vs '%v'", errOld, errNew)
+		}
+	case errOld != nil:
+		return fmt.Sprintf("fails only with the old library: %v", errOld)
+	case errNew != nil:
+		return fmt.Sprintf("fails only with the new library: %v", errNew)
+	case !bytes.Equal(resOld, resNew):
+		return fmt.Sprintf("results differ: %s vs %s", Fmt(resOld), Fmt(resNew))
+	}
+	return ""
+}
+
+// OK returns true if all items are compatible
+func (r *CompatReport) OK() bool {
+	return r.NumIncompatible == 0
+}
+
+func (r *CompatReport) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d bytecodes checked, %d incompatible\n", len(r.Items), r.NumIncompatible)
+	for _, item := range r.Items {
+		if item.Problem == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "#%d '%s': %s\n", item.Index, item.OldSource, item.Problem)
+	}
+	return buf.String()
+}
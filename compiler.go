@@ -18,6 +18,8 @@ import (
 type funParsed struct {
 	Sym        string
 	SourceCode string
+	// line of the function definition in the source, starting from 1
+	Line int
 }
 
 // parsedExpression interim representation of the parsed expression
@@ -57,18 +59,19 @@ func parseDefs(lines []string) ([]*funParsed, error) {
 			}
 			sym, body, found := strings.Cut(strings.TrimPrefix(line, "func "), ":")
 			if !found {
-				return nil, fmt.Errorf("':' expectected @ line %d", lineno)
+				return nil, fmt.Errorf("':' expectected @ line %d", lineno+1)
 			}
 			current = &funParsed{
 				Sym:        strings.TrimSpace(sym),
 				SourceCode: body,
+				Line:       lineno + 1,
 			}
 		} else {
 			if len(stripSpaces(line)) == 0 {
 				continue
 			}
 			if current == nil {
-				return nil, fmt.Errorf("unexpectected symbols @ line %d", lineno)
+				return nil, fmt.Errorf("unexpectected symbols @ line %d", lineno+1)
 			}
 			current.SourceCode += line
 		}
@@ -183,10 +186,45 @@ const (
 	FirstByteLongCallMask      = byte(0x01) << 6
 	FirstByteLongCallArityMask = byte(0x0f) << 2
 	Uint16LongCallCodeMask     = ^(uint16(FirstByteDataMask|FirstByteLongCallMask|FirstByteLongCallArityMask) << 8)
+	// MaxInlineDataSize is the maximal length of inline data in the bytecode
+	MaxInlineDataSize = int(FirstByteDataLenMask)
 )
 
+// Helpers for reading fields of the call prefix. The prefix must be a valid call prefix,
+// for example the one returned by ParseBytecodeOneLevel
+
+// IsLongCall returns true if the prefix is a long (2 or more bytes) call prefix
+func IsLongCall(prefix []byte) bool {
+	return len(prefix) > 0 && prefix[0]&FirstByteDataMask == 0 && prefix[0]&FirstByteLongCallMask != 0
+}
+
+// ArityFromPrefix returns number of arguments encoded in the long call prefix.
+// Arity of short calls is known only to the library, -1 is returned for them
+func ArityFromPrefix(prefix []byte) int {
+	if !IsLongCall(prefix) {
+		return -1
+	}
+	return int((prefix[0] & FirstByteLongCallArityMask) >> 2)
+}
+
+// FunCodeFromPrefix returns function code of the call. For short calls it is the first byte, i.e. parameter
+// references are codes 0-15. For local library calls it is FirstLocalFunCode plus the local function index
+func FunCodeFromPrefix(prefix []byte) uint16 {
+	if !IsLongCall(prefix) {
+		return uint16(prefix[0])
+	}
+	ret := binary.BigEndian.Uint16(prefix[:2]) & Uint16LongCallCodeMask
+	if ret == FirstLocalFunCode {
+		if idx, _, err := parseLocalFunIndex(prefix[2:]); err == nil {
+			ret += idx
+		}
+	}
+	return ret
+}
+
 // bytecodeFromParsedExpression takes parsed expression and generates bytecode of it
-func (f *parsedExpression) bytecodeFromParsedExpression(lib *Library, w io.Writer, localLib ...*LocalLibrary) (int, error) {
+// Internal library functions can be called only if allowInternal == true, i.e. from other library functions
+func (f *parsedExpression) bytecodeFromParsedExpression(lib *Library, w io.Writer, allowInternal bool, localLib ...*LocalLibrary) (int, error) {
 	numArgs := 0
 	if len(f.params) == 0 {
 		isLiteral, nArgs, err := parseLiteral(lib, f.sym, w)
@@ -203,6 +241,9 @@ func (f *parsedExpression) bytecodeFromParsedExpression(lib *Library, w io.Write
 	if err != nil {
 		return 0, err
 	}
+	if fi.IsInternal && !allowInternal {
+		return 0, fmt.Errorf("internal library function can't be called outside the library: '%s'", f.sym)
+	}
 	if fi.NumParams >= 0 && fi.NumParams != len(f.params) {
 		return 0, fmt.Errorf("%d arguments required, got %d: '%s'", fi.NumParams, len(f.params), f.sym)
 	}
@@ -218,7 +259,7 @@ func (f *parsedExpression) bytecodeFromParsedExpression(lib *Library, w io.Write
 	// generate code for call parameters
 	var n int
 	for _, ff := range f.params {
-		if n, err = ff.bytecodeFromParsedExpression(lib, w, localLib...); err != nil {
+		if n, err = ff.bytecodeFromParsedExpression(lib, w, allowInternal, localLib...); err != nil {
 			return 0, err
 		}
 		if n > numArgs {
@@ -229,7 +270,7 @@ func (f *parsedExpression) bytecodeFromParsedExpression(lib *Library, w io.Write
 }
 
 func writeDataWithPrefix(w io.Writer, data []byte) error {
-	if len(data) > 127 {
+	if len(data) > MaxInlineDataSize {
 		return errors.New("too long inline data")
 	}
 	_, err := w.Write([]byte{FirstByteDataMask | byte(len(data))})
@@ -240,6 +281,16 @@ func writeDataWithPrefix(w io.Writer, data []byte) error {
 	return err
 }
 
+// InlineDataBytecode makes bytecode of the inline data, which evaluates to the data. Hosts use it to
+// splice constants into the bytecode of scripts. Data is up to MaxInlineDataSize bytes long
+func InlineDataBytecode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeDataWithPrefix(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func mustDataWithPrefix(data []byte) []byte {
 	var buf bytes.Buffer
 	err := writeDataWithPrefix(&buf, data)
@@ -272,7 +323,8 @@ func parseLiteral(lib *Library, sym string, w io.Writer) (bool, int, error) {
 		if err != nil {
 			return false, 0, err
 		}
-		if n < 0 || n > MaxParameters {
+		// '$$8' would be encoded as the call of the first short embedded function. See ParsingVectors
+		if n < 0 || n >= MaxParameters {
 			return false, 0, fmt.Errorf("wrong bytecode parameter reference '%s'", sym)
 		}
 		if _, err = w.Write([]byte{BytecodeParameterFlag | byte(n)}); err != nil {
@@ -285,7 +337,7 @@ func parseLiteral(lib *Library, sym string, w io.Writer) (bool, int, error) {
 		if err != nil {
 			return false, 0, err
 		}
-		if n < 0 || n > MaxParameters {
+		if n < 0 || n >= MaxParameters {
 			return false, 0, fmt.Errorf("wrong eval parameter reference '%s'", sym)
 		}
 		if _, err = w.Write([]byte{byte(n)}); err != nil {
@@ -407,21 +459,30 @@ func parseLiteral(lib *Library, sym string, w io.Writer) (bool, int, error) {
 
 // ExpressionSourceToBytecode compiles expression from source form into the canonical bytecode representation
 func (lib *Library) ExpressionSourceToBytecode(formulaSource string, localLib ...*LocalLibrary) ([]byte, int, error) {
+	return lib.expressionSourceToBytecode(formulaSource, false, localLib...)
+}
+
+func (lib *Library) expressionSourceToBytecode(formulaSource string, allowInternal bool, localLib ...*LocalLibrary) ([]byte, int, error) {
 	f, err := parseExpression(formulaSource)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	var buf bytes.Buffer
-	numArgs, err := f.bytecodeFromParsedExpression(lib, &buf, localLib...)
+	numArgs, err := f.bytecodeFromParsedExpression(lib, &buf, allowInternal, localLib...)
 	if err != nil {
 		return nil, 0, err
 	}
 	return buf.Bytes(), numArgs, nil
 }
 
-// ExpressionFromBytecode creates evaluation form of the expression from its canonical representation
+// ExpressionFromBytecode creates evaluation form of the expression from its canonical representation.
+// Metadata headers are skipped
 func (lib *Library) ExpressionFromBytecode(code []byte, localLib ...*LocalLibrary) (*Expression, error) {
+	code, err := StripMetadata(code)
+	if err != nil {
+		return nil, err
+	}
 	ret, remaining, _, err := lib.expressionFromBytecode(code, localLib...)
 	if err != nil {
 		return nil, err
@@ -460,7 +521,14 @@ func writeExpressionBytecode(w io.Writer, expr *Expression) error {
 }
 
 func writeExpressionSource(w io.Writer, f *Expression) error {
-	if _, err := w.Write([]byte(f.FunctionName)); err != nil {
+	return writeExpressionSourceWithNames(w, f, func(f *Expression) string {
+		return f.FunctionName
+	})
+}
+
+// writeExpressionSourceWithNames writes source with names of functions provided by the callback
+func writeExpressionSourceWithNames(w io.Writer, f *Expression, name func(f *Expression) string) error {
+	if _, err := w.Write([]byte(name(f))); err != nil {
 		return err
 	}
 	if len(f.Args) > 0 {
@@ -475,7 +543,7 @@ func writeExpressionSource(w io.Writer, f *Expression) error {
 				return err
 			}
 		}
-		if err := writeExpressionSource(w, arg); err != nil {
+		if err := writeExpressionSourceWithNames(w, arg, name); err != nil {
 			return err
 		}
 		first = false
@@ -504,12 +572,12 @@ func (lib *Library) expressionFromBytecode(bytecode []byte, localLib ...*LocalLi
 		case 0:
 			sym = "nil"
 		case 1:
-			sym = fmt.Sprintf("%d", dataPrefix[1])
+			sym = strconv.Itoa(int(dataPrefix[1]))
 		default:
-			sym = fmt.Sprintf("0x%s", hex.EncodeToString(dataPrefix[1:]))
+			sym = "0x" + hex.EncodeToString(dataPrefix[1:])
 		}
 		ret := &Expression{
-			EvalFunc:     dataFunction(dataPrefix[1:]),
+			EvalFunc:     prefixedDataFunction(dataPrefix),
 			FunctionName: sym,
 			CallPrefix:   dataPrefix,
 		}
@@ -532,7 +600,7 @@ func (lib *Library) expressionFromBytecode(bytecode []byte, localLib ...*LocalLi
 	Assert(arity >= 0, "EasyFL: arity >= 0")
 
 	ret := &Expression{
-		Args:         make([]*Expression, 0),
+		Args:         make([]*Expression, 0, arity),
 		FunctionName: sym,
 		CallPrefix:   callPrefix,
 	}
@@ -554,13 +622,22 @@ func (lib *Library) expressionFromBytecode(bytecode []byte, localLib ...*LocalLi
 		ret.Args = append(ret.Args, p)
 	}
 	ret.EvalFunc = evalFun
+	if ret.EvalFunc.bytecode != nil {
+		// bytecode of the call, without bytecode of the following siblings. It is taken by $$i.
+		// Consensus-visible, see BytecodeParameterVectors
+		ret.EvalFunc.bytecode = ret.EvalFunc.bytecode[:len(ret.EvalFunc.bytecode)-len(bytecode)]
+	}
 	return ret, bytecode, maxParameterNumber, nil
 }
 
 // CompileExpression compiles from sources directly into the evaluation form
 func (lib *Library) CompileExpression(source string, localLib ...*LocalLibrary) (*Expression, int, []byte, error) {
+	return lib.compileExpression(source, false, localLib...)
+}
+
+func (lib *Library) compileExpression(source string, allowInternal bool, localLib ...*LocalLibrary) (*Expression, int, []byte, error) {
 	src := strings.Join(splitLinesStripComments(source), "")
-	bytecode, numParams, err := lib.ExpressionSourceToBytecode(stripSpaces(src), localLib...)
+	bytecode, numParams, err := lib.expressionSourceToBytecode(stripSpaces(src), allowInternal, localLib...)
 	if err != nil {
 		return nil, 0, nil, err
 	}
@@ -568,11 +645,21 @@ func (lib *Library) CompileExpression(source string, localLib ...*LocalLibrary)
 	if err != nil {
 		return nil, 0, nil, err
 	}
+	if err = lib.checkBytecodeArgs(ret, localLib...); err != nil {
+		return nil, 0, nil, err
+	}
 	return ret, numParams, bytecode, nil
 }
 
 // DecompileBytecode decompiles canonical bytecode into source. Symbols are restored wherever possible
 func (lib *Library) DecompileBytecode(code []byte) (string, error) {
+	if lib.decompileCache != nil {
+		d, err := lib.DecompileCached(code)
+		if err != nil {
+			return "", err
+		}
+		return d.Source, nil
+	}
 	f, err := lib.ExpressionFromBytecode(code)
 	if err != nil {
 		return "", err
@@ -580,14 +667,71 @@ func (lib *Library) DecompileBytecode(code []byte) (string, error) {
 	return ExpressionToSource(f), nil
 }
 
+// DecompileBytecodeWithNames decompiles bytecode with display names of functions, provided by the host, for example
+// 'amount' for the generic accessor. Names map function codes to display names, functions not in the map keep
+// library names. The source is for showing to the end users: it can't be compiled back unless display names are
+// library names. Display name of the function can't be a library name of another function
+func (lib *Library) DecompileBytecodeWithNames(code []byte, names map[uint16]string) (string, error) {
+	for funCode, name := range names {
+		if funCode >= FirstLocalFunCode || lib.funCodeTable[funCode] == nil {
+			return "", fmt.Errorf("DecompileBytecodeWithNames: no function with code %d in the library", funCode)
+		}
+		fd := lib.funCodeTable[funCode]
+		if other, found := lib.funByName[name]; found && other != fd {
+			return "", fmt.Errorf("DecompileBytecodeWithNames: display name '%s' of '%s' is a name of another library function",
+				name, fd.sym)
+		}
+	}
+	f, err := lib.ExpressionFromBytecode(code)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = writeExpressionSourceWithNames(&buf, f, func(f *Expression) string {
+		if IsDataPrefix(f.CallPrefix) || isParameterReference(f.CallPrefix) {
+			return f.FunctionName
+		}
+		if fd := lib.descriptorOfCall(f.CallPrefix); fd != nil {
+			if name, found := names[fd.funCode]; found {
+				return name
+			}
+		}
+		return f.FunctionName
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// dataFunction makes function which returns the data, for example value of the evaluation argument.
+// Data longer than 127 bytes can't be inline data, so it has no bytecode
 func dataFunction(data []byte) EvalFunction {
-	d := data
+	if len(data) <= MaxInlineDataSize {
+		return prefixedDataFunction(mustDataWithPrefix(data))
+	}
+	return EvalFunction{
+		EmbeddedFunction: func(par *CallParams) []byte {
+			if par.Tracing() {
+				par.Trace("-> %s", Fmt(data))
+			}
+			return data
+		},
+	}
+}
+
+// prefixedDataFunction makes data function from the inline data with the prefix.
+// The prefixed data is not copied, so parsed expression shares the backing array with the bytecode
+func prefixedDataFunction(prefixed []byte) EvalFunction {
+	data := prefixed[1:]
 	return EvalFunction{
 		EmbeddedFunction: func(par *CallParams) []byte {
-			par.Trace("-> %s", Fmt(d))
+			if par.Tracing() {
+				par.Trace("-> %s", Fmt(data))
+			}
 			return data
 		},
-		bytecode: mustDataWithPrefix(data),
+		bytecode: prefixed,
 	}
 }
 
@@ -612,21 +756,12 @@ func (lib *Library) parseCallPrefix(code []byte, localLib ...*LocalLibrary) ([]b
 	if code[0]&FirstByteLongCallMask == 0 {
 		// short call
 		if code[0] <= LastEmbeddedReserved {
-			// this is param reference
-			if code[0]&BytecodeParameterFlag == 0 {
-				// eval param reference
-				evalFun = EvalFunction{
-					EmbeddedFunction: evalEvalParamFun(code[0]),
-				}
-				sym = fmt.Sprintf("$%d", code[0])
-			} else {
-				// bytecode param reference
-				paramNr := code[0] & (^BytecodeParameterFlag)
-				evalFun = EvalFunction{
-					EmbeddedFunction: evalBytecodeParamFun(paramNr),
-				}
-				sym = fmt.Sprintf("$$%d", paramNr)
+			// this is param reference, eval $i or bytecode $$i
+			ref := &paramRefs[code[0]]
+			evalFun = EvalFunction{
+				EmbeddedFunction: ref.fun,
 			}
+			sym = ref.sym
 		} else {
 			embeddedFun, arity, sym, err = lib.functionByCode(uint16(code[0]))
 			if err != nil {
@@ -643,31 +778,39 @@ func (lib *Library) parseCallPrefix(code []byte, localLib ...*LocalLibrary) ([]b
 		if len(code) < 2 {
 			return nil, EvalFunction{}, 0, "", io.EOF
 		}
-		arity = int((code[0] & FirstByteLongCallArityMask) >> 2)
-		t := binary.BigEndian.Uint16(code[:2])
-		idx := t & Uint16LongCallCodeMask
+		arity = ArityFromPrefix(code)
+		idx := binary.BigEndian.Uint16(code[:2]) & Uint16LongCallCodeMask
 		if idx > FirstLocalFunCode {
 			return nil, EvalFunction{}, 0, "", fmt.Errorf("wrong call prefix")
 		}
 		callPrefix = code[:2]
 		if idx == FirstLocalFunCode {
 			// it is a local library call
+			if lib.noLocalLibraries {
+				return nil, EvalFunction{}, 0, "", ErrLocalLibrariesDisabled
+			}
 			if len(localLib) == 0 {
 				return nil, EvalFunction{}, 0, "", fmt.Errorf("local library not provided")
 			}
-			if len(code) < 3 {
-				return nil, EvalFunction{}, 0, "", io.EOF
+			localIdx, n, err := parseLocalFunIndex(code[2:])
+			if err != nil {
+				return nil, EvalFunction{}, 0, "", err
 			}
-			idx = uint16(FirstLocalFunCode) + uint16(code[2])
-			callPrefix = code[:3]
+			idx = uint16(FirstLocalFunCode) + localIdx
+			callPrefix = code[:2+n]
 		}
 		embeddedFun, numParams, sym, err = lib.functionByCode(idx, localLib...)
 		if err != nil {
 			return nil, EvalFunction{}, 0, "", err
 		}
-		if numParams > 0 && numParams != arity {
+		// functions without parameters take no arguments either. Consensus-visible, see ParsingVectors
+		if numParams >= 0 && numParams != arity {
 			return nil, EvalFunction{}, 0, "", fmt.Errorf("wrong number of call args")
 		}
+		if idx >= FirstLocalFunCode && numParams != arity {
+			// local functions take exactly the number of parameters they use
+			return nil, EvalFunction{}, 0, "", fmt.Errorf("wrong number of call args of '%s': required %d, got %d", sym, numParams, arity)
+		}
 		evalFun = EvalFunction{
 			EmbeddedFunction: embeddedFun,
 			bytecode:         code,
@@ -676,6 +819,26 @@ func (lib *Library) parseCallPrefix(code []byte, localLib ...*LocalLibrary) ([]b
 	return callPrefix, evalFun, arity, sym, nil
 }
 
+// parseLocalFunIndex parses index of the local function which follows the local call prefix.
+// Indices below LocalFunIndexEscape take 1 byte, others are encoded as escape byte and 2-byte index.
+// Returns index and number of bytes it takes
+func parseLocalFunIndex(code []byte) (uint16, int, error) {
+	if len(code) < 1 {
+		return 0, 0, io.EOF
+	}
+	if code[0] != LocalFunIndexEscape {
+		return uint16(code[0]), 1, nil
+	}
+	if len(code) < 3 {
+		return 0, 0, io.EOF
+	}
+	idx := binary.BigEndian.Uint16(code[1:3])
+	if idx < LocalFunIndexEscape || idx >= MaxNumLocalFunctions {
+		return 0, 0, fmt.Errorf("non-canonical or wrong 2-byte local function index %d", idx)
+	}
+	return idx, 3, nil
+}
+
 // ParseBytecodeInlineDataPrefix attempts to parse beginning of the code as inline data
 // Function used is binary code analysis
 // Returns:
@@ -743,17 +906,132 @@ func (lib *Library) ParseBytecodeOneLevel(code []byte, expectedNumArgs ...int) (
 	if len(expectedNumArgs) > 0 && len(f.Args) != expectedNumArgs[0] {
 		return "", nil, nil, fmt.Errorf("unexpected number of 1st level call arguments")
 	}
-	args := make([][]byte, len(f.Args))
-	prefix := f.CallPrefix
+	args, err := argsBytecode(f)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return f.FunctionName, f.CallPrefix, args, nil
+}
+
+// PartialParse is the one-level parse of the bytecode, possibly malformed, returned by ParseBytecodeOneLevelPartial
+type PartialParse struct {
+	Sym    string
+	Prefix []byte
+	// number of arguments required by the call prefix
+	Arity int
+	// bytecodes of the arguments parsed before the error. All arguments if there is no error
+	Args [][]byte
+	// offset in the bytecode where parsing failed, -1 if there is no error
+	ErrOffset int
+}
 
+// ParseBytecodeOneLevelPartial is the tolerant ParseBytecodeOneLevel for diagnostics of malformed bytecode.
+// On error it returns the call prefix and bytecodes of the arguments parsed before the failing one,
+// together with the error and the offset of the failed prefix. Arguments are returned as they are in the bytecode.
+// Empty prefix means the call prefix itself can't be parsed
+func (lib *Library) ParseBytecodeOneLevelPartial(code []byte) (PartialParse, error) {
+	ret := PartialParse{Args: make([][]byte, 0), ErrOffset: -1}
+	stripped, err := StripMetadata(code)
+	if err != nil {
+		ret.ErrOffset = 0
+		return ret, err
+	}
+	headerLen := len(code) - len(stripped)
+	fail := func(offset int, err error) (PartialParse, error) {
+		ret.ErrOffset = headerLen + offset
+		return ret, err
+	}
+	if len(stripped) == 0 {
+		return fail(0, io.EOF)
+	}
+
+	pos := 0
+	if IsDataPrefix(stripped) {
+		expr, _, _, err := lib.expressionFromBytecode(stripped)
+		if err != nil {
+			return fail(0, err)
+		}
+		ret.Sym, ret.Prefix = expr.FunctionName, expr.CallPrefix
+		pos = len(expr.CallPrefix)
+	} else {
+		callPrefix, _, arity, sym, err := lib.parseCallPrefix(stripped)
+		if err != nil {
+			return fail(0, err)
+		}
+		if len(callPrefix) == 1 && arity < 0 {
+			return fail(0, fmt.Errorf("EasyFL: short embedded with vararg is not allowed"))
+		}
+		ret.Sym, ret.Prefix, ret.Arity = sym, callPrefix, arity
+		pos = len(callPrefix)
+		for i := 0; i < arity; i++ {
+			n, errOffset, err := lib.bytecodeFragmentLengthWithOffset(stripped[pos:])
+			if err != nil {
+				return fail(pos+errOffset, fmt.Errorf("argument %d of '%s': %w", i, sym, err))
+			}
+			ret.Args = append(ret.Args, stripped[pos:pos+n])
+			pos += n
+		}
+	}
+	if pos != len(stripped) {
+		return fail(pos, fmt.Errorf("not all bytes have been consumed. Remaining: %s", Fmt(stripped[pos:])))
+	}
+	return ret, nil
+}
+
+// argsBytecode returns bytecodes of the call arguments of the expression
+func argsBytecode(f *Expression) ([][]byte, error) {
+	args := make([][]byte, len(f.Args))
 	for i, arg := range f.Args {
 		var buf bytes.Buffer
-		if err = writeExpressionBytecode(&buf, arg); err != nil {
-			return "", nil, nil, err
+		if err := writeExpressionBytecode(&buf, arg); err != nil {
+			return nil, err
 		}
 		args[i] = buf.Bytes()
 	}
-	return f.FunctionName, prefix, args, nil
+	return args, nil
+}
+
+// ComposeCall is the inverse of ParseBytecodeOneLevel: it makes bytecode of the call from the call prefix and the
+// bytecodes of arguments. Arity of the prefix must be equal to the number of arguments and each argument must be
+// exactly one well-formed expression. Inline data prefix is composed with no arguments
+func (lib *Library) ComposeCall(prefix []byte, args ...[]byte) ([]byte, error) {
+	if IsDataPrefix(prefix) {
+		dataPrefix, _, err := ParseBytecodeInlineDataPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(dataPrefix) != len(prefix) || len(args) != 0 {
+			return nil, fmt.Errorf("ComposeCall: inline data %s can't have arguments", Fmt(prefix))
+		}
+		return append([]byte(nil), prefix...), nil
+	}
+	callPrefix, _, arity, sym, err := lib.parseCallPrefix(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("ComposeCall: %v", err)
+	}
+	if len(callPrefix) != len(prefix) {
+		return nil, fmt.Errorf("ComposeCall: %s is not a call prefix", Fmt(prefix))
+	}
+	if arity != len(args) {
+		return nil, fmt.Errorf("ComposeCall: call prefix of '%s' has arity %d, got %d arguments", sym, arity, len(args))
+	}
+	size := len(prefix)
+	for i, arg := range args {
+		n, err := lib.bytecodeFragmentLength(arg)
+		if err != nil {
+			return nil, fmt.Errorf("ComposeCall: argument %d of '%s' is not valid bytecode: %v", i, sym, err)
+		}
+		if n != len(arg) {
+			return nil, fmt.Errorf("ComposeCall: argument %d of '%s' is not one expression. Remaining: %s", i, sym, Fmt(arg[n:]))
+		}
+		size += len(arg)
+	}
+	ret := make([]byte, 0, size)
+	ret = append(ret, prefix...)
+	for _, arg := range args {
+		ret = append(ret, arg...)
+	}
+	return ret, nil
 }
 
 // ComposeBytecodeOneLevel creates a source form of the one-level parsed expression. The nested function calls
@@ -794,11 +1072,11 @@ func ComposeBytecodeOneLevel(sym string, args [][]byte) string {
 
 func makeEmbeddedFunForExpression(sym string, expr *Expression) EmbeddedFunction {
 	return func(par *CallParams) []byte {
-		varScope := make([]*call, len(par.args))
+		varScope := make([]*call, par.Arity())
 		for i := range varScope {
-			varScope[i] = newCall(par.args[i].EvalFunc, par.args[i].Args, par.ctx)
+			varScope[i] = par.argumentCall(byte(i))
 		}
-		ret := evalExpression(par.ctx.glb, expr, varScope)
+		ret := par.ctx.nested(varScope).eval(expr)
 		par.Trace("'%s':: %d params -> %s", sym, par.Arity(), Fmt(ret))
 		return ret
 	}
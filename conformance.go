@@ -0,0 +1,201 @@
+package easyfl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ConformanceVector is an expression with the expected outcome. Vectors fix semantics which must be the same
+// in all implementations of EasyFL
+type ConformanceVector struct {
+	Name   string
+	Source string
+	// if not nil, the bytecode is evaluated instead of the source, which then only describes it.
+	// For bytecode which can't be compiled from the source
+	Bytecode []byte
+	// expected result, if the expression does not fail
+	Result []byte
+	// if not empty, evaluation must fail with the error containing this string
+	FailsWith string
+	// if not nil, names of the called functions in the order of calls. Fixes which arguments are evaluated.
+	// Inline data and parameter references are not calls
+	Calls []string
+}
+
+// ShortCircuitVectors fix left-to-right evaluation of and/or, which stops at the first decisive argument
+var ShortCircuitVectors = []ConformanceVector{
+	{Name: "and: empty", Source: "and", Result: []byte{0xff}, Calls: []string{"and"}},
+	{Name: "and: stops at first false", Source: "and(1, nil, fail(1))", Result: nil, Calls: []string{"and"}},
+	{Name: "and: evaluates until false", Source: "and(1, fail(1), nil)", FailsWith: "error #1", Calls: []string{"and", "fail"}},
+	{Name: "and: all true", Source: "and(1, 2, 3)", Result: []byte{0xff}, Calls: []string{"and"}},
+	{Name: "or: empty", Source: "or", Result: nil, Calls: []string{"or"}},
+	{Name: "or: stops at first true", Source: "or(nil, 1, fail(1))", Result: []byte{0xff}, Calls: []string{"or"}},
+	{Name: "or: evaluates until true", Source: "or(nil, fail(1), 1)", FailsWith: "error #1", Calls: []string{"or", "fail"}},
+	{Name: "or: all false", Source: "or(nil, nil)", Result: nil, Calls: []string{"or"}},
+	{Name: "if: only selected branch", Source: "if(1, 2, fail(1))", Result: []byte{2}, Calls: []string{"if"}},
+	{Name: "if: only selected branch else", Source: "if(nil, fail(1), 3)", Result: []byte{3}, Calls: []string{"if"}},
+	{Name: "if: nested", Source: "if(equal(1, 1), concat(1, and(nil, fail(1))), fail(2))", Result: []byte{1},
+		Calls: []string{"if", "equal", "concat", "and"}},
+}
+
+// BytecodeParameterVectors fix bytecode of the argument taken with '$$i': it is exactly the bytecode of the argument
+// expression. Earlier versions returned the bytecode of the argument which is the function call followed by the rest
+// of the bytecode of the caller, i.e. by bytecode of the following arguments of the same and enclosing calls.
+// Results of expressions which take bytecode of such arguments differ between versions, while the library hash
+// and the bytecode are the same. Bytecode of the inline data argument and of the last argument at the end
+// of the bytecode is the same in all versions
+var BytecodeParameterVectors = []ConformanceVector{
+	{Name: "$$i: data", Source: "concat(bytecode(1), 2)", Result: []byte{0x81, 1, 2}},
+	{Name: "$$i: call followed by argument", Source: "concat(bytecode(concat(1, 2)), 5)", Result: []byte{0x48, 0x40, 0x81, 1, 0x81, 2, 5}},
+	{Name: "$$i: same calls", Source: "equal(bytecode(concat(1, 2)), bytecode(concat(1, 2)))", Result: []byte{0xff}},
+	{Name: "$$i: nested call followed by argument", Source: "len(concat(bytecode(concat(1, 2)), bytecode(nil)))", Result: []byte{0, 0, 0, 0, 0, 0, 0, 7}},
+}
+
+// ParsingVectors fix parameter references and arity of calls rejected by the compiler and the bytecode parser.
+// Earlier versions compiled '$8' into the call of the first short embedded function and accepted long calls
+// of functions without parameters with any number of arguments, which were ignored.
+// Bytecode with such calls is rejected now, while the library hash is the same
+var ParsingVectors = []ConformanceVector{
+	{Name: "parsing: $8", Source: "concat($8)", FailsWith: "wrong eval parameter reference '$8'"},
+	{Name: "parsing: $$8", Source: "concat($$8)", FailsWith: "wrong bytecode parameter reference '$$8'"},
+	{Name: "parsing: $7", Source: "concat(1, $7)", FailsWith: "required number of parameters is 8, got 0"},
+	{Name: "parsing: long call of 'true' with 1 argument", Source: "true(1)", Bytecode: []byte{0x45, 0x40, 0x81, 0x01},
+		FailsWith: "wrong number of call args"},
+	{Name: "parsing: long call of 'true' without arguments", Source: "true", Bytecode: []byte{0x41, 0x40}, Result: []byte{0xff}},
+}
+
+// DefaultArithmeticVectors fix uint64 arithmetics of the library with ArithmeticDefault profile,
+// i.e. of the library constructed without the profile
+var DefaultArithmeticVectors = []ConformanceVector{
+	{Name: "default: add", Source: "add(u64/18446744073709551614, 1)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "default: add overflow", Source: "add(u64/18446744073709551615, 3)", Result: []byte{0, 0, 0, 0, 0, 0, 0, 2}},
+	{Name: "default: sub underflow", Source: "sub(1, 2)", FailsWith: "underflow in subtraction"},
+	{Name: "default: mul", Source: "mul(u64/4294967295, u64/4294967297)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "default: mul overflow", Source: "mul(u64/4294967296, u64/4294967297)", Result: []byte{0, 0, 0, 1, 0, 0, 0, 0}},
+}
+
+// StrictArithmeticVectors fix uint64 arithmetics of the library with ArithmeticStrict profile
+var StrictArithmeticVectors = []ConformanceVector{
+	{Name: "strict: add", Source: "add(u64/18446744073709551614, 1)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "strict: add overflow", Source: "add(u64/18446744073709551615, 1)", FailsWith: "overflow in addition"},
+	{Name: "strict: sub underflow", Source: "sub(1, 2)", FailsWith: "underflow in subtraction"},
+	{Name: "strict: mul", Source: "mul(u64/4294967295, u64/4294967297)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "strict: mul overflow", Source: "mul(u64/4294967296, u64/4294967296)", FailsWith: "overflow in multiplication"},
+}
+
+// WrappingArithmeticVectors fix uint64 arithmetics of the library with ArithmeticWrapping profile
+var WrappingArithmeticVectors = []ConformanceVector{
+	{Name: "wrapping: add", Source: "add(u64/18446744073709551614, 1)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "wrapping: add overflow", Source: "add(u64/18446744073709551615, 3)", Result: []byte{0, 0, 0, 0, 0, 0, 0, 2}},
+	{Name: "wrapping: sub underflow", Source: "sub(1, 2)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "wrapping: mul", Source: "mul(u64/4294967295, u64/4294967297)", Result: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	{Name: "wrapping: mul overflow", Source: "mul(u64/4294967296, u64/4294967297)", Result: []byte{0, 0, 0, 1, 0, 0, 0, 0}},
+}
+
+// CheckConformance evaluates vectors and checks outcomes
+func (lib *Library) CheckConformance(vectors []ConformanceVector) error {
+	for _, v := range vectors {
+		res, calls, err := lib.evalWithCalls(v)
+		if v.Calls != nil && strings.Join(calls, ",") != strings.Join(v.Calls, ",") {
+			return fmt.Errorf("conformance '%s': '%s' expected calls %v, got %v", v.Name, v.Source, v.Calls, calls)
+		}
+		if v.FailsWith != "" {
+			if err == nil {
+				return fmt.Errorf("conformance '%s': '%s' expected to fail, got %s", v.Name, v.Source, Fmt(res))
+			}
+			if !strings.Contains(err.Error(), v.FailsWith) {
+				return fmt.Errorf("conformance '%s': '%s' expected to fail with '%s', got '%v'", v.Name, v.Source, v.FailsWith, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("conformance '%s': '%s' failed: %v", v.Name, v.Source, err)
+		}
+		if !bytes.Equal(res, v.Result) {
+			return fmt.Errorf("conformance '%s': '%s' expected %s, got %s", v.Name, v.Source, Fmt(v.Result), Fmt(res))
+		}
+	}
+	return nil
+}
+
+// evalWithCalls evaluates the vector and returns names of the called functions in the order of calls
+func (lib *Library) evalWithCalls(v ConformanceVector) ([]byte, []string, error) {
+	tracer := &callOrderTracer{pending: make(map[int][]string)}
+	var res []byte
+	var err error
+	if v.Bytecode != nil {
+		res, err = lib.EvalFromBytecode(tracer, v.Bytecode)
+	} else {
+		res, err = lib.EvalFromSource(tracer, v.Source)
+	}
+	return res, tracer.calls(), err
+}
+
+// callOrderTracer restores the order of calls from trace events, which are reported upon completion of calls:
+// all calls below the depth, completed since the previous completion at the depth, are called by the completed one
+type callOrderTracer struct {
+	pending map[int][]string
+}
+
+func (t *callOrderTracer) Data() interface{} { return nil }
+func (t *callOrderTracer) Trace() bool       { return true }
+func (t *callOrderTracer) PutTrace(string)   {}
+
+func (t *callOrderTracer) PutTraceEvent(e *TraceEvent) {
+	seq := make([]string, 0)
+	if e.IsCall {
+		seq = append(seq, e.Fun)
+	}
+	seq = append(seq, t.pending[e.Depth+1]...)
+	delete(t.pending, e.Depth+1)
+	t.pending[e.Depth] = append(t.pending[e.Depth], seq...)
+}
+
+func (t *callOrderTracer) calls() []string {
+	// calls of the failed evaluation may remain pending at any depth
+	ret := make([]string, 0)
+	for depth := 0; len(t.pending) > 0; depth++ {
+		ret = append(ret, t.pending[depth]...)
+		delete(t.pending, depth)
+	}
+	return ret
+}
+
+type conformanceVectorJSON struct {
+	Name      string   `json:"name"`
+	Source    string   `json:"source"`
+	Bytecode  string   `json:"bytecode"`
+	Result    string   `json:"result,omitempty"`
+	FailsWith string   `json:"failsWith,omitempty"`
+	Calls     []string `json:"calls,omitempty"`
+}
+
+// ConformanceJSON exports vectors with the bytecode compiled by the library, for implementations in other languages.
+// Bytecode of the vector, source of which is expected not to compile, is empty
+func (lib *Library) ConformanceJSON(vectors []ConformanceVector) ([]byte, error) {
+	ret := make([]conformanceVectorJSON, len(vectors))
+	for i, v := range vectors {
+		code := v.Bytecode
+		if code == nil {
+			var err error
+			_, _, code, err = lib.CompileExpression(v.Source)
+			if err != nil && (v.FailsWith == "" || !strings.Contains(err.Error(), v.FailsWith)) {
+				return nil, fmt.Errorf("conformance '%s': %v", v.Name, err)
+			}
+		}
+		ret[i] = conformanceVectorJSON{
+			Name:      v.Name,
+			Source:    v.Source,
+			Bytecode:  hex.EncodeToString(code),
+			FailsWith: v.FailsWith,
+			Calls:     v.Calls,
+		}
+		if v.FailsWith == "" {
+			ret[i].Result = hex.EncodeToString(v.Result)
+		}
+	}
+	return json.MarshalIndent(ret, "", "  ")
+}
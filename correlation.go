@@ -0,0 +1,50 @@
+package easyfl
+
+// Correlated is implemented by global data which carries the correlation ID, for example prefix of the
+// transaction hash. The ID is included in every trace message of the evaluation, in panic messages
+// of TracePanic and in errors ErrScriptFail, ErrOutOfGas and ErrBranchBudget, so that interleaved logs
+// of concurrent evaluations can be attributed
+type Correlated interface {
+	CorrelationID() string
+}
+
+type globalDataCorrelated struct {
+	GlobalData
+	id string
+}
+
+// WithCorrelationID wraps global data so that trace and panic messages of evaluations with it are prefixed
+// with the correlation ID. The wrapper does not report trace events and is not profiled, even if
+// the wrapped global data does. Implement Correlated to combine them
+func WithCorrelationID(glb GlobalData, id string) GlobalData {
+	if isNil(glb) {
+		glb = NewGlobalDataNoTrace(nil)
+	}
+	return &globalDataCorrelated{GlobalData: glb, id: id}
+}
+
+func (g *globalDataCorrelated) CorrelationID() string {
+	return g.id
+}
+
+func correlationIDOf(glb GlobalData) string {
+	if isNil(glb) {
+		return ""
+	}
+	if c, ok := glb.(Correlated); ok {
+		return c.CorrelationID()
+	}
+	return ""
+}
+
+// withCorrelationID prefixes the message with the correlation ID of the evaluation, if any
+func (p *CallParams) withCorrelationID(msg string) string {
+	return prefixCorrelationID(p.ctx.state.correlationID, msg)
+}
+
+func prefixCorrelationID(id, msg string) string {
+	if id == "" {
+		return msg
+	}
+	return "[" + id + "] " + msg
+}
@@ -0,0 +1,287 @@
+package easyfl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dynamicEvalBase are base functions which evaluate bytecode, known only at runtime
+var dynamicEvalBase = map[string]bool{
+	"eval":   true,
+	"applyN": true,
+}
+
+// ErrCostNotBounded is returned by EstimateCost for bytecode which evaluates bytecode known only at runtime
+// or which calls functions consuming gas depending on the size of data not known statically
+type ErrCostNotBounded struct {
+	Sym string
+	// the cost of the function depends on the size of its arguments, which is not known statically
+	SizeDependent bool
+}
+
+func (e *ErrCostNotBounded) Error() string {
+	if e.SizeDependent {
+		return fmt.Sprintf("cost can't be estimated statically: cost of '%s' depends on size of data not known statically", e.Sym)
+	}
+	return fmt.Sprintf("cost can't be estimated statically: '%s' evaluates bytecode known only at runtime", e.Sym)
+}
+
+// unknownSize is size bound of the data which can't be bounded statically
+const unknownSize = -1
+
+// maxEstimatedSize is the biggest size bound. Bigger bounds are treated as unknown
+const maxEstimatedSize = 1 << 40
+
+// dataGasBase are estimates of gas consumed by base functions depending on the size of arguments,
+// in addition to their gas costs. See hashingGas, copyingGas and signatureGas
+var dataGasBase = map[string]func(argSizes []int) (uint64, bool){
+	"blake2b": func(argSizes []int) (uint64, bool) {
+		size := sumOfSizes(argSizes)
+		return hashingGas(size), size != unknownSize
+	},
+	"chainHash": func(argSizes []int) (uint64, bool) {
+		size := sumOfSizes(argSizes)
+		return hashingGas(size), size != unknownSize
+	},
+	"prand": func(argSizes []int) (uint64, bool) {
+		// up to 255 bytes, 8 hashes of the seed with the counter
+		return 8 * hashingGas(argSizes[0]+1), argSizes[0] != unknownSize
+	},
+	"concat": func(argSizes []int) (uint64, bool) {
+		size := sumOfSizes(argSizes)
+		return copyingGas(size), size != unknownSize
+	},
+	"repeat": func(argSizes []int) (uint64, bool) {
+		size := mulSize(argSizes[0], 255)
+		return copyingGas(size), size != unknownSize
+	},
+	"validSignatureED25519": func(argSizes []int) (uint64, bool) {
+		return hashingGas(argSizes[0]), argSizes[0] != unknownSize
+	},
+	"validMultiSigED25519": func(argSizes []int) (uint64, bool) {
+		// each signature entry is verified at most once
+		if argSizes[0] == unknownSize || argSizes[3] == unknownSize {
+			return 0, false
+		}
+		return uint64(argSizes[3]/65) * signatureGas(argSizes[0]), true
+	},
+}
+
+// sizeBoundsBase are bounds of the size of the results of base functions, given bounds of the size of arguments.
+// Results of other functions are of unknown size
+var sizeBoundsBase = map[string]func(argSizes []int) int{
+	"fail":                  fixedSize(0),
+	"byte":                  fixedSize(1),
+	"equal":                 fixedSize(1),
+	"hasPrefix":             fixedSize(1),
+	"not":                   fixedSize(1),
+	"isZero":                fixedSize(1),
+	"and":                   fixedSize(1),
+	"or":                    fixedSize(1),
+	"lessThan":              fixedSize(1),
+	"validUTF8":             fixedSize(1),
+	"containsBytes":         fixedSize(1),
+	"validSignatureED25519": fixedSize(1),
+	"validMultiSigED25519":  fixedSize(1),
+	"equalMasked":           fixedSize(1),
+	"requireErr":            fixedSize(1),
+	"firstCaseIndex":        fixedSize(1),
+	"firstEqualIndex":       fixedSize(1),
+	"cmp128":                fixedSize(1),
+	"len":                   fixedSize(8),
+	"add":                   fixedSize(8),
+	"sub":                   fixedSize(8),
+	"mul":                   fixedSize(8),
+	"div":                   fixedSize(8),
+	"mod":                   fixedSize(8),
+	"uint64Bytes":           fixedSize(8),
+	"scaleUp":               fixedSize(8),
+	"scaleDown":             fixedSize(8),
+	"lshift64":              fixedSize(8),
+	"rshift64":              fixedSize(8),
+	"numWords32":            fixedSize(8),
+	"add128":                fixedSize(16),
+	"sub128":                fixedSize(16),
+	"mul64to128":            fixedSize(16),
+	"blake2b":               fixedSize(32),
+	"chainHash":             fixedSize(32),
+	"word32At":              fixedSize(32),
+	"prand":                 fixedSize(255),
+	"slice":                 sizeOfArg(0),
+	"tail":                  sizeOfArg(0),
+	"bitwiseAND":            sizeOfArg(0),
+	"bitwiseOR":             sizeOfArg(0),
+	"bitwiseXOR":            sizeOfArg(0),
+	"bitwiseNOT":            sizeOfArg(0),
+	"setWord32":             sizeOfArg(0),
+	"parsePrefixBytecode":   sizeOfArg(0),
+	"parseArgumentBytecode": sizeOfArg(0),
+	"concat":                sumOfSizes,
+	"repeat":                func(argSizes []int) int { return mulSize(argSizes[0], 255) },
+	"if":                    maxOfSizes(1),
+	"selectCaseByIndex":     maxOfSizes(1),
+	"cond":                  maxOfSizes(0),
+}
+
+func fixedSize(n int) func([]int) int {
+	return func([]int) int { return n }
+}
+
+func sizeOfArg(i int) func([]int) int {
+	return func(argSizes []int) int { return argSizes[i] }
+}
+
+func maxOfSizes(from int) func([]int) int {
+	return func(argSizes []int) int {
+		ret := 0
+		for _, size := range argSizes[from:] {
+			if size == unknownSize {
+				return unknownSize
+			}
+			if size > ret {
+				ret = size
+			}
+		}
+		return ret
+	}
+}
+
+func sumOfSizes(argSizes []int) int {
+	ret := 0
+	for _, size := range argSizes {
+		if size == unknownSize || ret+size > maxEstimatedSize {
+			return unknownSize
+		}
+		ret += size
+	}
+	return ret
+}
+
+func mulSize(size, n int) int {
+	if size == unknownSize || size > maxEstimatedSize/n {
+		return unknownSize
+	}
+	return size * n
+}
+
+// EstimateCost returns the worst-case cost of the evaluation of the bytecode, without evaluating it.
+// It is gas the metered evaluation can consume, as if all branches were taken and each argument was evaluated,
+// plus the total size of inline data in the evaluated expressions.
+// The estimate assumes each argument of an embedded function is evaluated at most once, which is true for all
+// base functions. Gas consumed by host embedded functions with ConsumeGas is not known statically and not included.
+// Gas consumed by base functions depending on the size of data is included, with the size bounded statically.
+// Arguments of the bytecode are of unknown size, use EstimateCostWithArgSize to bound them.
+// Returns *ErrCostNotBounded if the bytecode calls functions which evaluate dynamic bytecode, such as 'eval',
+// or if the size of data processed by a base function can't be bounded.
+// Calls to local libraries are not supported
+func (lib *Library) EstimateCost(code []byte) (uint64, error) {
+	return lib.estimateCost(code, unknownSize)
+}
+
+// EstimateCostWithArgSize is EstimateCost of the bytecode with each argument at most maxArgSize bytes long
+func (lib *Library) EstimateCostWithArgSize(code []byte, maxArgSize int) (uint64, error) {
+	Assert(maxArgSize >= 0 && maxArgSize <= maxEstimatedSize, "wrong maximal size of the argument: %d", maxArgSize)
+	return lib.estimateCost(code, maxArgSize)
+}
+
+func (lib *Library) estimateCost(code []byte, maxArgSize int) (uint64, error) {
+	expr, err := lib.ExpressionFromBytecode(code)
+	if err != nil {
+		return 0, err
+	}
+	paramSizes := make([]int, MaxParameters)
+	for i := range paramSizes {
+		paramSizes[i] = maxArgSize
+	}
+	e := &costEstimator{lib: lib, bodies: make(map[string]costEstimate)}
+	ret, err := e.estimate(expr, paramSizes)
+	return ret.cost, err
+}
+
+type costEstimator struct {
+	lib *Library
+	// estimates of bodies of extended functions, computed once for the function and bounds of its arguments
+	bodies map[string]costEstimate
+}
+
+type costEstimate struct {
+	cost uint64
+	// bound of the size of the result or unknownSize
+	size int
+}
+
+// estimate returns the cost and the size bound of the result of the expression with parameters
+// bounded by paramSizes
+func (e *costEstimator) estimate(expr *Expression, paramSizes []int) (costEstimate, error) {
+	ret := costEstimate{cost: GasPerStep, size: unknownSize}
+	switch {
+	case IsDataPrefix(expr.CallPrefix):
+		ret.cost += uint64(len(expr.CallPrefix) - 1)
+		ret.size = len(expr.CallPrefix) - 1
+		return ret, nil
+	case isParameterReference(expr.CallPrefix):
+		// argument itself is charged by the caller. Bytecode of the argument is of unknown size
+		if idx := expr.CallPrefix[0]; idx&BytecodeParameterFlag == 0 && int(idx) < len(paramSizes) {
+			ret.size = paramSizes[idx]
+		}
+		return ret, nil
+	}
+	fd := e.lib.descriptorOfCall(expr.CallPrefix)
+	if fd == nil {
+		return ret, fmt.Errorf("EstimateCost: can't estimate cost of the call %s: local library calls are not supported", Fmt(expr.CallPrefix))
+	}
+	if dynamicEvalBase[fd.sym] {
+		return ret, &ErrCostNotBounded{Sym: fd.sym}
+	}
+	ret.cost += fd.gasCost
+	argSizes := make([]int, len(expr.Args))
+	for i, arg := range expr.Args {
+		argEstimate, err := e.estimate(arg, paramSizes)
+		if err != nil {
+			return ret, err
+		}
+		ret.cost += argEstimate.cost
+		argSizes[i] = argEstimate.size
+	}
+	if len(fd.bytecode) > 0 {
+		body, err := e.bodyEstimate(fd, argSizes)
+		if err != nil {
+			return ret, err
+		}
+		ret.cost += body.cost
+		ret.size = body.size
+		return ret, nil
+	}
+	if dataGas, found := dataGasBase[fd.sym]; found {
+		gas, bounded := dataGas(argSizes)
+		if !bounded {
+			return ret, &ErrCostNotBounded{Sym: fd.sym, SizeDependent: true}
+		}
+		ret.cost += gas
+	}
+	if sizeBound, found := sizeBoundsBase[fd.sym]; found {
+		ret.size = sizeBound(argSizes)
+	}
+	return ret, nil
+}
+
+func (e *costEstimator) bodyEstimate(fd *funDescriptor, argSizes []int) (costEstimate, error) {
+	var key strings.Builder
+	_, _ = fmt.Fprintf(&key, "%d", fd.funCode)
+	for _, size := range argSizes {
+		_, _ = fmt.Fprintf(&key, ",%d", size)
+	}
+	if ret, found := e.bodies[key.String()]; found {
+		return ret, nil
+	}
+	body, err := e.lib.ExpressionFromBytecode(fd.bytecode)
+	if err != nil {
+		return costEstimate{}, err
+	}
+	ret, err := e.estimate(body, argSizes)
+	if err != nil {
+		return costEstimate{}, err
+	}
+	e.bodies[key.String()] = ret
+	return ret, nil
+}
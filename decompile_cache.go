@@ -0,0 +1,67 @@
+package easyfl
+
+import (
+	"golang.org/x/crypto/blake2b"
+)
+
+// DecompiledScript is decompiled bytecode together with its one-level parse, as returned by ParseBytecodeOneLevel
+type DecompiledScript struct {
+	Source string
+	Sym    string
+	Prefix []byte
+	Args   [][]byte
+}
+
+// SetDecompileCache enables cache of the decompiled bytecodes of the given capacity. 0 disables the cache.
+// When enabled, DecompileBytecode uses the cache too
+func (lib *Library) SetDecompileCache(capacity int) {
+	if capacity <= 0 {
+		lib.decompileCache = nil
+		return
+	}
+	lib.decompileCache = newLRUCache(capacity)
+}
+
+// DecompileCacheStats returns counters of the decompile cache. Zero if cache is not enabled
+func (lib *Library) DecompileCacheStats() CacheStats {
+	if lib.decompileCache == nil {
+		return CacheStats{}
+	}
+	return lib.decompileCache.stats()
+}
+
+// DecompileCached decompiles and parses one level of the bytecode. Results are cached by the hash of the bytecode,
+// if cache is enabled. Returned value is shared and must not be modified. Errors are not cached
+func (lib *Library) DecompileCached(code []byte) (*DecompiledScript, error) {
+	cache := lib.decompileCache
+	if cache == nil {
+		return lib.decompileScript(code)
+	}
+	key := blake2b.Sum256(code)
+	if ret, found := cache.get(key); found {
+		return ret.(*DecompiledScript), nil
+	}
+	ret, err := lib.decompileScript(code)
+	if err != nil {
+		return nil, err
+	}
+	cache.put(key, ret)
+	return ret, nil
+}
+
+func (lib *Library) decompileScript(code []byte) (*DecompiledScript, error) {
+	f, err := lib.ExpressionFromBytecode(code)
+	if err != nil {
+		return nil, err
+	}
+	args, err := argsBytecode(f)
+	if err != nil {
+		return nil, err
+	}
+	return &DecompiledScript{
+		Source: ExpressionToSource(f),
+		Sym:    f.FunctionName,
+		Prefix: f.CallPrefix,
+		Args:   args,
+	}, nil
+}
@@ -0,0 +1,38 @@
+package easyfl
+
+// MaxStaticDepth returns the maximal nesting depth of calls the evaluation of the bytecode can reach, including
+// nesting inside bodies of the called extended and local library functions. It is an upper bound over all
+// branches, whichever are taken at runtime. Bytecode evaluated dynamically, for example by 'eval',
+// is not known statically and is bounded by the recursion limit of dynamic evaluation instead
+func (lib *Library) MaxStaticDepth(code []byte, localLib ...*LocalLibrary) (int, error) {
+	expr, err := lib.ExpressionFromBytecode(code, localLib...)
+	if err != nil {
+		return 0, err
+	}
+	return lib.staticDepth(expr, localLib...), nil
+}
+
+// staticDepth of the call of extended function is one level of the call plus depth of its body, where
+// each parameter reference is replaced by the deepest argument
+func (lib *Library) staticDepth(expr *Expression, localLib ...*LocalLibrary) int {
+	prefix := expr.CallPrefix
+	if IsDataPrefix(prefix) || len(prefix) == 1 && prefix[0] <= LastEmbeddedReserved {
+		return 1
+	}
+	argDepth := 0
+	for _, arg := range expr.Args {
+		if d := lib.staticDepth(arg, localLib...); d > argDepth {
+			argDepth = d
+		}
+	}
+	fd := lib.descriptorOfCall(prefix, localLib...)
+	if fd == nil || fd.staticDepth == 0 {
+		// embedded function
+		return 1 + argDepth
+	}
+	if argDepth == 0 {
+		argDepth = 1
+	}
+	// the call itself is one level, parameter reference in the body is replaced by the argument
+	return fd.staticDepth + argDepth
+}
@@ -0,0 +1,63 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// CheckDeterminism evaluates the bytecode n times and returns error if results or errors differ. If parallel is true,
+// evaluations run concurrently. It is a test harness for host embedded functions, which must be deterministic:
+// reading wall clock, iterating over maps or depending on unsynchronized state makes validation results
+// differ between nodes. The global data is shared by all evaluations
+func CheckDeterminism(lib *Library, glb GlobalData, code []byte, args [][]byte, n int, parallel bool) error {
+	if n < 2 {
+		return fmt.Errorf("CheckDeterminism: at least 2 evaluations required")
+	}
+	results := make([][]byte, n)
+	errs := make([]error, n)
+	eval := func(i int) {
+		results[i], errs[i] = lib.EvalFromBytecode(glb, code, args...)
+	}
+	if parallel {
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func(i int) {
+				defer wg.Done()
+				eval(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := 0; i < n; i++ {
+			eval(i)
+		}
+	}
+	for i := 1; i < n; i++ {
+		if !sameOutcome(results[0], errs[0], results[i], errs[i]) {
+			return fmt.Errorf("CheckDeterminism: evaluation #%d differs from #0: %s", i, outcomeDiff(results[0], errs[0], results[i], errs[i]))
+		}
+	}
+	return nil
+}
+
+func sameOutcome(res0 []byte, err0 error, res1 []byte, err1 error) bool {
+	switch {
+	case err0 == nil && err1 == nil:
+		return bytes.Equal(res0, res1)
+	case err0 != nil && err1 != nil:
+		return err0.Error() == err1.Error()
+	}
+	return false
+}
+
+func outcomeDiff(res0 []byte, err0 error, res1 []byte, err1 error) string {
+	format := func(res []byte, err error) string {
+		if err != nil {
+			return fmt.Sprintf("error '%v'", err)
+		}
+		return Fmt(res)
+	}
+	return fmt.Sprintf("%s != %s", format(res0, err0), format(res1, err1))
+}
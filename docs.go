@@ -0,0 +1,60 @@
+package easyfl
+
+// FunctionDoc is the documentation of the library function, as it is known to the library
+type FunctionDoc struct {
+	FunctionInfo
+	// bytecode of the extended function. Nil for embedded functions
+	Bytecode []byte
+	// decompiled bytecode of the extended function. Empty for embedded functions
+	Source string
+	// deprecation note, if the function is deprecated
+	Deprecated string
+	// sources of the semantics annotations, if any
+	Precondition  string
+	Postcondition string
+}
+
+// FunctionDocs returns documentation of all functions of the library, in the order of function codes
+func (lib *Library) FunctionDocs() []FunctionDoc {
+	descriptors := lib.descriptorsByFunCode()
+	ret := make([]FunctionDoc, 0, len(descriptors))
+	for _, fd := range descriptors {
+		ret = append(ret, lib.functionDoc(fd))
+	}
+	return ret
+}
+
+// FunctionDoc returns documentation of the function by its name
+func (lib *Library) FunctionDoc(sym string) (FunctionDoc, bool) {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return FunctionDoc{}, false
+	}
+	return lib.functionDoc(fd), true
+}
+
+func (lib *Library) functionDoc(fd *funDescriptor) FunctionDoc {
+	isEmbedded, isShort := fd.isEmbeddedOrShort()
+	ret := FunctionDoc{
+		FunctionInfo: FunctionInfo{
+			Sym:        fd.sym,
+			FunCode:    fd.funCode,
+			IsEmbedded: isEmbedded,
+			IsShort:    isShort,
+			IsInternal: fd.internal,
+			NumParams:  fd.requiredNumParams,
+		},
+		Bytecode:   fd.bytecode,
+		Deprecated: fd.deprecated,
+	}
+	if len(fd.bytecode) > 0 {
+		if src, err := lib.DecompileBytecode(fd.bytecode); err == nil {
+			ret.Source = src
+		}
+	}
+	if fd.semantics != nil {
+		ret.Precondition = fd.semantics.preSource
+		ret.Postcondition = fd.semantics.postSource
+	}
+	return ret
+}
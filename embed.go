@@ -6,7 +6,10 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math/bits"
 	"reflect"
+	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/blake2b"
 )
@@ -17,34 +20,48 @@ import (
 //  - do we need short end long embedding?
 
 var (
-	embedShortBase = []*EmbeddedFunctionData{
-		{"fail", 1, evalFail},
-		{"slice", 3, evalSlice},
-		{"byte", 2, evalByte},
-		{"tail", 2, evalTail},
-		{"equal", 2, evalEqual},
-		{"hasPrefix", 2, evalHasPrefix},
-		{"len", 1, evalLen},
-		{"not", 1, evalNot},
-		{"if", 3, evalIf},
-		{"isZero", 1, evalIsZero},
-	}
-	embedLongBase = []*EmbeddedFunctionData{
-		{"concat", -1, evalConcat},
-		{"and", -1, evalAnd},
-		{"or", -1, evalOr},
-		{"repeat", 2, evalRepeat},
-		{"firstCaseIndex", -1, evalFirstCaseIndex},
-		{"firstEqualIndex", -1, evalFirstEqualIndex},
-		{"selectCaseByIndex", -1, evalSelectCaseByIndex},
-	}
-	embedArithmeticsShort = []*EmbeddedFunctionData{
-		{"add", 2, evalAddUint},
-		{"sub", 2, evalSubUint},
-		{"mul", 2, evalMulUint},
-		{"div", 2, evalDivUint},
-		{"mod", 2, evalModuloUint},
-		{"uint64Bytes", 1, evalUint64Bytes},
+	embedShortBase = func(lib *Library) []*EmbeddedFunctionData {
+		return []*EmbeddedFunctionData{
+			{"fail", 1, lib.evalFail},
+			{"slice", 3, evalSlice},
+			{"byte", 2, evalByte},
+			{"tail", 2, evalTail},
+			{"equal", 2, evalEqual},
+			{"hasPrefix", 2, evalHasPrefix},
+			{"len", 1, evalLen},
+			{"not", 1, evalNot},
+			{"if", 3, evalIf},
+			{"isZero", 1, evalIsZero},
+		}
+	}
+	embedLongBase = func(lib *Library) []*EmbeddedFunctionData {
+		return []*EmbeddedFunctionData{
+			{"concat", -1, evalConcat},
+			{"and", -1, evalAnd},
+			{"or", -1, lib.evalOr},
+			{"repeat", 2, evalRepeat},
+			{"firstCaseIndex", -1, lib.evalFirstCaseIndex},
+			{"firstEqualIndex", -1, evalFirstEqualIndex},
+			{"selectCaseByIndex", -1, evalSelectCaseByIndex},
+		}
+	}
+	embedArithmeticsShort = func(lib *Library) []*EmbeddedFunctionData {
+		return []*EmbeddedFunctionData{
+			{"add", 2, lib.evalAddUint},
+			{"sub", 2, lib.evalSubUint},
+			{"mul", 2, lib.evalMulUint},
+			{"div", 2, evalDivUint},
+			{"mod", 2, evalModuloUint},
+			{"uint64Bytes", 1, evalUint64Bytes},
+		}
+	}
+	embedArithmeticsLong = []*EmbeddedFunctionData{
+		{"scaleUp", 2, evalScaleUp},
+		{"scaleDown", 2, evalScaleDown},
+	}
+	embedStringsLong = []*EmbeddedFunctionData{
+		{"validUTF8", 1, evalValidUTF8},
+		{"containsBytes", 2, evalContainsBytes},
 	}
 	embedBitwiseAndCmpShort = []*EmbeddedFunctionData{
 		{"lessThan", 2, evalLessThan},
@@ -61,6 +78,44 @@ var (
 		{"validSignatureED25519", 3, evalValidSigED25519},
 		{"blake2b", -1, evalBlake2b},
 	}
+	embedPseudoRandomLong = []*EmbeddedFunctionData{
+		{"prand", 2, evalPRand},
+	}
+	embedRequireErrLong = func(lib *Library) []*EmbeddedFunctionData {
+		return []*EmbeddedFunctionData{
+			{"requireErr", 2, lib.evalRequireErr},
+		}
+	}
+	embedCondLong = func(lib *Library) []*EmbeddedFunctionData {
+		return []*EmbeddedFunctionData{
+			{"cond", -1, lib.evalCond},
+		}
+	}
+	embedEqualMaskedLong = []*EmbeddedFunctionData{
+		{"equalMasked", 3, evalEqualMasked},
+	}
+	embedMultiSigLong = []*EmbeddedFunctionData{
+		{"validMultiSigED25519", 4, evalValidMultiSigED25519},
+	}
+	embedChainHashLong = []*EmbeddedFunctionData{
+		{"chainHash", 2, evalChainHash},
+	}
+	embedWord32Long = []*EmbeddedFunctionData{
+		{"word32At", 2, evalWord32At},
+		{"setWord32", 3, evalSetWord32},
+		{"numWords32", 1, evalNumWords32},
+	}
+	embedApplyNLong = func(lib *Library) []*EmbeddedFunctionData {
+		return []*EmbeddedFunctionData{
+			{"applyN", 3, lib.evalApplyN},
+		}
+	}
+	embedUint128Long = []*EmbeddedFunctionData{
+		{"add128", 2, evalAdd128},
+		{"sub128", 2, evalSub128},
+		{"mul64to128", 2, evalMul64to128},
+		{"cmp128", 2, evalCmp128},
+	}
 	embedBytecodeManipulation = func(lib *Library) []*EmbeddedFunctionData {
 		return []*EmbeddedFunctionData{
 			{"parseArgumentBytecode", 3, lib.evalParseArgumentBytecode},
@@ -70,11 +125,24 @@ var (
 	}
 )
 
+// embedded functions which evaluate arguments lazily as part of their semantics. They can't be made eager
+var lazyArgsBase = []string{"if", "and", "or", "cond", "requireErr"}
+
+// embedded functions which always evaluate all arguments
+var eagerArgsBase = []string{
+	"slice", "byte", "tail", "equal", "hasPrefix", "concat", "repeat",
+	"add", "sub", "mul", "div", "mod", "scaleUp", "scaleDown",
+	"lessThan", "bitwiseOR", "bitwiseAND", "bitwiseXOR", "lshift64", "rshift64",
+	"validSignatureED25519", "blake2b", "containsBytes", "prand",
+	"add128", "sub128", "mul64to128", "cmp128", "equalMasked", "validMultiSigED25519",
+	"chainHash", "word32At", "setWord32", "numWords32", "applyN",
+}
+
 // embedding functions with inline tests
 
 func (lib *Library) embedMain() {
-	lib.UpgradeWithEmbeddedShort(embedShortBase...)
-	lib.UpgradeWthEmbeddedLong(embedLongBase...)
+	lib.UpgradeWithEmbeddedShort(embedShortBase(lib)...)
+	lib.UpgradeWthEmbeddedLong(embedLongBase(lib)...)
 
 	// inline tests
 	lib.MustEqual("concat", "0x")
@@ -106,13 +174,19 @@ func (lib *Library) embedMain() {
 	lib.MustTrue("not(or(concat))")
 	lib.MustTrue("or(1)")
 
+	// short-circuit
+	lib.MustTrue("not(and(1, nil, fail(1)))")
+	lib.MustTrue("or(nil, 1, fail(1))")
+	lib.MustError("and(1, fail(1), nil)", "error #1")
+	lib.MustError("or(nil, fail(1), 1)", "error #1")
+
 	lib.MustTrue("isZero(0)")
 	lib.MustTrue("isZero(repeat(0,100))")
 	lib.MustTrue("not(isZero(0x0000000003))")
 }
 
 func (lib *Library) embedArithmetics() {
-	lib.UpgradeWithEmbeddedShort(embedArithmeticsShort...)
+	lib.UpgradeWithEmbeddedShort(embedArithmeticsShort(lib)...)
 
 	lib.MustEqual("add(5,6)", "add(10,1)")
 	lib.MustEqual("add(5,6)", "u64/11")
@@ -125,7 +199,20 @@ func (lib *Library) embedArithmetics() {
 	lib.MustEqual("sub(0, 0)", "u64/0")
 	lib.MustEqual("sub(u16/1337, 0)", "u64/1337")
 	lib.MustError("sub(nil, 0)", "wrong size of parameter")
-	lib.MustError("sub(10, 100)", "underflow in subtraction")
+	switch lib.arithmetic {
+	case ArithmeticDefault:
+		lib.MustError("sub(10, 100)", "underflow in subtraction")
+		lib.MustEqual("add(u64/18446744073709551615, 2)", "u64/1")
+		lib.MustEqual("mul(u64/4294967296, u64/4294967297)", "u64/4294967296")
+	case ArithmeticStrict:
+		lib.MustError("sub(10, 100)", "underflow in subtraction")
+		lib.MustError("add(u64/18446744073709551615, 1)", "overflow in addition")
+		lib.MustError("mul(u64/4294967296, u64/4294967296)", "overflow in multiplication")
+	case ArithmeticWrapping:
+		lib.MustEqual("sub(10, 100)", "u64/18446744073709551526")
+		lib.MustEqual("add(u64/18446744073709551615, 2)", "u64/1")
+		lib.MustEqual("mul(u64/4294967296, u64/4294967297)", "u64/4294967296")
+	}
 
 	lib.MustEqual("mul(5,6)", "mul(15,2)")
 	lib.MustEqual("mul(5,6)", "u64/30")
@@ -207,18 +294,219 @@ func (lib *Library) embedBytecodeManipulation() {
 	lib.MustEqual(src, "#slice")
 }
 
+// embedDecimalScaling is called after all other base embedded functions to keep their function codes stable
+func (lib *Library) embedDecimalScaling() {
+	lib.UpgradeWthEmbeddedLong(embedArithmeticsLong...)
+
+	lib.MustEqual("scaleUp(5, 0)", "u64/5")
+	lib.MustEqual("scaleUp(5, 3)", "u64/5000")
+	lib.MustEqual("scaleUp(u16/1337, 15)", "u64/1337000000000000000")
+	lib.MustEqual("scaleUp(1, 19)", "u64/10000000000000000000")
+	lib.MustError("scaleUp(2, 19)", "overflow")
+	lib.MustError("scaleUp(1, 20)", "wrong number of decimals")
+	lib.MustError("scaleUp(nil, 1)", "wrong size of parameter")
+
+	lib.MustEqual("scaleDown(u64/5000, 3)", "u64/5")
+	lib.MustEqual("scaleDown(u64/5999, 3)", "u64/5")
+	lib.MustEqual("scaleDown(u64/5999, 19)", "u64/0")
+	lib.MustEqual("scaleDown(u64/10000000000000000000, 19)", "u64/1")
+	lib.MustError("scaleDown(1, 20)", "wrong number of decimals")
+}
+
+func (lib *Library) embedStrings() {
+	lib.UpgradeWthEmbeddedLong(embedStringsLong...)
+
+	lib.MustTrue("validUTF8(nil)")
+	lib.MustTrue("validUTF8(0x616263)")
+	lib.MustTrue("validUTF8(0xc3a9)")
+	lib.MustTrue("not(validUTF8(0xc3))")
+	lib.MustTrue("not(validUTF8(0xff))")
+
+	lib.MustTrue("containsBytes(0x01020304, 0x0203)")
+	lib.MustTrue("containsBytes(0x01020304, nil)")
+	lib.MustTrue("not(containsBytes(0x01020304, 0x0204))")
+	lib.MustTrue("not(containsBytes(nil, 1))")
+}
+
+func (lib *Library) embedPseudoRandom() {
+	lib.UpgradeWthEmbeddedLong(embedPseudoRandomLong...)
+
+	h0 := blake2b.Sum256([]byte{1, 2, 3, 0})
+	h1 := blake2b.Sum256([]byte{1, 2, 3, 1})
+	lib.MustEqual("prand(0x010203, 5)", fmt.Sprintf("0x%s", hex.EncodeToString(h0[:5])))
+	lib.MustEqual("prand(0x010203, 40)", fmt.Sprintf("0x%s%s", hex.EncodeToString(h0[:]), hex.EncodeToString(h1[:8])))
+	lib.MustEqual("len(prand(nil, 255))", "u64/255")
+	lib.MustEqual("prand(0x010203, 0)", "nil")
+	lib.MustError("prand(0x010203, u16/1)", "1-byte")
+}
+
+func (lib *Library) embedRequireErr() {
+	lib.UpgradeWthEmbeddedLong(embedRequireErrLong(lib)...)
+
+	lib.MustTrue("requireErr(1, u16/1000)")
+	lib.MustTrue("requireErr(1, nil)")
+	lib.MustError("requireErr(nil, u16/1000)", "SCRIPT FAIL: error #1000")
+	lib.MustError("requireErr(nil, 1)", "error code must be 2 bytes")
+}
+
+func (lib *Library) embedCond() {
+	lib.UpgradeWthEmbeddedLong(embedCondLong(lib)...)
+
+	lib.MustEqual("cond(5)", "5")
+	lib.MustEqual("cond(1, 2, 3)", "2")
+	lib.MustEqual("cond(nil, 2, 3)", "3")
+	lib.MustEqual("cond(nil, 2, 1, 4, 5)", "4")
+	lib.MustEqual("cond(nil, fail(1), nil, fail(2), 5)", "5")
+	lib.MustEqual("cond(1, 2, fail(1), fail(2), fail(3))", "2")
+	lib.MustError("cond", "odd number")
+	lib.MustError("cond(1, 2)", "odd number")
+}
+
+func (lib *Library) embedUint128() {
+	lib.UpgradeWthEmbeddedLong(embedUint128Long...)
+
+	lib.MustEqual("add128(5, 6)", "0x0000000000000000000000000000000b")
+	lib.MustEqual("add128(0xffffffffffffffff, 1)", "0x00000000000000010000000000000000")
+	lib.MustEqual("add128(u64/1, u64/2)", "add128(2, 1)")
+	lib.MustError("add128(0xffffffffffffffffffffffffffffffff, 1)", "overflow in addition")
+	lib.MustError("add128(nil, 1)", "wrong size of parameter")
+	lib.MustError("add128(0x0000000000000000000000000000000000, 1)", "wrong size of parameter")
+
+	lib.MustEqual("sub128(0x00000000000000010000000000000000, 1)", "0x0000000000000000ffffffffffffffff")
+	lib.MustEqual("sub128(6, 6)", "0x00000000000000000000000000000000")
+	lib.MustError("sub128(5, 6)", "underflow in subtraction")
+
+	lib.MustEqual("mul64to128(5, 6)", "0x0000000000000000000000000000001e")
+	lib.MustEqual("mul64to128(0xffffffffffffffff, 0xffffffffffffffff)", "0xfffffffffffffffe0000000000000001")
+	lib.MustError("mul64to128(0x000000000000000000, 1)", "wrong size of parameter")
+
+	lib.MustEqual("cmp128(5, 5)", "0x00")
+	lib.MustEqual("cmp128(6, 5)", "0x01")
+	lib.MustEqual("cmp128(5, u64/6)", "0xff")
+	lib.MustEqual("cmp128(0x00000000000000010000000000000000, 0xffffffffffffffff)", "0x01")
+}
+
+func (lib *Library) embedMultiSig() {
+	lib.UpgradeWthEmbeddedLong(embedMultiSigLong...)
+
+	msg := []byte("message")
+	pubKeys := make([]byte, 0)
+	sigs := make([][]byte, 3)
+	for i := range sigs {
+		privKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i)}, ed25519.SeedSize))
+		pubKeys = append(pubKeys, privKey.Public().(ed25519.PublicKey)...)
+		sigs[i] = append([]byte{byte(i)}, ed25519.Sign(privKey, msg)...)
+	}
+	src := func(m int, entries ...[]byte) string {
+		// inline data is up to 127 bytes long, so each entry is a separate literal
+		entriesSrc := make([]string, len(entries))
+		for i := range entries {
+			entriesSrc[i] = "0x" + hex.EncodeToString(entries[i])
+		}
+		return fmt.Sprintf("validMultiSigED25519(0x%s, %d, 0x%s, concat(%s))",
+			hex.EncodeToString(msg), m, hex.EncodeToString(pubKeys), strings.Join(entriesSrc, ","))
+	}
+	lib.MustTrue(src(2, sigs[0], sigs[2]))
+	lib.MustTrue(src(2, sigs[2], sigs[1], sigs[0]))
+	lib.MustError(src(0), "wrong m-of-n: 0 of 3")
+	lib.MustError(src(4, sigs[0], sigs[1], sigs[2]), "wrong m-of-n: 4 of 3")
+	lib.MustTrue("not(" + src(2, sigs[1]) + ")")
+	lib.MustTrue("not(" + src(2, sigs[1], sigs[1]) + ")")
+	wrongKeyIndex := append([]byte{1}, sigs[0][1:]...)
+	lib.MustTrue("not(" + src(2, sigs[0], wrongKeyIndex) + ")")
+	lib.MustError(src(2, sigs[0], sigs[1][:10]), "wrong length of signatures")
+	lib.MustError(src(2, append([]byte{3}, sigs[0][1:]...)), "key index 3 is out of range")
+}
+
+func (lib *Library) embedChainHash() {
+	lib.UpgradeWthEmbeddedLong(embedChainHashLong...)
+
+	h := blake2b.Sum256([]byte{1, 2, 3})
+	lib.MustEqual("chainHash(0x01, 0x0203)", fmt.Sprintf("0x%s", hex.EncodeToString(h[:])))
+	lib.MustEqual("chainHash(0x01, 0x0203)", "blake2b(0x01, 0x0203)")
+	lib.MustEqual("chainHash(nil, 0x010203)", "blake2b(0x010203)")
+	lib.MustEqual("chainHash(nil, nil)", "blake2b")
+}
+
+func (lib *Library) embedWord32() {
+	lib.UpgradeWthEmbeddedLong(embedWord32Long...)
+
+	w0 := strings.Repeat("00", 32)
+	w1 := strings.Repeat("11", 32)
+	w2 := strings.Repeat("22", 32)
+	lib.MustEqual(fmt.Sprintf("word32At(0x%s%s%s, 0)", w0, w1, w2), "0x"+w0)
+	lib.MustEqual(fmt.Sprintf("word32At(0x%s%s%s, 2)", w0, w1, w2), "0x"+w2)
+	lib.MustEqual(fmt.Sprintf("word32At(0x%s%s%s, u64/1)", w0, w1, w2), "0x"+w1)
+	lib.MustError(fmt.Sprintf("word32At(0x%s%s%s, 3)", w0, w1, w2), "word index 3 is out of range")
+	lib.MustError(fmt.Sprintf("word32At(0x%s, u64/18446744073709551615)", w0), "out of range")
+	lib.MustError(fmt.Sprintf("word32At(0x%s01, 0)", w0), "not a multiple of 32")
+	lib.MustError(fmt.Sprintf("word32At(0x%s, nil)", w0), "wrong size of word index")
+	lib.MustError("word32At(nil, 0)", "out of range")
+
+	lib.MustEqual(fmt.Sprintf("setWord32(0x%s%s, 1, 0x%s)", w0, w1, w2), fmt.Sprintf("0x%s%s", w0, w2))
+	lib.MustEqual(fmt.Sprintf("setWord32(0x%s%s, 0, 0x%s)", w0, w1, w2), fmt.Sprintf("0x%s%s", w2, w1))
+	lib.MustError(fmt.Sprintf("setWord32(0x%s%s, 2, 0x%s)", w0, w1, w2), "out of range")
+	lib.MustError(fmt.Sprintf("setWord32(0x%s%s, 1, 0x%s01)", w0, w1, w2), "word must be 32 bytes")
+
+	lib.MustEqual("numWords32(nil)", "u64/0")
+	lib.MustEqual(fmt.Sprintf("numWords32(0x%s%s%s)", w0, w1, w2), "u64/3")
+	lib.MustError(fmt.Sprintf("numWords32(0x%s01)", w0), "not a multiple of 32")
+}
+
+func (lib *Library) embedApplyN() {
+	lib.UpgradeWthEmbeddedLong(embedApplyNLong(lib)...)
+
+	bytecode := func(src string) string {
+		_, _, code, err := lib.CompileExpression(src)
+		AssertNoError(err)
+		return "0x" + hex.EncodeToString(code)
+	}
+	// tests depend on the limit of iterations of the library
+	limit := lib.maxApplyIterations()
+	n := 1000
+	if limit < n {
+		n = limit
+	}
+	lib.MustEqual(fmt.Sprintf("applyN(%s, 0, 0x02)", bytecode("concat($0, 1)")), "0x02")
+	lib.MustEqual(fmt.Sprintf("applyN(%s, u64/%d, 0)", bytecode("add($0, 1)"), n), fmt.Sprintf("u64/%d", n))
+	lib.MustError(fmt.Sprintf("applyN(%s, u64/%d, 0)", bytecode("add($0, 1)"), limit+1), fmt.Sprintf("count %d exceeds limit %d", limit+1, limit))
+	lib.MustError(fmt.Sprintf("applyN(%s, nil, 0)", bytecode("add($0, 1)")), "wrong size of count")
+	lib.MustError("applyN(0xffff, 0, 0)", "applyN")
+	if limit >= 3 {
+		lib.MustEqual(fmt.Sprintf("applyN(%s, 3, nil)", bytecode("concat($0, 1)")), "0x010101")
+		lib.MustEqual(fmt.Sprintf("applyN(%s, u64/2, 1)", bytecode("blake2b($0)")), "blake2b(blake2b(1))")
+		lib.MustError(fmt.Sprintf("applyN(%s, 3, 0)", bytecode("fail(7)")), "SCRIPT FAIL: error #7")
+		lib.MustError("applyN(0xffff, 3, 0)", "applyN")
+	}
+}
+
+func (lib *Library) embedEqualMasked() {
+	lib.UpgradeWthEmbeddedLong(embedEqualMaskedLong...)
+
+	lib.MustTrue("equalMasked(0x1234, 0x1299, 0xff00)")
+	lib.MustTrue("not(equalMasked(0x1234, 0x1299, 0xff0f))")
+	lib.MustTrue("equalMasked(0x0f, 0xff, 0x0f)")
+	lib.MustTrue("equalMasked(0x1234, 0x5678, 0x0000)")
+	lib.MustTrue("equalMasked(nil, nil, nil)")
+	lib.MustError("equalMasked(0x1234, 0x12, 0xff00)", "equal length arguments expected")
+	lib.MustError("equalMasked(0x1234, 0x1234, 0xff)", "equal length arguments expected")
+}
+
 // -----------------------------------------------------------------
 
 func isNil(p interface{}) bool {
 	return p == nil || (reflect.ValueOf(p).Kind() == reflect.Ptr && reflect.ValueOf(p).IsNil())
 }
 
-func evalFail(par *CallParams) []byte {
+func (lib *Library) evalFail(par *CallParams) []byte {
 	c := par.Arg(0)
 	if len(c) == 1 {
-		par.TracePanic("SCRIPT FAIL: error #%d", c[0])
+		if fc, found := lib.FailCodeInfo(c[0]); found {
+			par.failWithCode(uint16(c[0]), "error #%d: %s", c[0], fc)
+		}
+		par.failWithCode(uint16(c[0]), "error #%d", c[0])
 	}
-	par.TracePanic("SCRIPT FAIL: '%s'", string(c))
+	par.Fail("'%s'", string(c))
 	return nil
 }
 
@@ -292,6 +580,7 @@ func evalRepeat(par *CallParams) []byte {
 	if len(n) != 1 {
 		par.TracePanic("evalRepeat: count must be 1-byte long")
 	}
+	par.ConsumeGas(copyingGas(len(fragment) * int(n[0])))
 	ret := bytes.Repeat(fragment, int(n[0]))
 	par.Trace("hasPrefix:: %s, %s -> %s", Fmt(fragment), Fmt(n), Fmt(ret))
 	return ret
@@ -317,12 +606,33 @@ func evalIf(par *CallParams) []byte {
 	return no
 }
 
-func evalFirstCaseIndex(par *CallParams) []byte {
+// evalCond returns value of the first true condition in the pairs condition, value. The last argument is the default.
+// Only the conditions up to the first true one and the selected value are evaluated
+func (lib *Library) evalCond(par *CallParams) []byte {
+	n := par.Arity()
+	if n%2 == 0 {
+		par.TracePanic("cond:: odd number of arguments expected, got %d", n)
+	}
+	for i := byte(0); i+1 < n; i += 2 {
+		if c := par.Arg(i); len(c) != 0 {
+			ret := par.Arg(i + 1)
+			par.Trace("cond:: case %d -> %s", i/2, Fmt(ret))
+			return ret
+		}
+		lib.countFailedBranch(par)
+	}
+	ret := par.Arg(n - 1)
+	par.Trace("cond:: default -> %s", Fmt(ret))
+	return ret
+}
+
+func (lib *Library) evalFirstCaseIndex(par *CallParams) []byte {
 	for i := byte(0); i < par.Arity(); i++ {
 		if ret := par.Arg(i); len(ret) > 0 {
 			par.Trace("firstCaseIndex:: -> %d", i)
 			return []byte{i}
 		}
+		lib.countFailedBranch(par)
 	}
 	par.Trace("firstCaseIndex:: -> nil")
 	return nil
@@ -377,16 +687,45 @@ func evalNot(par *CallParams) []byte {
 	return nil
 }
 
+func evalValidUTF8(par *CallParams) []byte {
+	data := par.Arg(0)
+	if utf8.Valid(data) {
+		par.Trace("validUTF8:: %s -> true", Fmt(data))
+		return []byte{0xff}
+	}
+	par.Trace("validUTF8:: %s -> false", Fmt(data))
+	return nil
+}
+
+func evalContainsBytes(par *CallParams) []byte {
+	data := par.Arg(0)
+	sub := par.Arg(1)
+	if bytes.Contains(data, sub) {
+		par.Trace("containsBytes:: %s, %s -> true", Fmt(data), Fmt(sub))
+		return []byte{0xff}
+	}
+	par.Trace("containsBytes:: %s, %s -> false", Fmt(data), Fmt(sub))
+	return nil
+}
+
 func evalConcat(par *CallParams) []byte {
+	args := par.Args()
+	size := 0
+	for _, arg := range args {
+		size += len(arg)
+	}
+	par.ConsumeGas(copyingGas(size))
 	var buf bytes.Buffer
-	for i := byte(0); i < par.Arity(); i++ {
-		buf.Write(par.Arg(i))
+	for _, arg := range args {
+		buf.Write(arg)
 	}
 	ret := buf.Bytes()
 	par.Trace("Concat:: %d params -> %s", par.Arity(), Fmt(ret))
 	return ret
 }
 
+// evalAnd evaluates arguments left to right and stops at the first false (empty) one.
+// Arguments after it are not evaluated. It is part of the language semantics, not an optimization
 func evalAnd(par *CallParams) []byte {
 	for i := byte(0); i < par.Arity(); i++ {
 		if len(par.Arg(i)) == 0 {
@@ -398,12 +737,15 @@ func evalAnd(par *CallParams) []byte {
 	return []byte{0xff}
 }
 
-func evalOr(par *CallParams) []byte {
+// evalOr evaluates arguments left to right and stops at the first true (non-empty) one.
+// Arguments after it are not evaluated. It is part of the language semantics, not an optimization
+func (lib *Library) evalOr(par *CallParams) []byte {
 	for i := byte(0); i < par.Arity(); i++ {
 		if len(par.Arg(i)) != 0 {
 			par.Trace("or:: param %d -> true", i)
 			return []byte{0xff}
 		}
+		lib.countFailedBranch(par)
 	}
 	par.Trace("or:: %d params -> nil", par.Arity())
 	return nil
@@ -435,16 +777,20 @@ func mustArithmeticArgs(par *CallParams, name string) (uint64, uint64) {
 	return binary.BigEndian.Uint64(a0), binary.BigEndian.Uint64(a1)
 }
 
-func evalAddUint(par *CallParams) []byte {
+func (lib *Library) evalAddUint(par *CallParams) []byte {
 	a0, a1 := mustArithmeticArgs(par, "addUint")
+	sum, carry := bits.Add64(a0, a1, 0)
+	if carry != 0 && lib.arithmetic == ArithmeticStrict {
+		par.TracePanic("evalAddUint:: %d + %d -> overflow in addition", a0, a1)
+	}
 	var ret [8]byte
-	binary.BigEndian.PutUint64(ret[:], a0+a1)
+	binary.BigEndian.PutUint64(ret[:], sum)
 	return ret[:]
 }
 
-func evalSubUint(par *CallParams) []byte {
+func (lib *Library) evalSubUint(par *CallParams) []byte {
 	a0, a1 := mustArithmeticArgs(par, "subUint")
-	if a0 < a1 {
+	if a0 < a1 && lib.arithmetic != ArithmeticWrapping {
 		par.TracePanic("evalSubUint:: %d - %d -> underflow in subtraction", a0, a1)
 	}
 	var ret [8]byte
@@ -452,10 +798,14 @@ func evalSubUint(par *CallParams) []byte {
 	return ret[:]
 }
 
-func evalMulUint(par *CallParams) []byte {
+func (lib *Library) evalMulUint(par *CallParams) []byte {
 	a0, a1 := mustArithmeticArgs(par, "mulUint")
+	hi, lo := bits.Mul64(a0, a1)
+	if hi != 0 && lib.arithmetic == ArithmeticStrict {
+		par.TracePanic("evalMulUint:: %d * %d -> overflow in multiplication", a0, a1)
+	}
 	var ret [8]byte
-	binary.BigEndian.PutUint64(ret[:], a0*a1)
+	binary.BigEndian.PutUint64(ret[:], lo)
 	return ret[:]
 }
 
@@ -473,6 +823,121 @@ func evalModuloUint(par *CallParams) []byte {
 	return ret[:]
 }
 
+// powersOf10 are all powers of 10 which fit uint64
+var powersOf10 = func() []uint64 {
+	ret := make([]uint64, 20)
+	ret[0] = 1
+	for i := 1; i < len(ret); i++ {
+		ret[i] = ret[i-1] * 10
+	}
+	return ret
+}()
+
+func mustDecimalScaleArgs(par *CallParams, name string) (uint64, uint64) {
+	a, decimals := mustArithmeticArgs(par, name)
+	if decimals >= uint64(len(powersOf10)) {
+		par.TracePanic("%s:: wrong number of decimals %d", name, decimals)
+	}
+	return a, powersOf10[decimals]
+}
+
+// evalScaleUp multiplies uint64 value by 10^decimals. Panics on overflow
+func evalScaleUp(par *CallParams) []byte {
+	a, scale := mustDecimalScaleArgs(par, "scaleUp")
+	hi, lo := bits.Mul64(a, scale)
+	if hi != 0 {
+		par.TracePanic("scaleUp:: %d * %d -> overflow", a, scale)
+	}
+	var ret [8]byte
+	binary.BigEndian.PutUint64(ret[:], lo)
+	return ret[:]
+}
+
+// evalScaleDown divides uint64 value by 10^decimals. The result is truncated
+func evalScaleDown(par *CallParams) []byte {
+	a, scale := mustDecimalScaleArgs(par, "scaleDown")
+	var ret [8]byte
+	binary.BigEndian.PutUint64(ret[:], a/scale)
+	return ret[:]
+}
+
+// ensureUint128Bytes pads not empty data up to 16 bytes
+func ensureUint128Bytes(data []byte) ([]byte, bool) {
+	if len(data) == 16 {
+		return data, true
+	}
+	if len(data) == 0 || len(data) > 16 {
+		return nil, false
+	}
+	ret := make([]byte, 16)
+	copy(ret[16-len(data):], data)
+	return ret, true
+}
+
+// mustUint128Args makes pairs (hi, lo) of uint64 from both params (bigendian)
+// Parameters must be not nil with size <= 16. They are padded with 0 in upper bytes, if necessary
+func mustUint128Args(par *CallParams, name string) (hi0, lo0, hi1, lo1 uint64) {
+	a0, ok := ensureUint128Bytes(par.Arg(0))
+	if !ok {
+		par.TracePanic("%s:: wrong size of parameter 0", name)
+	}
+	a1, ok := ensureUint128Bytes(par.Arg(1))
+	if !ok {
+		par.TracePanic("%s:: wrong size of parameter 1", name)
+	}
+	return binary.BigEndian.Uint64(a0[:8]), binary.BigEndian.Uint64(a0[8:]),
+		binary.BigEndian.Uint64(a1[:8]), binary.BigEndian.Uint64(a1[8:])
+}
+
+func uint128Bytes(hi, lo uint64) []byte {
+	ret := make([]byte, 16)
+	binary.BigEndian.PutUint64(ret[:8], hi)
+	binary.BigEndian.PutUint64(ret[8:], lo)
+	return ret
+}
+
+// evalAdd128 adds two 16-byte bigendian values. Panics on overflow
+func evalAdd128(par *CallParams) []byte {
+	hi0, lo0, hi1, lo1 := mustUint128Args(par, "add128")
+	lo, carry := bits.Add64(lo0, lo1, 0)
+	hi, carry := bits.Add64(hi0, hi1, carry)
+	if carry != 0 {
+		par.TracePanic("add128:: overflow in addition")
+	}
+	return uint128Bytes(hi, lo)
+}
+
+// evalSub128 subtracts two 16-byte bigendian values. Panics on underflow
+func evalSub128(par *CallParams) []byte {
+	hi0, lo0, hi1, lo1 := mustUint128Args(par, "sub128")
+	lo, borrow := bits.Sub64(lo0, lo1, 0)
+	hi, borrow := bits.Sub64(hi0, hi1, borrow)
+	if borrow != 0 {
+		par.TracePanic("sub128:: underflow in subtraction")
+	}
+	return uint128Bytes(hi, lo)
+}
+
+// evalMul64to128 multiplies two uint64 values into 16-byte bigendian value. It never overflows
+func evalMul64to128(par *CallParams) []byte {
+	a0, a1 := mustArithmeticArgs(par, "mul64to128")
+	return uint128Bytes(bits.Mul64(a0, a1))
+}
+
+// evalCmp128 compares two 16-byte bigendian values. Returns 0x00 if equal, 0x01 if the first is bigger
+// and 0xff if the first is smaller
+func evalCmp128(par *CallParams) []byte {
+	hi0, lo0, hi1, lo1 := mustUint128Args(par, "cmp128")
+	switch {
+	case hi0 == hi1 && lo0 == lo1:
+		return []byte{0}
+	case hi0 > hi1 || hi0 == hi1 && lo0 > lo1:
+		return []byte{1}
+	default:
+		return []byte{0xff}
+	}
+}
+
 func evalUint64Bytes(par *CallParams) []byte {
 	ret, ok := ensureUint64Bytes(par.Arg(0))
 	if !ok {
@@ -507,6 +972,7 @@ func evalValidSigED25519(par *CallParams) []byte {
 	signature := par.Arg(1)
 	pubKey := par.Arg(2)
 
+	par.ConsumeGas(hashingGas(len(msg)))
 	if ed25519.Verify(pubKey, msg, signature) {
 		par.Trace("ValidSigED25519: msg=%s, sig=%s, pubKey=%s -> true",
 			Fmt(msg), Fmt(signature), Fmt(pubKey))
@@ -517,16 +983,175 @@ func evalValidSigED25519(par *CallParams) []byte {
 	return nil
 }
 
+// evalValidMultiSigED25519 verifies m-of-n multi-signature:
+//   - $0 is the message
+//   - $1 is 1-byte m
+//   - $2 is concatenation of n 32-byte public keys
+//   - $3 is concatenation of signature entries, each 1-byte index of the public key followed by 64-byte signature
+//
+// It is true if there are valid signatures of at least m distinct public keys. Entries with invalid signatures
+// and repeating keys are not counted. m must be from 1 to n. Each verified signature consumes gas
+func evalValidMultiSigED25519(par *CallParams) []byte {
+	msg := par.Arg(0)
+	m := par.Arg(1)
+	pubKeys := par.Arg(2)
+	entries := par.Arg(3)
+	const entrySize = 1 + ed25519.SignatureSize
+	if len(m) != 1 {
+		par.TracePanic("validMultiSigED25519: m must be 1 byte")
+	}
+	if len(pubKeys)%ed25519.PublicKeySize != 0 {
+		par.TracePanic("validMultiSigED25519: wrong length of public keys %d", len(pubKeys))
+	}
+	if len(entries)%entrySize != 0 {
+		par.TracePanic("validMultiSigED25519: wrong length of signatures %d", len(entries))
+	}
+	numKeys := len(pubKeys) / ed25519.PublicKeySize
+	if m[0] == 0 || int(m[0]) > numKeys {
+		par.TracePanic("validMultiSigED25519: wrong m-of-n: %d of %d", m[0], numKeys)
+	}
+	signed := make(map[string]struct{})
+	for i := 0; i < len(entries) && len(signed) < int(m[0]); i += entrySize {
+		idx := int(entries[i])
+		if idx >= numKeys {
+			par.TracePanic("validMultiSigED25519: key index %d is out of range", idx)
+		}
+		pubKey := pubKeys[idx*ed25519.PublicKeySize : (idx+1)*ed25519.PublicKeySize]
+		if _, already := signed[string(pubKey)]; already {
+			continue
+		}
+		par.ConsumeGas(signatureGas(len(msg)))
+		if ed25519.Verify(pubKey, msg, entries[i+1:i+entrySize]) {
+			signed[string(pubKey)] = struct{}{}
+		}
+	}
+	if len(signed) >= int(m[0]) {
+		par.Trace("validMultiSigED25519: msg=%s, m=%d, %d keys -> true", Fmt(msg), m[0], numKeys)
+		return []byte{0xff}
+	}
+	par.Trace("validMultiSigED25519: msg=%s, m=%d, %d keys -> false", Fmt(msg), m[0], numKeys)
+	return nil
+}
+
 func evalBlake2b(par *CallParams) []byte {
 	var buf bytes.Buffer
 	for i := byte(0); i < par.Arity(); i++ {
 		buf.Write(par.Arg(i))
 	}
+	par.ConsumeGas(hashingGas(buf.Len()))
 	ret := blake2b.Sum256(buf.Bytes())
 	par.Trace("blake2b: %d params -> %s", par.Arity(), Fmt(ret[:]))
 	return ret[:]
 }
 
+// evalChainHash is blake2b(prev || data) without concatenating arguments into a new buffer
+func evalChainHash(par *CallParams) []byte {
+	prev := par.Arg(0)
+	data := par.Arg(1)
+	par.ConsumeGas(hashingGas(len(prev) + len(data)))
+	h, _ := blake2b.New256(nil)
+	h.Write(prev)
+	h.Write(data)
+	ret := h.Sum(nil)
+	par.Trace("chainHash: %s, %s -> %s", Fmt(prev), Fmt(data), Fmt(ret))
+	return ret
+}
+
+// evalPRand expands the seed into n pseudo-random bytes: blake2b(seed|0) || blake2b(seed|1) || ...
+func evalPRand(par *CallParams) []byte {
+	seed := par.Arg(0)
+	n := par.Arg(1)
+	if len(n) != 1 {
+		par.TracePanic("prand: number of bytes must be 1-byte long")
+	}
+	numHashes := (int(n[0]) + blake2b.Size256 - 1) / blake2b.Size256
+	par.ConsumeGas(uint64(numHashes) * hashingGas(len(seed)+1))
+	ret := make([]byte, 0, int(n[0])+blake2b.Size256)
+	buf := make([]byte, len(seed)+1)
+	copy(buf, seed)
+	for counter := byte(0); len(ret) < int(n[0]); counter++ {
+		buf[len(seed)] = counter
+		h := blake2b.Sum256(buf)
+		ret = append(ret, h[:]...)
+	}
+	ret = ret[:n[0]]
+	par.Trace("prand:: %s, %s -> %s", Fmt(seed), Fmt(n), Fmt(ret))
+	return ret
+}
+
+// mustWords32 returns the first argument, which must be a sequence of 32-byte words
+func mustWords32(par *CallParams, name string) []byte {
+	data := par.Arg(0)
+	if len(data)%32 != 0 {
+		par.TracePanic("%s: length of data %d is not a multiple of 32", name, len(data))
+	}
+	return data
+}
+
+// mustWord32Index returns position of the 32-byte word in the data. The index is up to 8 bytes bigendian
+func mustWord32Index(par *CallParams, name string, data []byte) int {
+	idxBytes, ok := ensureUint64Bytes(par.Arg(1))
+	if !ok {
+		par.TracePanic("%s: wrong size of word index %s", name, Fmt(par.Arg(1)))
+	}
+	idx := binary.BigEndian.Uint64(idxBytes)
+	if idx >= uint64(len(data)/32) {
+		par.TracePanic("%s: word index %d is out of range, number of words: %d", name, idx, len(data)/32)
+	}
+	return int(idx) * 32
+}
+
+// evalWord32At returns 32-byte word of the data with the index
+func evalWord32At(par *CallParams) []byte {
+	data := mustWords32(par, "word32At")
+	pos := mustWord32Index(par, "word32At", data)
+	ret := data[pos : pos+32]
+	par.Trace("word32At: %s, %s -> %s", Fmt(data), Fmt(par.Arg(1)), Fmt(ret))
+	return ret
+}
+
+// evalSetWord32 returns copy of the data with the 32-byte word with the index replaced
+func evalSetWord32(par *CallParams) []byte {
+	data := mustWords32(par, "setWord32")
+	pos := mustWord32Index(par, "setWord32", data)
+	word := par.Arg(2)
+	if len(word) != 32 {
+		par.TracePanic("setWord32: word must be 32 bytes, got %s", Fmt(word))
+	}
+	ret := make([]byte, len(data))
+	copy(ret, data)
+	copy(ret[pos:], word)
+	par.Trace("setWord32: %s, %s, %s -> %s", Fmt(data), Fmt(par.Arg(1)), Fmt(word), Fmt(ret))
+	return ret
+}
+
+// evalNumWords32 returns number of 32-byte words in the data as 8-byte bigendian
+func evalNumWords32(par *CallParams) []byte {
+	data := mustWords32(par, "numWords32")
+	var ret [8]byte
+	binary.BigEndian.PutUint64(ret[:], uint64(len(data)/32))
+	par.Trace("numWords32: %s -> %s", Fmt(data), Fmt(ret[:]))
+	return ret[:]
+}
+
+// evalEqualMasked compares only bits set in the mask. All arguments must be of equal length
+func evalEqualMasked(par *CallParams) []byte {
+	a0 := par.Arg(0)
+	a1 := par.Arg(1)
+	mask := par.Arg(2)
+	if len(a0) != len(a1) || len(a0) != len(mask) {
+		par.TracePanic("equalMasked: equal length arguments expected: %s, %s, %s", Fmt(a0), Fmt(a1), Fmt(mask))
+	}
+	for i := range mask {
+		if (a0[i]^a1[i])&mask[i] != 0 {
+			par.Trace("equalMasked: %s, %s, %s -> false", Fmt(a0), Fmt(a1), Fmt(mask))
+			return nil
+		}
+	}
+	par.Trace("equalMasked: %s, %s, %s -> true", Fmt(a0), Fmt(a1), Fmt(mask))
+	return []byte{0xff}
+}
+
 func evalBitwiseAND(par *CallParams) []byte {
 	a0 := par.Arg(0)
 	a1 := par.Arg(1)
@@ -658,8 +1283,13 @@ func (lib *Library) evalBytecodeArg(par *CallParams) []byte {
 		par.TracePanic("evalParseArgumentBytecode: wrong parameter index")
 	}
 
-	ret, err := lib.EvalFromBytecode(par.ctx.glb, args[idx[0]])
+	ret, err := lib.evalDynamic(par, args[idx[0]])
 	if err != nil {
+		panicIfEvalRecursion(err)
+		panicIfScriptFail(err)
+		panicIfBranchBudget(err)
+		panicIfOutOfGas(err)
+		panicIfApplyNBudget(err)
 		par.TracePanic("evalBytecodeArg:: %s, %s, %s", Fmt(a0), Fmt(expectedPrefix), Fmt(idx))
 	}
 
@@ -667,9 +1297,58 @@ func (lib *Library) evalBytecodeArg(par *CallParams) []byte {
 	return ret
 }
 
+// evalRequireErr returns true if condition is true, otherwise fails with the 2-byte error code.
+// The error code is evaluated only in case of failure
+func (lib *Library) evalRequireErr(par *CallParams) []byte {
+	if len(par.Arg(0)) != 0 {
+		return []byte{0xff}
+	}
+	c := par.Arg(1)
+	if len(c) != 2 {
+		par.TracePanic("requireErr: error code must be 2 bytes, got %s", Fmt(c))
+	}
+	code := binary.BigEndian.Uint16(c)
+	if msg, found := lib.ErrorCodeMessage(code); found {
+		par.failWithCode(code, "error #%d: %s", code, msg)
+	}
+	par.failWithCode(code, "error #%d", code)
+	return nil
+}
+
+// evalApplyN evaluates 1-parameter bytecode count times, each time with the result of the previous evaluation
+// as the parameter. The first parameter is the argument. The count is up to 8 bytes bigendian.
+// Iterations of all 'applyN' calls of the evaluation, including nested ones, are limited by the library
+func (lib *Library) evalApplyN(par *CallParams) []byte {
+	code := par.Arg(0)
+	countBytes, ok := ensureUint64Bytes(par.Arg(1))
+	if !ok {
+		par.TracePanic("applyN: wrong size of count %s", Fmt(par.Arg(1)))
+	}
+	count := binary.BigEndian.Uint64(countBytes)
+	if count > uint64(lib.maxApplyIterations()) {
+		par.TracePanic("applyN: count %d exceeds limit %d", count, lib.maxApplyIterations())
+	}
+	ret, err := lib.evalDynamicN(par, code, int(count), par.Arg(2))
+	if err != nil {
+		panicIfEvalRecursion(err)
+		panicIfScriptFail(err)
+		panicIfBranchBudget(err)
+		panicIfOutOfGas(err)
+		panicIfApplyNBudget(err)
+		par.TracePanic("applyN:: %v", err)
+	}
+	par.Trace("applyN:: %s, %d, %s -> %s", Fmt(code), count, Fmt(par.Arg(2)), Fmt(ret))
+	return ret
+}
+
 func (lib *Library) evalBytecode(par *CallParams) []byte {
-	ret, err := lib.EvalFromBytecode(par.ctx.glb, par.Arg(0))
+	ret, err := lib.evalDynamic(par, par.Arg(0))
 	if err != nil {
+		panicIfEvalRecursion(err)
+		panicIfScriptFail(err)
+		panicIfBranchBudget(err)
+		panicIfOutOfGas(err)
+		panicIfApplyNBudget(err)
 		par.TracePanic("evalBytecode:: %v", err)
 	}
 	par.Trace("evalBytecode:: %s} -> %s", Fmt(par.Arg(0)), Fmt(ret))
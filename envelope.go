@@ -0,0 +1,45 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Optional envelope of the stored bytecode, which tells the library version it was compiled with:
+// magic byte followed by the first bytes of the library hash
+
+const (
+	LibraryTagMagic = byte(0xfe)
+	LibraryTagSize  = 4
+)
+
+// LibraryTag returns envelope prefix of the library
+func (lib *Library) LibraryTag() []byte {
+	h := lib.LibraryHash()
+	ret := make([]byte, LibraryTagSize)
+	ret[0] = LibraryTagMagic
+	copy(ret[1:], h[:LibraryTagSize-1])
+	return ret
+}
+
+// WrapWithLibraryTag prefixes bytecode with the library tag
+func (lib *Library) WrapWithLibraryTag(code []byte) []byte {
+	return concat(lib.LibraryTag(), code)
+}
+
+// HasLibraryTag returns true if data starts with the envelope magic byte and is long enough to contain the tag
+func HasLibraryTag(data []byte) bool {
+	return len(data) >= LibraryTagSize && data[0] == LibraryTagMagic
+}
+
+// UnwrapAndVerifyTag checks if the data is bytecode wrapped with the tag of this library and returns the bytecode
+func (lib *Library) UnwrapAndVerifyTag(data []byte) ([]byte, error) {
+	if !HasLibraryTag(data) {
+		return nil, fmt.Errorf("UnwrapAndVerifyTag: bytecode is not wrapped with the library tag")
+	}
+	if tag := lib.LibraryTag(); !bytes.Equal(data[:LibraryTagSize], tag) {
+		return nil, fmt.Errorf("UnwrapAndVerifyTag: bytecode targets library %s, current library is %s",
+			Fmt(data[1:LibraryTagSize]), Fmt(tag[1:]))
+	}
+	return data[LibraryTagSize:], nil
+}
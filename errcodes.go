@@ -0,0 +1,78 @@
+package easyfl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RegisterErrorCode registers human-readable message for the error code of 'requireErr'.
+// The message is used only for formatting failures, it does not change semantics of scripts
+func (lib *Library) RegisterErrorCode(code uint16, msg string) error {
+	if lib.errorCodes == nil {
+		lib.errorCodes = make(map[uint16]string)
+	}
+	if prev, already := lib.errorCodes[code]; already {
+		return fmt.Errorf("error code %d is already registered: '%s'", code, prev)
+	}
+	lib.errorCodes[code] = msg
+	return nil
+}
+
+// ErrorCodeMessage returns registered message of the error code
+func (lib *Library) ErrorCodeMessage(code uint16) (string, bool) {
+	msg, found := lib.errorCodes[code]
+	return msg, found
+}
+
+// FailCode is the registered meaning of the 1-byte error code of 'fail', for example 'fail(100)'
+type FailCode struct {
+	Code        byte
+	Name        string
+	Description string
+}
+
+func (fc FailCode) String() string {
+	if fc.Description == "" {
+		return fc.Name
+	}
+	return fc.Name + ": " + fc.Description
+}
+
+// RegisterFailCode registers name and description of the error code of 'fail'. Both codes and names are unique.
+// Registered code is shown in the failure message and in the trace, it does not change semantics of scripts
+func (lib *Library) RegisterFailCode(code byte, name, description string) error {
+	if name == "" {
+		return fmt.Errorf("name of the fail code %d can't be empty", code)
+	}
+	if lib.failCodes == nil {
+		lib.failCodes = make(map[byte]FailCode)
+	}
+	if prev, already := lib.failCodes[code]; already {
+		return fmt.Errorf("fail code %d is already registered: '%s'", code, prev.Name)
+	}
+	for _, fc := range lib.failCodes {
+		if fc.Name == name {
+			return fmt.Errorf("fail code name '%s' is already registered with code %d", name, fc.Code)
+		}
+	}
+	lib.failCodes[code] = FailCode{Code: code, Name: name, Description: description}
+	return nil
+}
+
+// FailCodeInfo returns registered fail code
+func (lib *Library) FailCodeInfo(code byte) (FailCode, bool) {
+	fc, found := lib.failCodes[code]
+	return fc, found
+}
+
+// FailCodes returns all registered fail codes, sorted by code
+func (lib *Library) FailCodes() []FailCode {
+	ret := make([]FailCode, 0, len(lib.failCodes))
+	for _, fc := range lib.failCodes {
+		ret = append(ret, fc)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Code < ret[j].Code
+	})
+	return ret
+}
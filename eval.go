@@ -7,7 +7,11 @@ import (
 )
 
 // GlobalData represents the data to be evaluated. It is wrapped into the interface
-// which offers some tracing options
+// which offers some tracing options.
+// The evaluator only reads the global data, so one GlobalData can be shared by concurrent evaluations,
+// provided Data is not modified meanwhile and PutTrace (and PutTraceEvent, if implemented) is safe for
+// concurrent use. Tracers of this package are: GlobalDataNoTrace, GlobalDataLog and GlobalDataJSONTrace.
+// GlobalDataTracePrint is safe as far as its Logger is
 type GlobalData interface {
 	Data() interface{} // return data being evaluated. It is interpreted by the transaction host
 	Trace() bool       // should return true if tracing enabled
@@ -18,12 +22,44 @@ type GlobalData interface {
 type evalContext struct {
 	glb      GlobalData
 	varScope []*call
+	state    *evalState
+}
+
+// evalState is shared by all evaluation contexts of one top-level evaluation,
+// including contexts of the called extended functions
+type evalState struct {
+	// per-evaluation memo of the values computed by embedded functions
+	memo map[string][]byte
+	// not nil if global data accepts structured trace events
+	eventTracer EventTracer
+	// current depth of nested calls, for trace events
+	depth int
+	// tracing is enabled in the global data
+	trace bool
+	// current depth of nested dynamic evaluations of bytecode by 'eval' and similar functions
+	evalDepth int
+	// not nil if the evaluation is profiled
+	profiler *Profiler
+	// names of the called functions, maintained only for the profiler
+	stack []string
+	// number of failed branches evaluated, counted only when the library limits them
+	failedBranches int
+	// number of iterations of all 'applyN' calls
+	applyIterations int
+	// prefix of trace and panic messages. Empty if global data is not Correlated
+	correlationID string
+	// not nil if the evaluation is gas metered
+	gas *GasMeter
 }
 
 // CallParams is a structure through which the function accesses its evaluation context and call arguments
 type CallParams struct {
 	ctx  *evalContext
 	args []*Expression
+	// values of all arguments, if evaluated eagerly before the call. Otherwise nil
+	argValues [][]byte
+	// arguments in the bytecode, if the call is interpreted directly by EvalBytecodeDirect. Then args are nil
+	direct directArgs
 }
 
 // call is EvalFunction with params
@@ -32,12 +68,32 @@ type call struct {
 	params *CallParams
 	cache  []byte
 	cached bool
+	// not nil if the call is the argument interpreted directly from the bytecode at pos in the context ctx
+	direct *directCode
+	pos    int
+	ctx    *evalContext
 }
 
 func newEvalContext(varScope []*call, glb GlobalData) *evalContext {
 	return &evalContext{
 		varScope: varScope,
 		glb:      glb,
+		state: &evalState{
+			eventTracer:   eventTracerOf(glb),
+			profiler:      profilerOf(glb),
+			trace:         !isNil(glb) && glb.Trace(),
+			correlationID: correlationIDOf(glb),
+			gas:           gasMeterOf(glb),
+		},
+	}
+}
+
+// nested makes context for the evaluation of the function body with its own parameters within the same evaluation
+func (ctx *evalContext) nested(varScope []*call) *evalContext {
+	return &evalContext{
+		varScope: varScope,
+		glb:      ctx.glb,
+		state:    ctx.state,
 	}
 }
 
@@ -60,11 +116,23 @@ func (c *call) Eval() []byte {
 	if c.cached {
 		return c.cache
 	}
-	c.cache = c.f.EmbeddedFunction(c.params)
+	if c.direct != nil {
+		c.cache = c.direct.eval(c.ctx, c.pos)
+	} else {
+		c.cache = c.f.EmbeddedFunction(c.params)
+	}
 	c.cached = true
 	return c.cache
 }
 
+// bytecode returns bytecode of the call
+func (c *call) bytecode() []byte {
+	if c.direct != nil {
+		return c.direct.fragment(c.pos)
+	}
+	return c.f.bytecode
+}
+
 // DataContext accesses the data context inside the embedded function
 func (p *CallParams) DataContext() interface{} {
 	return p.ctx.glb.Data()
@@ -72,52 +140,140 @@ func (p *CallParams) DataContext() interface{} {
 
 // Slice makes CallParams with the slice of arguments
 func (p *CallParams) Slice(from, to byte) *CallParams {
-	return &CallParams{
-		ctx:  p.ctx,
-		args: p.args[from:to],
+	ret := &CallParams{ctx: p.ctx}
+	if p.direct.d != nil {
+		ret.direct = p.direct.slice(from, to)
+	} else {
+		ret.args = p.args[from:to]
+	}
+	if p.argValues != nil {
+		ret.argValues = p.argValues[from:to]
 	}
+	return ret
 }
 
 // Arity return actual number of call parameters
 func (p *CallParams) Arity() byte {
+	if p.direct.d != nil {
+		return p.direct.arity
+	}
 	return byte(len(p.args))
 }
 
 func (ctx *evalContext) eval(f *Expression) []byte {
-	return newCall(f.EvalFunc, f.Args, ctx).Eval()
+	if ctx.state.gas != nil {
+		ctx.chargeGas(f.CallPrefix)
+	}
+	if ctx.state.profiler != nil {
+		return ctx.evalProfiled(f)
+	}
+	return ctx.evalNotProfiled(f)
+}
+
+func (ctx *evalContext) evalNotProfiled(f *Expression) []byte {
+	if ctx.state.eventTracer != nil {
+		return ctx.evalWithTraceEvent(f)
+	}
+	// result of the expression is not cached, so the call is not needed
+	return f.EvalFunc.EmbeddedFunction(newCallParams(ctx, f.Args))
 }
 
 // Arg evaluates argument if the call inside embedded function
 func (p *CallParams) Arg(n byte) []byte {
+	if p.argValues != nil {
+		return p.argValues[n]
+	}
+	if p.direct.d != nil {
+		return p.direct.d.eval(p.ctx, p.direct.argPos(n))
+	}
 	if traceYN {
-		fmt.Printf("Arg(%d) -- IN\n", n)
+		DefaultLogger.Printf("Arg(%d) -- IN\n", n)
 	}
 	ret := p.ctx.eval(p.args[n])
 
 	if traceYN {
-		fmt.Printf("Arg(%d) -- OUT ret: %v\n", n, ret)
+		DefaultLogger.Printf("Arg(%d) -- OUT ret: %v\n", n, ret)
 	}
 	return ret
 }
 
+// Args evaluates all arguments once, upon the first call. Subsequent calls of Args and Arg return evaluated values.
+// Returned slice must not be modified
+func (p *CallParams) Args() [][]byte {
+	p.evalArgsEager()
+	return p.argValues
+}
+
+// RawArgs returns arguments of the call as not evaluated expressions
+func (p *CallParams) RawArgs() []*Expression {
+	if p.direct.d != nil {
+		return p.direct.expressions()
+	}
+	return p.args
+}
+
+// argumentCall makes the call of the n-th argument for the variable scope of the called extended function
+func (p *CallParams) argumentCall(n byte) *call {
+	if p.direct.d != nil {
+		return p.direct.call(p.ctx, n)
+	}
+	return argumentCall(p.args[n], p.ctx)
+}
+
+// evalArgsEager evaluates all arguments of the call. Subsequent Arg calls return evaluated values
+func (p *CallParams) evalArgsEager() {
+	if p.argValues != nil {
+		return
+	}
+	values := make([][]byte, p.Arity())
+	for i := range values {
+		values[i] = p.Arg(byte(i))
+	}
+	p.argValues = values
+}
+
+// MemoGetOrCompute returns value memoized under the key during the current evaluation. If the value is not
+// memoized yet, it is computed by the function and memoized. It is intended for embedded functions which access
+// the same parts of the data context repeatedly, for example by path. The key must fully determine the value
+func (p *CallParams) MemoGetOrCompute(key string, f func() []byte) []byte {
+	if ret, found := p.ctx.state.memo[key]; found {
+		return ret
+	}
+	if p.ctx.state.memo == nil {
+		p.ctx.state.memo = make(map[string][]byte)
+	}
+	ret := f()
+	p.ctx.state.memo[key] = ret
+	return ret
+}
+
+// Tracing returns true if tracing is enabled. Embedded functions may use it to skip formatting of trace arguments
+func (p *CallParams) Tracing() bool {
+	return p.ctx.state.trace
+}
+
 func (p *CallParams) Trace(format string, args ...interface{}) {
-	if isNil(p.ctx.glb) || !p.ctx.glb.Trace() {
+	if !p.ctx.state.trace {
 		return
 	}
-	p.ctx.glb.PutTrace(fmt.Sprintf(format, args...))
+	p.ctx.glb.PutTrace(p.withCorrelationID(fmt.Sprintf(format, args...)))
 }
 
 func (p *CallParams) TracePanic(format string, args ...interface{}) {
 	p.Trace("panic: "+format, args...)
-	panic(fmt.Sprintf("panic: "+format, args...))
+	panic(p.withCorrelationID(fmt.Sprintf("panic: "+format, args...)))
 }
 
 func (p *CallParams) EvalParam(paramNr byte) []byte {
 	return p.ctx.varScope[paramNr].Eval()
 }
 
+// GetBytecode returns bytecode of the argument, passed as parameter paramNr to the extended function. It implements '$$i'.
+// Embedded function, called in the body of the extended function, takes bytecode of arguments of the extended function
+// with it. The compiler does not know which arguments it takes, so passing parameter reference to such argument
+// is not rejected and the bytecode is nil. Prefer '$$i' in the body, which is checked by the compiler, or BytecodeArg
 func (p *CallParams) GetBytecode(paramNr byte) []byte {
-	return p.ctx.varScope[paramNr].f.bytecode
+	return p.ctx.varScope[paramNr].bytecode()
 }
 
 func evalExpression(glb GlobalData, f *Expression, varScope []*call) []byte {
@@ -134,7 +290,8 @@ func EvalExpression(glb GlobalData, f *Expression, args ...[]byte) []byte {
 	return evalExpression(glb, f, argsForData)
 }
 
-// EvalFromSource compiles source of the expression and evaluates it
+// EvalFromSource compiles source of the expression and evaluates it.
+// The source can't take bytecode of arguments with '$$i', because arguments are values
 // Never panics
 func (lib *Library) EvalFromSource(glb GlobalData, source string, args ...[]byte) ([]byte, error) {
 	var ret []byte
@@ -143,6 +300,9 @@ func (lib *Library) EvalFromSource(glb GlobalData, source string, args ...[]byte
 		if err != nil {
 			return err
 		}
+		if err = checkNoBytecodeParams(f); err != nil {
+			return err
+		}
 		if requiredNumArgs != len(args) {
 			return fmt.Errorf("required number of parameters is %d, got %d", requiredNumArgs, len(args))
 		}
@@ -166,6 +326,9 @@ func (lib *Library) MustEvalFromSource(glb GlobalData, source string, args ...[]
 
 // MustEvalFromBytecode interprets expression in the bytecode form. Will panic on any compile and runtime error
 func (lib *Library) MustEvalFromBytecode(glb GlobalData, code []byte, args ...[]byte) []byte {
+	if ret, ok := pureDataScript(glb, code); ok {
+		return ret
+	}
 	expr, err := lib.ExpressionFromBytecode(code)
 	if err != nil {
 		panic(err)
@@ -173,13 +336,34 @@ func (lib *Library) MustEvalFromBytecode(glb GlobalData, code []byte, args ...[]
 	return EvalExpression(glb, expr, args...)
 }
 
+// pureDataScript is the fast path of the script which is only inline data, for example the constant unlock data.
+// The data is returned without building the expression and the evaluation context. The fast path is not taken
+// if the evaluation is traced, profiled or gas metered, so that traces, profiles and gas are the same as for other scripts.
+// Malformed bytecode is left for the regular path to report
+func pureDataScript(glb GlobalData, code []byte) ([]byte, bool) {
+	if !IsDataPrefix(code) || (!isNil(glb) && (glb.Trace() || profilerOf(glb) != nil || gasMeterOf(glb) != nil)) {
+		return nil, false
+	}
+	dataPrefix, _, err := ParseBytecodeInlineDataPrefix(code)
+	if err != nil || len(dataPrefix) != len(code) {
+		return nil, false
+	}
+	return dataPrefix[1:], true
+}
+
 // EvalFromBytecode evaluates expression, never panics but return an error
 func (lib *Library) EvalFromBytecode(glb GlobalData, code []byte, args ...[]byte) ([]byte, error) {
+	if lib.recorder != nil {
+		lib.recorder.record(code, args)
+	}
 	var ret []byte
 	err := CatchPanicOrError(func() error {
 		ret = lib.MustEvalFromBytecode(glb, code, args...)
 		return nil
 	})
+	if shadow := lib.shadowOf(); shadow != nil {
+		shadow.compare(glb, code, args, ret, err)
+	}
 	return ret, err
 }
 
@@ -227,11 +411,11 @@ func (lib *Library) CallLocalLibrary(ctx *CallParams, libBin [][]byte, idx int)
 	if err != nil {
 		ctx.TracePanic("error while parsing local library: %v", err)
 	}
-	varScope := make([]*call, len(ctx.args))
+	varScope := make([]*call, ctx.Arity())
 	for i := range varScope {
-		varScope[i] = newCall(ctx.args[i].EvalFunc, ctx.args[i].Args, ctx.ctx)
+		varScope[i] = ctx.argumentCall(byte(i))
 	}
-	ret := evalExpression(ctx.ctx.glb, expr, varScope)
+	ret := ctx.ctx.nested(varScope).eval(expr)
 	ctx.Trace("'lib#%d':: %d params -> %s", idx, ctx.Arity(), Fmt(ret))
 	return ret
 }
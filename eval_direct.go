@@ -0,0 +1,229 @@
+package easyfl
+
+import (
+	"fmt"
+	"io"
+)
+
+// EvalBytecodeDirect evaluates expression in the bytecode form without building the expression tree first.
+// The bytecode is checked to be a well-formed expression and boundaries of all its expressions are computed
+// in one pass, then it is interpreted directly: arguments of each call are located in the bytecode and evaluated
+// only when the called function evaluates them. Evaluation of inline data and parameter references does not
+// allocate, each call allocates only its CallParams.
+// It is intended for scripts which are evaluated once, where parsing of the whole tree is pure overhead.
+// Global data with trace events or the profiler is evaluated through the expression tree, as EvalFromBytecode does.
+// Never panics
+func (lib *Library) EvalBytecodeDirect(glb GlobalData, code []byte, args ...[]byte) ([]byte, error) {
+	var ret []byte
+	err := CatchPanicOrError(func() error {
+		if eventTracerOf(glb) != nil || profilerOf(glb) != nil {
+			ret = lib.MustEvalFromBytecode(glb, code, args...)
+			return nil
+		}
+		code, err := StripMetadata(code)
+		if err != nil {
+			return err
+		}
+		d, err := lib.newDirectCode(code)
+		if err != nil {
+			return err
+		}
+		if n := d.ends[0]; n != len(code) {
+			return fmt.Errorf("EvalBytecodeDirect: not all bytes have been consumed in %s. Remaining: %s",
+				Fmt(code), Fmt(code[n:]))
+		}
+		ctx := newEvalContext(nil, glb)
+		ctx.varScope = make([]*call, len(args))
+		for i, d := range args {
+			ctx.varScope[i] = newCall(dataFunction(d), nil, ctx)
+		}
+		ret = d.eval(ctx, 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// directCode is validated bytecode with boundaries of all its expressions, computed once
+type directCode struct {
+	lib  *Library
+	code []byte
+	// ends[pos] is the end of the expression which starts at pos. Meaningful only at starts of expressions
+	ends []int
+}
+
+// newDirectCode validates the expression at the beginning of the code and computes boundaries of
+// all its sub-expressions in one pass. The expression is walked with the stack of pending arguments
+// instead of recursion. Each call prefix is validated against the library
+func (lib *Library) newDirectCode(code []byte) (*directCode, error) {
+	type frame struct {
+		start   int
+		pending int
+	}
+	ret := &directCode{lib: lib, code: code, ends: make([]int, len(code))}
+	stack := make([]frame, 0, 8)
+	pos := 0
+	for {
+		if pos >= len(code) {
+			return nil, io.EOF
+		}
+		start := pos
+		arity := 0
+		dataPrefix, itIsData, err := ParseBytecodeInlineDataPrefix(code[pos:])
+		if err != nil {
+			return nil, err
+		}
+		if itIsData {
+			pos += len(dataPrefix)
+		} else {
+			var callPrefix []byte
+			callPrefix, _, arity, _, err = lib.parseCallPrefix(code[pos:])
+			if err != nil {
+				return nil, err
+			}
+			if len(callPrefix) == 1 && arity < 0 {
+				return nil, fmt.Errorf("EasyFL: short embedded with vararg is not allowed")
+			}
+			pos += len(callPrefix)
+		}
+		if arity > 0 {
+			stack = append(stack, frame{start: start, pending: arity})
+			continue
+		}
+		ret.ends[start] = pos
+		// the expression is complete, so are the calls whose last argument it is
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.pending--; top.pending > 0 {
+				break
+			}
+			ret.ends[top.start] = pos
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			return ret, nil
+		}
+	}
+}
+
+// bytecodeFragmentLength returns length of the expression at the beginning of the bytecode.
+// The expression is walked with the counter of pending arguments instead of recursion. Each call prefix is
+// validated against the library
+func (lib *Library) bytecodeFragmentLength(code []byte) (int, error) {
+	n, _, err := lib.bytecodeFragmentLengthWithOffset(code)
+	return n, err
+}
+
+// bytecodeFragmentLengthWithOffset is bytecodeFragmentLength which, in case of error, also returns
+// the offset of the failed prefix in the bytecode
+func (lib *Library) bytecodeFragmentLengthWithOffset(code []byte) (int, int, error) {
+	pos := 0
+	for pending := 1; pending > 0; {
+		if pos >= len(code) {
+			return 0, pos, io.EOF
+		}
+		dataPrefix, itIsData, err := ParseBytecodeInlineDataPrefix(code[pos:])
+		if err != nil {
+			return 0, pos, err
+		}
+		if itIsData {
+			pos += len(dataPrefix)
+			pending--
+			continue
+		}
+		callPrefix, _, arity, _, err := lib.parseCallPrefix(code[pos:])
+		if err != nil {
+			return 0, pos, err
+		}
+		if len(callPrefix) == 1 && arity < 0 {
+			return 0, pos, fmt.Errorf("EasyFL: short embedded with vararg is not allowed")
+		}
+		pos += len(callPrefix)
+		pending += arity - 1
+	}
+	return pos, 0, nil
+}
+
+// eval evaluates the expression which starts at pos. Inline data and parameter references are evaluated
+// in place, calls get arguments located in the bytecode
+func (d *directCode) eval(ctx *evalContext, pos int) []byte {
+	code := d.code[pos:d.ends[pos]]
+	if ctx.state.gas != nil {
+		ctx.chargeGas(code)
+	}
+	if IsDataPrefix(code) {
+		if ctx.state.trace {
+			(&CallParams{ctx: ctx}).Trace("-> %s", Fmt(code[1:]))
+		}
+		return code[1:]
+	}
+	var fd *funDescriptor
+	var prefixLen, arity int
+	if IsLongCall(code) {
+		fd = d.lib.funCodeTable[FunCodeFromPrefix(code)]
+		prefixLen, arity = 2, ArityFromPrefix(code)
+	} else {
+		if code[0] <= LastEmbeddedReserved {
+			// parameter reference $i or $$i
+			if code[0]&BytecodeParameterFlag != 0 {
+				return ctx.varScope[code[0]&^BytecodeParameterFlag].bytecode()
+			}
+			return ctx.varScope[code[0]].Eval()
+		}
+		fd = d.lib.funCodeTable[code[0]]
+		prefixLen, arity = 1, fd.requiredNumParams
+	}
+	par := &CallParams{
+		ctx:    ctx,
+		direct: directArgs{d: d, pos: pos + prefixLen, arity: byte(arity)},
+	}
+	return fd.dispatch(par)
+}
+
+// fragment returns bytecode of the expression which starts at pos
+func (d *directCode) fragment(pos int) []byte {
+	return d.code[pos:d.ends[pos]]
+}
+
+// directArgs are arguments of the call, interpreted directly from the bytecode
+type directArgs struct {
+	d *directCode
+	// position of the first argument
+	pos   int
+	arity byte
+}
+
+// argPos returns position of the n-th argument
+func (a *directArgs) argPos(n byte) int {
+	pos := a.pos
+	for i := byte(0); i < n; i++ {
+		pos = a.d.ends[pos]
+	}
+	return pos
+}
+
+// slice returns arguments from-to
+func (a *directArgs) slice(from, to byte) directArgs {
+	return directArgs{d: a.d, pos: a.argPos(from), arity: to - from}
+}
+
+// expressions parses arguments into expressions, for embedded functions which take them with RawArgs
+func (a *directArgs) expressions() []*Expression {
+	ret := make([]*Expression, a.arity)
+	pos := a.pos
+	for i := range ret {
+		expr, err := a.d.lib.ExpressionFromBytecode(a.d.fragment(pos))
+		AssertNoError(err)
+		ret[i] = expr
+		pos = a.d.ends[pos]
+	}
+	return ret
+}
+
+// call makes the call of the n-th argument for the variable scope of the extended function.
+// It is evaluated upon the first reference to the parameter
+func (a *directArgs) call(ctx *evalContext, n byte) *call {
+	return &call{direct: a.d, pos: a.argPos(n), ctx: ctx}
+}
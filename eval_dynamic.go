@@ -0,0 +1,153 @@
+package easyfl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// DefaultMaxEvalRecursion is the default limit of nested dynamic evaluations of bytecode within one evaluation
+	DefaultMaxEvalRecursion = 32
+	// DefaultMaxApplyN is the default limit of iterations of all 'applyN' calls within one evaluation
+	DefaultMaxApplyN = 1024
+)
+
+// ErrEvalRecursion is returned when nested dynamic evaluations of bytecode exceed the limit.
+// It is usually a bytecode which evaluates itself
+type ErrEvalRecursion struct {
+	Limit int
+}
+
+func (e *ErrEvalRecursion) Error() string {
+	return fmt.Sprintf("dynamic evaluation of bytecode is nested deeper than %d", e.Limit)
+}
+
+// SetMaxEvalRecursion sets limit of nested dynamic evaluations of bytecode. 0 means DefaultMaxEvalRecursion
+func (lib *Library) SetMaxEvalRecursion(n int) {
+	lib.maxEvalRecursion = n
+}
+
+// ErrApplyNBudget is returned when 'applyN' calls of one evaluation, including nested ones,
+// iterate more times than allowed by the library
+type ErrApplyNBudget struct {
+	Limit int
+	// correlation ID of the evaluation, if any. See Correlated
+	CorrelationID string
+}
+
+func (e *ErrApplyNBudget) Error() string {
+	return prefixCorrelationID(e.CorrelationID, fmt.Sprintf("applyN: more than %d iterations in one evaluation", e.Limit))
+}
+
+// WithMaxApplyN limits total number of iterations of all 'applyN' calls within one evaluation,
+// including nested ones. The limit bounds work of the evaluation also without gas metering.
+// It can't be changed after construction, because bytecode evaluates differently under different limits.
+// Limit other than DefaultMaxApplyN is part of the library hash. 0 means DefaultMaxApplyN
+func WithMaxApplyN(n int) LibraryOption {
+	Assert(n >= 0 && n <= math.MaxUint32, "wrong limit of applyN iterations: %d", n)
+	return func(lib *Library) {
+		if n == DefaultMaxApplyN {
+			n = 0
+		}
+		lib.maxApplyN = n
+	}
+}
+
+// MaxApplyN returns limit of iterations of all 'applyN' calls within one evaluation
+func (lib *Library) MaxApplyN() int {
+	return lib.maxApplyIterations()
+}
+
+func (lib *Library) maxApplyIterations() int {
+	if lib.maxApplyN <= 0 {
+		return DefaultMaxApplyN
+	}
+	return lib.maxApplyN
+}
+
+func (lib *Library) maxEvalDepth() int {
+	if lib.maxEvalRecursion <= 0 {
+		return DefaultMaxEvalRecursion
+	}
+	return lib.maxEvalRecursion
+}
+
+// evalDynamic evaluates closed bytecode, computed at runtime, within the current evaluation.
+// Depth of nested dynamic evaluations is limited. The bytecode can't call internal library functions
+func (lib *Library) evalDynamic(par *CallParams, code []byte) ([]byte, error) {
+	st := par.ctx.state
+	if st.evalDepth >= lib.maxEvalDepth() {
+		return nil, &ErrEvalRecursion{Limit: lib.maxEvalDepth()}
+	}
+	st.evalDepth++
+	defer func() { st.evalDepth-- }()
+
+	var ret []byte
+	err := CatchPanicOrError(func() error {
+		expr, err := lib.ExpressionFromBytecode(code)
+		if err != nil {
+			return err
+		}
+		if err = lib.checkNotInternal(expr); err != nil {
+			return err
+		}
+		ret = par.ctx.nested(nil).eval(expr)
+		return nil
+	})
+	return ret, err
+}
+
+// evalDynamicN evaluates bytecode with one parameter n times within the current evaluation.
+// The argument is the parameter of the first evaluation, the result is the parameter of the next one.
+// The bytecode is parsed once. Each iteration is counted in the budget of 'applyN' iterations of the evaluation
+func (lib *Library) evalDynamicN(par *CallParams, code []byte, n int, arg []byte) ([]byte, error) {
+	st := par.ctx.state
+	if st.evalDepth >= lib.maxEvalDepth() {
+		return nil, &ErrEvalRecursion{Limit: lib.maxEvalDepth()}
+	}
+	st.evalDepth++
+	defer func() { st.evalDepth-- }()
+
+	ret := arg
+	err := CatchPanicOrError(func() error {
+		expr, err := lib.ExpressionFromBytecode(code)
+		if err != nil {
+			return err
+		}
+		if err = lib.checkNotInternal(expr); err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			lib.countApplyIteration(st)
+			ret = par.ctx.nested([]*call{newCall(dataFunction(ret), nil, par.ctx)}).eval(expr)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// countApplyIteration counts one iteration of 'applyN' and panics when the budget of the evaluation is exhausted
+func (lib *Library) countApplyIteration(st *evalState) {
+	st.applyIterations++
+	if st.applyIterations > lib.maxApplyIterations() {
+		panic(&ErrApplyNBudget{Limit: lib.maxApplyIterations(), CorrelationID: st.correlationID})
+	}
+}
+
+// panicIfApplyNBudget propagates exhausted budget of 'applyN' iterations as is, so that it is not wrapped
+// by each nested level
+func panicIfApplyNBudget(err error) {
+	var errBudget *ErrApplyNBudget
+	if errors.As(err, &errBudget) {
+		panic(err)
+	}
+}
+
+// panicIfEvalRecursion propagates the recursion error as is, so that it is not wrapped by each nested level
+func panicIfEvalRecursion(err error) {
+	var errRecursion *ErrEvalRecursion
+	if errors.As(err, &errRecursion) {
+		panic(err)
+	}
+}
@@ -0,0 +1,102 @@
+package easyfl
+
+import (
+	"sync"
+)
+
+// EvalMany evaluates the same bytecode over many sets of argument values, for example one script over
+// all inputs of the airdrop. The bytecode is parsed once. Each worker reuses its evaluation context.
+// If numWorkers > 1, argument sets are evaluated in parallel by numWorkers goroutines, so
+// the GlobalData must be safe for concurrent reading. Metered global data is evaluated only with numWorkers <= 1,
+// otherwise each argument set gets ErrGasMeterConcurrent.
+// Results and errors are in the order of argument sets. If the bytecode can't be parsed, each argument set
+// gets the parsing error. Never panics
+func (lib *Library) EvalMany(glb GlobalData, code []byte, argSets [][][]byte, numWorkers int) ([][]byte, []error) {
+	results := make([][]byte, len(argSets))
+	errs := make([]error, len(argSets))
+
+	var expr *Expression
+	err := CatchPanicOrError(func() error {
+		var err error
+		expr, err = lib.ExpressionFromBytecode(code)
+		return err
+	})
+	if err == nil && numWorkers > 1 && gasMeterOf(glb) != nil {
+		err = ErrGasMeterConcurrent
+	}
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	evalOne := func(ev *manyEvaluator, i int) {
+		errs[i] = CatchPanicOrError(func() error {
+			results[i] = ev.eval(expr, argSets[i])
+			return nil
+		})
+	}
+	if numWorkers <= 1 {
+		ev := newManyEvaluator(glb)
+		for i := range argSets {
+			evalOne(ev, i)
+		}
+		return results, errs
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for w := 0; w < numWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			ev := newManyEvaluator(glb)
+			for i := range indices {
+				evalOne(ev, i)
+			}
+		}()
+	}
+	for i := range argSets {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results, errs
+}
+
+// manyEvaluator evaluates expressions one after another, reusing the evaluation context and calls of parameters
+type manyEvaluator struct {
+	ctx   *evalContext
+	state evalState
+	calls []call
+}
+
+func newManyEvaluator(glb GlobalData) *manyEvaluator {
+	ctx := newEvalContext(nil, glb)
+	return &manyEvaluator{
+		ctx:   ctx,
+		state: *ctx.state,
+	}
+}
+
+func (ev *manyEvaluator) eval(f *Expression, args [][]byte) []byte {
+	// fresh state of the evaluation, the backing array of the profiler stack is reused
+	stack := ev.ctx.state.stack[:0]
+	*ev.ctx.state = ev.state
+	ev.ctx.state.stack = stack
+
+	if cap(ev.calls) < len(args) {
+		ev.calls = make([]call, len(args))
+		ev.ctx.varScope = make([]*call, len(args))
+	}
+	ev.calls = ev.calls[:len(args)]
+	ev.ctx.varScope = ev.ctx.varScope[:len(args)]
+	for i, d := range args {
+		ev.calls[i] = call{
+			f:      dataFunction(d),
+			params: newCallParams(ev.ctx, nil),
+		}
+		ev.ctx.varScope[i] = &ev.calls[i]
+	}
+	return ev.ctx.eval(f)
+}
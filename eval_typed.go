@@ -0,0 +1,91 @@
+package easyfl
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EvalOptions declares expected shape of the evaluation result. Zero value accepts any result
+type EvalOptions struct {
+	// if true, the result must be exactly Len bytes long
+	CheckLen bool
+	Len      int
+	// the result must be non-empty
+	TruthyOnly bool
+}
+
+// ErrResultShape is returned when the evaluation result does not have the shape declared by EvalOptions
+type ErrResultShape struct {
+	Result []byte
+	Reason string
+}
+
+func (e *ErrResultShape) Error() string {
+	return fmt.Sprintf("result %s %s", Fmt(e.Result), e.Reason)
+}
+
+// ExactLen declares result of exactly n bytes
+func ExactLen(n int) EvalOptions {
+	return EvalOptions{CheckLen: true, Len: n}
+}
+
+// TruthyOnly declares non-empty result
+func TruthyOnly() EvalOptions {
+	return EvalOptions{TruthyOnly: true}
+}
+
+// checkResult returns *ErrResultShape if the result does not have the declared shape
+func (opts EvalOptions) checkResult(res []byte) error {
+	if opts.TruthyOnly && len(res) == 0 {
+		return &ErrResultShape{Result: res, Reason: "is expected to be true"}
+	}
+	if opts.CheckLen && len(res) != opts.Len {
+		return &ErrResultShape{Result: res, Reason: fmt.Sprintf("is expected to be %d bytes long", opts.Len)}
+	}
+	return nil
+}
+
+// EvalWithOptions evaluates bytecode and checks the result against the shape declared by options.
+// Mismatch is returned as *ErrResultShape, the result is not returned
+func (lib *Library) EvalWithOptions(glb GlobalData, opts EvalOptions, code []byte, args ...[]byte) ([]byte, error) {
+	res, err := lib.EvalFromBytecode(glb, code, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err = opts.checkResult(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// EvalUint64 evaluates bytecode and decodes result as big-endian uint64. Results shorter than 8 bytes
+// are accepted the same way as by arithmetic functions, empty result is an error
+func (lib *Library) EvalUint64(glb GlobalData, code []byte, args ...[]byte) (uint64, error) {
+	res, err := lib.EvalFromBytecode(glb, code, args...)
+	if err != nil {
+		return 0, err
+	}
+	b, ok := ensureUint64Bytes(res)
+	if !ok {
+		return 0, fmt.Errorf("EvalUint64: result %s is not uint64", Fmt(res))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// EvalBool evaluates bytecode and interprets result as boolean: empty result is false, any other is true
+func (lib *Library) EvalBool(glb GlobalData, code []byte, args ...[]byte) (bool, error) {
+	res, err := lib.EvalFromBytecode(glb, code, args...)
+	if err != nil {
+		return false, err
+	}
+	return len(res) != 0, nil
+}
+
+// EvalBytesN evaluates bytecode and checks if the result is exactly n bytes long
+func (lib *Library) EvalBytesN(glb GlobalData, n int, code []byte, args ...[]byte) ([]byte, error) {
+	res, err := lib.EvalWithOptions(glb, ExactLen(n), code, args...)
+	if err != nil {
+		return nil, fmt.Errorf("EvalBytesN: %w", err)
+	}
+	return res, nil
+}
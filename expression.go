@@ -0,0 +1,99 @@
+package easyfl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constructors of the expression trees. They allow generating code programmatically without
+// emitting and parsing the source. Canonical bytecode is obtained with ExpressionToBytecode
+
+// NewData makes expression which evaluates to the inline data
+func NewData(data []byte) (*Expression, error) {
+	prefixed, err := InlineDataBytecode(data)
+	if err != nil {
+		return nil, err
+	}
+	var sym string
+	switch len(data) {
+	case 0:
+		sym = "nil"
+	case 1:
+		sym = fmt.Sprintf("%d", data[0])
+	default:
+		sym = fmt.Sprintf("0x%x", data)
+	}
+	return &Expression{
+		EvalFunc:     prefixedDataFunction(prefixed),
+		FunctionName: sym,
+		CallPrefix:   prefixed,
+	}, nil
+}
+
+// NewParam makes expression which evaluates parameter $n
+func NewParam(n byte) (*Expression, error) {
+	if n >= MaxParameters {
+		return nil, fmt.Errorf("wrong eval parameter reference $%d", n)
+	}
+	return &Expression{
+		Args:         make([]*Expression, 0),
+		EvalFunc:     EvalFunction{EmbeddedFunction: paramRefs[n].fun},
+		FunctionName: paramRefs[n].sym,
+		CallPrefix:   []byte{n},
+	}, nil
+}
+
+// NewBytecodeParam makes expression which returns bytecode of the parameter $$n
+func NewBytecodeParam(n byte) (*Expression, error) {
+	if n >= MaxParameters {
+		return nil, fmt.Errorf("wrong bytecode parameter reference $$%d", n)
+	}
+	return &Expression{
+		Args:         make([]*Expression, 0),
+		EvalFunc:     EvalFunction{EmbeddedFunction: paramRefs[BytecodeParameterFlag|n].fun},
+		FunctionName: paramRefs[BytecodeParameterFlag|n].sym,
+		CallPrefix:   []byte{BytecodeParameterFlag | n},
+	}, nil
+}
+
+// NewCall makes expression which calls library function with the arguments.
+// Existence of the function and number of arguments is checked against the library
+func (lib *Library) NewCall(sym string, args ...*Expression) (*Expression, error) {
+	if strings.HasPrefix(sym, "$") {
+		return nil, fmt.Errorf("parameter reference '%s' can't be called, use NewParam or NewBytecodeParam", sym)
+	}
+	fi, err := lib.functionByName(sym)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsInternal {
+		return nil, fmt.Errorf("internal library function can't be called outside the library: '%s'", sym)
+	}
+	if len(args) > MaxParameters {
+		return nil, fmt.Errorf("can't be more than %d parameters", MaxParameters)
+	}
+	if fi.NumParams >= 0 && fi.NumParams != len(args) {
+		return nil, fmt.Errorf("%d arguments required, got %d: '%s'", fi.NumParams, len(args), sym)
+	}
+	for i, arg := range args {
+		if arg == nil {
+			return nil, fmt.Errorf("argument %d of '%s' is nil", i, sym)
+		}
+	}
+	prefix, err := fi.callPrefix(byte(len(args)))
+	if err != nil {
+		return nil, err
+	}
+	embeddedFun, _, _, err := lib.functionByCode(fi.FunCode)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Expression{
+		Args:         args,
+		EvalFunc:     EvalFunction{EmbeddedFunction: embeddedFun},
+		FunctionName: sym,
+		CallPrefix:   prefix,
+	}
+	ret.EvalFunc.bytecode = ExpressionToBytecode(ret)
+	return ret, nil
+}
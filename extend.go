@@ -13,6 +13,10 @@ var extendWithUtilityFunctions = []*ExtendedFunctionData{
 	{"equalUint", "equal(uint64Bytes($0), uint64Bytes($1))"},
 	{"max", "if(lessThan($0,$1),$1,$0)"},
 	{"min", "if(lessThan($0,$1),$0,$1)"},
+	{"chainHash2", "chainHash(chainHash($0,$1),$2)"},
+	{"chainHash3", "chainHash(chainHash2($0,$1,$2),$3)"},
+	{"validChainLink", "equal($0, chainHash($1,$2))"},
+	{"commitment", "chainHash(blake2b($0),$1)"},
 }
 
 func (lib *Library) extendBase() {
@@ -44,4 +48,10 @@ func (lib *Library) extendBase() {
 	lib.MustEqual("min(u32/1,u32/100)", "u32/1")
 	lib.MustEqual("min(u32/100,u32/1)", "u32/1")
 
+	lib.MustEqual("chainHash2(0x01, 0x02, 0x03)", "chainHash(chainHash(0x01, 0x02), 0x03)")
+	lib.MustEqual("chainHash3(0x01, 0x02, 0x03, 0x04)", "chainHash(chainHash(chainHash(0x01, 0x02), 0x03), 0x04)")
+	lib.MustTrue("validChainLink(chainHash(0x01, 0x02), 0x01, 0x02)")
+	lib.MustTrue("not(validChainLink(chainHash(0x01, 0x02), 0x01, 0x03))")
+	lib.MustEqual("commitment(0x0102, 0x03)", "blake2b(blake2b(0x0102), 0x03)")
+
 }
@@ -0,0 +1,42 @@
+package easyfl
+
+import "fmt"
+
+// ResultFormatter renders result of the function in the trace output
+type ResultFormatter interface {
+	// FormatResult returns rendered value and true if there is a formatter for the function
+	FormatResult(sym string, data []byte) (string, bool)
+}
+
+// SetResultFormatter registers host formatter of the function results for the trace output,
+// for example to render 8-byte values as amounts. nil removes the formatter.
+// The string trace reports the formatted result of each call of the function after the messages of the call.
+// GlobalDataJSONTrace renders results with the formatter, if the library is set as its ResultFormatter.
+// Formatters can be set while the library is used
+func (lib *Library) SetResultFormatter(sym string, format func(data []byte) string) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	fd.resultFormatter.Store(format)
+	return nil
+}
+
+// FormatResult implements ResultFormatter with formatters registered in the library
+func (lib *Library) FormatResult(sym string, data []byte) (string, bool) {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return "", false
+	}
+	format := fd.formatter()
+	if format == nil {
+		return "", false
+	}
+	return format(data), true
+}
+
+// formatter returns result formatter of the function or nil
+func (fd *funDescriptor) formatter() func(data []byte) string {
+	ret, _ := fd.resultFormatter.Load().(func(data []byte) string)
+	return ret
+}
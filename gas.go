@@ -0,0 +1,209 @@
+package easyfl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// GasPerStep is gas consumed by evaluation of each expression of the bytecode: call, inline data or
+// parameter reference. Called functions consume their gas cost in addition
+const GasPerStep = 1
+
+const (
+	// GasDataBlockSize is the size of the data block. Base functions which hash or build data consume gas
+	// for each started block of it, in addition to their gas cost
+	GasDataBlockSize = 64
+	// GasPerHashedBlock is gas consumed by hashing of each started block of the data
+	GasPerHashedBlock = 4
+	// GasPerCopiedBlock is gas consumed by each started block of the result of 'concat' and 'repeat'
+	GasPerCopiedBlock = 1
+	// GasPerSignatureVerification is gas consumed by each verification of the ED25519 signature,
+	// in addition to hashing of the message
+	GasPerSignatureVerification = 1000
+)
+
+// default gas costs of the base functions, in addition to GasPerStep. Other base functions cost nothing extra.
+// 'validMultiSigED25519' consumes gas for each verified signature
+var gasCostsBase = map[string]uint64{
+	"blake2b":               20,
+	"chainHash":             20,
+	"prand":                 20,
+	"validSignatureED25519": GasPerSignatureVerification,
+}
+
+// ErrOutOfGas is returned when evaluation consumes more gas than the budget of the gas meter
+type ErrOutOfGas struct {
+	Limit uint64
+	// correlation ID of the evaluation, if any. See Correlated
+	CorrelationID string
+}
+
+func (e *ErrOutOfGas) Error() string {
+	return prefixCorrelationID(e.CorrelationID, fmt.Sprintf("out of gas: limit %d", e.Limit))
+}
+
+// ErrGasMeterConcurrent is returned by parallel evaluations with the metered global data
+var ErrGasMeterConcurrent = errors.New("metered global data can't be evaluated in parallel: gas meter is not thread safe")
+
+// GasMeter counts gas consumed by evaluations against the budget. It is not thread safe,
+// so each concurrent evaluation needs its own meter
+type GasMeter struct {
+	lib   *Library
+	limit uint64
+	used  uint64
+}
+
+// GasMetered is implemented by global data with the gas budget. Evaluations with it consume gas
+type GasMetered interface {
+	GasMeter() *GasMeter
+}
+
+type globalDataGasMetered struct {
+	GlobalData
+	meter *GasMeter
+}
+
+// NewGasMeter makes gas meter with the budget. Gas costs of the functions are read from the library
+// during evaluation. Costs are not part of the library hash, so all parties which must agree on
+// the outcome of the metered evaluation must use the same costs
+func (lib *Library) NewGasMeter(limit uint64) *GasMeter {
+	return &GasMeter{lib: lib, limit: limit}
+}
+
+// Used returns gas consumed so far. It does not exceed the limit
+func (m *GasMeter) Used() uint64 {
+	return m.used
+}
+
+// Limit returns the budget
+func (m *GasMeter) Limit() uint64 {
+	return m.limit
+}
+
+// Remaining returns gas left in the budget
+func (m *GasMeter) Remaining() uint64 {
+	return m.limit - m.used
+}
+
+// consume adds gas to the consumed and panics with *ErrOutOfGas if the budget is exceeded
+func (m *GasMeter) consume(gas uint64, correlationID string) {
+	if gas > m.limit-m.used {
+		m.used = m.limit
+		panic(&ErrOutOfGas{Limit: m.limit, CorrelationID: correlationID})
+	}
+	m.used += gas
+}
+
+// WithGasMeter wraps global data so that evaluations with it consume gas of the meter.
+// The wrapper does not report trace events and is not profiled, even if the wrapped global data does.
+// Implement GasMetered to combine them
+func WithGasMeter(glb GlobalData, m *GasMeter) GlobalData {
+	if isNil(glb) {
+		glb = NewGlobalDataNoTrace(nil)
+	}
+	return &globalDataGasMetered{GlobalData: glb, meter: m}
+}
+
+func (g *globalDataGasMetered) GasMeter() *GasMeter {
+	return g.meter
+}
+
+func gasMeterOf(glb GlobalData) *GasMeter {
+	if isNil(glb) {
+		return nil
+	}
+	if m, ok := glb.(GasMetered); ok {
+		return m.GasMeter()
+	}
+	return nil
+}
+
+// SetGasCost sets gas consumed by each call of the library function, in addition to GasPerStep
+func (lib *Library) SetGasCost(sym string, cost uint64) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	fd.gasCost = cost
+	return nil
+}
+
+// GasCost returns gas cost of the library function
+func (lib *Library) GasCost(sym string) (uint64, error) {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return 0, fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	return fd.gasCost, nil
+}
+
+func (lib *Library) setGasCostsBase() {
+	for sym, cost := range gasCostsBase {
+		AssertNoError(lib.SetGasCost(sym, cost))
+	}
+}
+
+// ConsumeGas consumes gas of the evaluation, if it is metered. Embedded functions use it for costs which depend
+// on arguments. Panics with *ErrOutOfGas if the budget is exceeded
+func (p *CallParams) ConsumeGas(gas uint64) {
+	if m := p.ctx.state.gas; m != nil {
+		m.consume(gas, p.ctx.state.correlationID)
+	}
+}
+
+// dataBlocks returns number of started blocks of GasDataBlockSize bytes in the data of the size
+func dataBlocks(size int) uint64 {
+	return uint64((size + GasDataBlockSize - 1) / GasDataBlockSize)
+}
+
+// hashingGas is gas of hashing of the data of the size
+func hashingGas(size int) uint64 {
+	return dataBlocks(size) * GasPerHashedBlock
+}
+
+// copyingGas is gas of building the data of the size
+func copyingGas(size int) uint64 {
+	return dataBlocks(size) * GasPerCopiedBlock
+}
+
+// signatureGas is gas of verification of one signature of the message of the size
+func signatureGas(msgSize int) uint64 {
+	return GasPerSignatureVerification + hashingGas(msgSize)
+}
+
+// chargeGas consumes gas for the evaluation of the expression with the call prefix.
+// Functions of local libraries cost only GasPerStep
+func (ctx *evalContext) chargeGas(callPrefix []byte) {
+	m := ctx.state.gas
+	gas := uint64(GasPerStep)
+	if len(callPrefix) > 0 && !IsDataPrefix(callPrefix) && !isParameterReference(callPrefix) {
+		if fd := m.lib.descriptorOfCall(callPrefix); fd != nil {
+			gas += fd.gasCost
+		}
+	}
+	m.consume(gas, ctx.state.correlationID)
+}
+
+// argumentCall makes the call of the argument of the extended function, evaluated upon the first reference
+// to the parameter. In the metered evaluation the argument is charged as if it was evaluated by the context
+func argumentCall(arg *Expression, ctx *evalContext) *call {
+	if ctx.state.gas == nil {
+		return newCall(arg.EvalFunc, arg.Args, ctx)
+	}
+	f := arg.EvalFunc
+	embeddedFun, prefix := f.EmbeddedFunction, arg.CallPrefix
+	f.EmbeddedFunction = func(par *CallParams) []byte {
+		par.ctx.chargeGas(prefix)
+		return embeddedFun(par)
+	}
+	return newCall(f, arg.Args, ctx)
+}
+
+// panicIfOutOfGas propagates exhausted gas budget of dynamically evaluated bytecode as is, so that it is not
+// wrapped by each nested level
+func panicIfOutOfGas(err error) {
+	var errGas *ErrOutOfGas
+	if errors.As(err, &errGas) {
+		panic(err)
+	}
+}
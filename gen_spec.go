@@ -0,0 +1,21 @@
+//go:build ignore
+
+// gen_spec generates spec.json from the bytecode format constants
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+func main() {
+	data, err := easyfl.SpecJSON()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile("spec.json", data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
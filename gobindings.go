@@ -0,0 +1,107 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// GenerateGoBindings generates Go source of the package pkg with:
+// - constants of function codes, for example FuncCodeValidSignatureED25519
+// - constant of the library hash
+// - wrappers for calling each not internal extended function from Go, which evaluate the call with arguments
+// as values of parameters.
+// Symbols are converted into Go identifiers by capitalizing and removing characters other than letters and digits.
+// Symbols which become the same identifier are reported as an error
+func GenerateGoBindings(lib *Library, pkg string) ([]byte, error) {
+	var buf bytes.Buffer
+	h := lib.LibraryHash()
+	fmt.Fprintf(&buf, "// Code generated by easyfl.GenerateGoBindings. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	buf.WriteString("import \"github.com/lunfardo314/easyfl\"\n\n")
+	fmt.Fprintf(&buf, "// LibraryHash is the hash of the library the bindings are generated for\n")
+	fmt.Fprintf(&buf, "const LibraryHash = %q\n\n", fmt.Sprintf("%x", h[:]))
+
+	descriptors := lib.descriptorsByFunCode()
+	names := make(map[string]string)
+	goNames := make([]string, len(descriptors))
+	for i, fd := range descriptors {
+		name := goIdentifier(fd.sym)
+		if name == "" {
+			return nil, fmt.Errorf("GenerateGoBindings: can't make Go identifier of '%s'", fd.sym)
+		}
+		if prev, already := names[name]; already {
+			return nil, fmt.Errorf("GenerateGoBindings: '%s' and '%s' have the same Go identifier '%s'", prev, fd.sym, name)
+		}
+		names[name] = fd.sym
+		goNames[i] = name
+	}
+
+	buf.WriteString("// function codes\nconst (\n")
+	for i, fd := range descriptors {
+		fmt.Fprintf(&buf, "\tFuncCode%s = %d // %s\n", goNames[i], fd.funCode, fd.sym)
+	}
+	buf.WriteString(")\n")
+
+	for i, fd := range descriptors {
+		if len(fd.bytecode) == 0 || fd.internal {
+			continue
+		}
+		// the call with parameters $0, $1, ... as arguments
+		code, err := lib.FunctionCallPrefixByName(fd.sym, byte(fd.requiredNumParams))
+		if err != nil {
+			return nil, err
+		}
+		params := make([]string, fd.requiredNumParams)
+		for j := range params {
+			code = append(code, byte(j))
+			params[j] = fmt.Sprintf("arg%d", j)
+		}
+		paramList := ""
+		if len(params) > 0 {
+			paramList = ", " + strings.Join(params, ", ") + " []byte"
+		}
+		fmt.Fprintf(&buf, "\nvar bytecode%s = %#v\n\n", goNames[i], code)
+		fmt.Fprintf(&buf, "// %s evaluates call of '%s'\n", goNames[i], fd.sym)
+		fmt.Fprintf(&buf, "func %s(lib *easyfl.Library, glb easyfl.GlobalData%s) ([]byte, error) {\n", goNames[i], paramList)
+		fmt.Fprintf(&buf, "\treturn lib.EvalFromBytecode(glb, bytecode%s%s)\n}\n", goNames[i], prefixEach(", ", params))
+	}
+	ret, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("GenerateGoBindings: %v", err)
+	}
+	return ret, nil
+}
+
+// goIdentifier makes exported Go identifier of the symbol
+func goIdentifier(sym string) string {
+	var buf strings.Builder
+	upper := true
+	for _, r := range sym {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	ret := buf.String()
+	if ret == "" || !unicode.IsLetter([]rune(ret)[0]) {
+		return ""
+	}
+	return ret
+}
+
+func prefixEach(prefix string, items []string) string {
+	var buf strings.Builder
+	for _, s := range items {
+		buf.WriteString(prefix)
+		buf.WriteString(s)
+	}
+	return buf.String()
+}
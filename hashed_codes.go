@@ -0,0 +1,71 @@
+package easyfl
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrFunCodeCollision is returned when the function code derived from the name of the new extended function
+// is already taken by another function
+type ErrFunCodeCollision struct {
+	Sym     string
+	Other   string
+	FunCode uint16
+}
+
+func (e *ErrFunCodeCollision) Error() string {
+	return fmt.Sprintf("function code %d of '%s' collides with '%s'. Choose another name", e.FunCode, e.Sym, e.Other)
+}
+
+// WithHashedExtensionCodes makes codes of extended functions, added to the library after the base library,
+// derived from their names with HashedFunCode instead of being allocated sequentially.
+// Independent parties can then add extensions in any order without agreeing on function codes,
+// and the bytecode of each extension does not depend on what else was added to the library.
+// Adding a function which code is already taken fails with *ErrFunCodeCollision.
+// Codes from FirstHashedExtendedFun to LastHashedExtendedFun are reserved for hashed codes only in the library
+// with the option. Without it the whole range of extended codes is allocated sequentially
+func WithHashedExtensionCodes() LibraryOption {
+	return func(lib *Library) {
+		lib.hashedExtensionCodes = true
+	}
+}
+
+// HashedExtensionCodes returns if codes of extended functions are derived from names
+func (lib *Library) HashedExtensionCodes() bool {
+	return lib.hashedExtensionCodes
+}
+
+// HashedFunCode maps blake2b hash of the function name into the range of hashed extended function codes
+func HashedFunCode(sym string) uint16 {
+	h := blake2b.Sum256([]byte(sym))
+	return FirstHashedExtendedFun + uint16(binary.BigEndian.Uint32(h[:4])%NumHashedExtendedFun)
+}
+
+// isHashedExtendedFunCode returns if the code is in the range reserved for hashed codes.
+// The range is reserved only in libraries with hashed extension codes
+func (lib *Library) isHashedExtendedFunCode(funCode uint16) bool {
+	return lib.hashedExtensionCodes && FirstHashedExtendedFun <= funCode && funCode <= LastHashedExtendedFun
+}
+
+// nextExtendedFunCode returns code of the new extended function. Sequential codes are allocated up to
+// the reserved range of hashed codes, if the library uses them, otherwise up to the end of the extended range
+func (lib *Library) nextExtendedFunCode(sym string) (uint16, error) {
+	if !lib.hashedExtensionCodes || lib.buildingBase {
+		ret := FirstExtendedFun + lib.numExtended - lib.numHashedExtended
+		limit := uint16(LastGlobalFunCode)
+		if lib.hashedExtensionCodes {
+			limit = FirstHashedExtendedFun
+		}
+		if ret >= limit {
+			return 0, fmt.Errorf("too many extended functions: can't add '%s'", sym)
+		}
+		return ret, nil
+	}
+	ret := HashedFunCode(sym)
+	if fd, taken := lib.funByFunCode[ret]; taken {
+		return 0, &ErrFunCodeCollision{Sym: sym, Other: fd.sym, FunCode: ret}
+	}
+	return ret, nil
+}
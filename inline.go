@@ -0,0 +1,103 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MaxClosedBytecodeSize is the maximum length of the bytecode returned by ExportClosedBytecode.
+// Extended functions are limited to the same length in the serialized library
+const MaxClosedBytecodeSize = 256*256 - 1
+
+// ErrClosedBytecodeTooLong is returned by ExportClosedBytecode when the inlined bytecode is longer than MaxClosedBytecodeSize
+var ErrClosedBytecodeTooLong = fmt.Errorf("closed bytecode is longer than %d bytes", MaxClosedBytecodeSize)
+
+// ExportClosedBytecode returns bytecode of the extended function with all transitively called extended functions
+// inlined. Calls to embedded functions remain, as well as references to parameters of the exported function.
+// The result can be evaluated by any library with the same embedded functions.
+// Note, that arguments of inlined calls are duplicated for each reference to the parameter,
+// so the result may be much longer than the original bytecode and evaluation may repeat some computations.
+// Functions which refer to bytecode parameters ($$i) can't be inlined. Returns ErrClosedBytecodeTooLong
+// if the result exceeds MaxClosedBytecodeSize. Inlining stops as soon as the limit is exceeded, so nested
+// calls, which grow the result exponentially, are rejected quickly
+func (lib *Library) ExportClosedBytecode(sym string) ([]byte, error) {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return nil, fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	if len(fd.bytecode) == 0 {
+		return nil, fmt.Errorf("'%s' is not an extended function", sym)
+	}
+	expr, err := lib.ExpressionFromBytecode(fd.bytecode)
+	if err != nil {
+		return nil, err
+	}
+	var buf limitedBuffer
+	if err = lib.writeInlined(&buf, expr, nil); err != nil {
+		return nil, fmt.Errorf("ExportClosedBytecode '%s': %w", sym, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// limitedBuffer fails writes beyond MaxClosedBytecodeSize
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > MaxClosedBytecodeSize {
+		return 0, ErrClosedBytecodeTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+// writeInlined writes bytecode of the expression with extended function calls inlined.
+// If args is not nil, parameter references are substituted with the bytecode of the respective arguments
+func (lib *Library) writeInlined(w io.Writer, expr *Expression, args [][]byte) error {
+	if IsDataPrefix(expr.CallPrefix) {
+		_, err := w.Write(expr.CallPrefix)
+		return err
+	}
+	if len(expr.CallPrefix) == 1 && expr.CallPrefix[0] <= LastEmbeddedReserved {
+		// parameter reference
+		if expr.CallPrefix[0]&BytecodeParameterFlag != 0 {
+			return fmt.Errorf("bytecode parameter reference '%s' can't be inlined", expr.FunctionName)
+		}
+		if args == nil {
+			_, err := w.Write(expr.CallPrefix)
+			return err
+		}
+		paramNr := int(expr.CallPrefix[0])
+		if paramNr >= len(args) {
+			return fmt.Errorf("parameter reference '%s' out of %d arguments", expr.FunctionName, len(args))
+		}
+		_, err := w.Write(args[paramNr])
+		return err
+	}
+	if fd, found := lib.funByName[expr.FunctionName]; found && len(fd.bytecode) > 0 {
+		// extended function call is replaced by its body with arguments substituted
+		inlinedArgs := make([][]byte, len(expr.Args))
+		for i, arg := range expr.Args {
+			var buf limitedBuffer
+			if err := lib.writeInlined(&buf, arg, args); err != nil {
+				return err
+			}
+			inlinedArgs[i] = buf.Bytes()
+		}
+		body, err := lib.ExpressionFromBytecode(fd.bytecode)
+		if err != nil {
+			return err
+		}
+		return lib.writeInlined(w, body, inlinedArgs)
+	}
+	if _, err := w.Write(expr.CallPrefix); err != nil {
+		return err
+	}
+	for _, arg := range expr.Args {
+		if err := lib.writeInlined(w, arg, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
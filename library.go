@@ -1,10 +1,13 @@
 package easyfl
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -35,6 +38,18 @@ const (
 	LastGlobalFunCode    = 1022 // biggest global function code. All the rest are local
 	MaxNumExtendedGlobal = LastGlobalFunCode - FirstExtendedFun
 	FirstLocalFunCode    = LastGlobalFunCode + 1 // functions in local libraries uses extra byte for local function codes
+
+	// ---- extended codes derived from function names, see WithHashedExtensionCodes
+
+	FirstHashedExtendedFun = 512
+	LastHashedExtendedFun  = LastGlobalFunCode - 1 // LastGlobalFunCode is the metadata header
+	NumHashedExtendedFun   = LastHashedExtendedFun - FirstHashedExtendedFun + 1
+
+	// ---- local function indices
+
+	// LocalFunIndexEscape in place of the 1-byte local index means 2-byte index follows. Used for indices >= 255
+	LocalFunIndexEscape  = 0xff
+	MaxNumLocalFunctions = 0xffff - FirstLocalFunCode + 1
 )
 
 type (
@@ -65,6 +80,29 @@ type (
 		// for embedded functions it is hardcoded function, for extended functions is
 		// interpreter closure of the bytecode
 		embeddedFun EmbeddedFunction
+		// implementation wrapped by WrapFunction, if any. Holds EmbeddedFunction
+		wrapped atomic.Value
+		// calls wrapped implementation, if any, otherwise embeddedFun. Parsed calls of library functions
+		// take it, so that implementation can be wrapped while the library is used
+		dispatch EmbeddedFunction
+		// all arguments are evaluated before invocation of the embedded function
+		eagerArgs bool
+		// optional executable specification of the function
+		semantics *semanticsAnnotation
+		// if not empty, function is deprecated. Calls to it produce compiler warnings
+		deprecated string
+		// extended function which can be called only from other library functions
+		internal bool
+		// optional host formatter of the results for the trace output. Holds func(data []byte) string
+		resultFormatter atomic.Value
+		// static nesting depth of the body of extended function. 0 for embedded functions
+		staticDepth int
+		// bit i is set if the body of extended function takes bytecode of the parameter i with $$i
+		bytecodeParams uint16
+		// portability class of the embedded function. Not used for extended functions
+		portability Portability
+		// gas consumed by each call in addition to GasPerStep
+		gasCost uint64
 	}
 
 	funInfo struct {
@@ -73,15 +111,54 @@ type (
 		IsEmbedded bool
 		IsShort    bool
 		IsLocal    bool
+		IsInternal bool
 		NumParams  int
 	}
 
 	Library struct {
 		funByName        map[string]*funDescriptor
 		funByFunCode     map[uint16]*funDescriptor
+		funCodeTable     []*funDescriptor // dense index of global functions by function code, for the parsing hot path
 		numEmbeddedShort uint16
 		numEmbeddedLong  uint16
 		numExtended      uint16
+		// extended functions with codes derived from names. Included in numExtended
+		numHashedExtended uint16
+		// host-registered messages of the requireErr error codes. Not part of the library hash
+		errorCodes map[uint16]string
+		// host-registered error codes of 'fail'. Not part of the library hash
+		failCodes map[byte]FailCode
+		// optional upgraded version of the library, evaluated alongside for comparison
+		shadow atomic.Value // *shadowLibrary
+		// optional recorder of the evaluated bytecodes
+		recorder *corpusRecorder
+		// optional cache of decompiled bytecodes
+		decompileCache *lruCache
+		// optional memo of evaluation results
+		resultMemo *lruCache
+		// optional logger of the library diagnostics. nil means DefaultLogger
+		logger Logger
+		// behavior of uint64 arithmetics on overflow. Set at construction
+		arithmetic ArithmeticProfile
+		// local libraries can't be used. Set at construction
+		noLocalLibraries bool
+		// codes of extended functions are derived from names. Set at construction
+		hashedExtensionCodes bool
+		// base library is being built, its extended functions get sequential codes
+		buildingBase bool
+		// some of extended functions are internal
+		hasInternal bool
+		// limit of nested dynamic evaluations. 0 means DefaultMaxEvalRecursion
+		maxEvalRecursion int
+		// limit of iterations of all 'applyN' calls within one evaluation. 0 means DefaultMaxApplyN. Set at construction
+		maxApplyN int
+		// limit of failed branches of 'or', 'firstCaseIndex' and 'cond' within one evaluation. 0 means no limit
+		maxFailedBranches int
+		// serializes WrapFunction
+		wrapMutex sync.Mutex
+		// memoized library hash. Reset when function is added
+		hashMutex sync.Mutex
+		hash      *[32]byte
 	}
 
 	EmbeddedFunctionData struct {
@@ -116,17 +193,25 @@ The target environment, such as 'EasyUTXO' extends the standard library by using
 
 */
 
-func New() *Library {
-	return newLibrary()
+func New(opts ...LibraryOption) *Library {
+	ret := newLibrary()
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
 }
 
-func NewBase() *Library {
-	ret := newLibrary()
+func NewBase(opts ...LibraryOption) *Library {
+	ret := New(opts...)
 	ret.initBase()
 	return ret
 }
 
 func (lib *Library) initBase() {
+	// functions of the base library always have sequential codes
+	lib.buildingBase = true
+	defer func() { lib.buildingBase = false }()
+
 	// basic
 	lib.embedBase()
 	lib.extendBase()
@@ -138,33 +223,54 @@ func (lib *Library) embedBase() {
 	lib.embedBitwiseAndCmp()
 	lib.embedBaseCrypto()
 	lib.embedBytecodeManipulation()
+	lib.embedDecimalScaling()
+	lib.embedStrings()
+	lib.embedPseudoRandom()
+	lib.embedRequireErr()
+	lib.embedCond()
+	lib.embedUint128()
+	lib.embedEqualMasked()
+	lib.embedMultiSig()
+	lib.embedChainHash()
+	lib.embedWord32()
+	lib.embedApplyN()
+	AssertNoError(lib.SetEagerArgs(eagerArgsBase...))
+	lib.annotateBase()
+	lib.annotatePortabilityBase()
+	lib.setGasCostsBase()
 }
 
 func newLibrary() *Library {
 	return &Library{
 		funByName:        make(map[string]*funDescriptor),
 		funByFunCode:     make(map[uint16]*funDescriptor),
+		funCodeTable:     make([]*funDescriptor, FirstLocalFunCode),
 		numEmbeddedShort: FirstEmbeddedShort,
 	}
 }
 
 func (lib *Library) PrintLibraryStats() {
 	h := lib.LibraryHash()
-	fmt.Printf(`EasyFL function library (hash: %s):
+	lib.Logger().Printf(`EasyFL function library (hash: %s):
     number of short embedded: %d out of max %d, remain free %d 
     number of long embedded: %d out of max %d, remain free %d
     number of extended: %d out of max %d, remain free %d
+    number of extended with hashed codes: %d out of max %d
 `,
 		hex.EncodeToString(h[:]),
 		lib.numEmbeddedShort, MaxNumEmbeddedAndReservedShort, MaxNumEmbeddedAndReservedShort-lib.numEmbeddedShort,
 		lib.numEmbeddedLong, MaxNumEmbeddedLong, MaxNumEmbeddedLong-lib.numEmbeddedLong,
 		lib.numExtended, MaxNumExtendedGlobal, MaxNumExtendedGlobal-lib.numExtended,
+		lib.numHashedExtended, NumHashedExtendedFun,
 	)
 }
 
 func (lib *Library) addDescriptor(fd *funDescriptor) {
+	lib.invalidateHash()
+	fd.dispatch = fd.call
 	lib.funByName[fd.sym] = fd
 	lib.funByFunCode[fd.funCode] = fd
+	lib.funCodeTable[fd.funCode] = fd
 	isEmbedded, isShort := fd.isEmbeddedOrShort()
 	switch {
 	case isEmbedded && isShort:
@@ -173,6 +279,9 @@ func (lib *Library) addDescriptor(fd *funDescriptor) {
 		lib.numEmbeddedLong++
 	default:
 		lib.numExtended++
+		if lib.isHashedExtendedFunCode(fd.funCode) {
+			lib.numHashedExtended++
+		}
 	}
 }
 
@@ -197,7 +306,7 @@ func (lib *Library) embedShortErr(sym string, requiredNumPar int, embeddedFun Em
 		return 0, fmt.Errorf("EasyFL: short embedded vararg functions are not allowed")
 	}
 	if traceYN {
-		embeddedFun = wrapWithTracing(embeddedFun, sym)
+		embeddedFun = lib.wrapWithTracing(embeddedFun, sym)
 	}
 	dscr := &funDescriptor{
 		sym:               sym,
@@ -225,7 +334,7 @@ func (lib *Library) embedLong(sym string, requiredNumPar int, embeddedFun Embedd
 }
 
 func (lib *Library) embedLongErr(sym string, requiredNumPar int, embeddedFun EmbeddedFunction) (uint16, error) {
-	if lib.numEmbeddedLong > MaxNumEmbeddedLong {
+	if lib.numEmbeddedLong >= MaxNumEmbeddedLong {
 		return 0, fmt.Errorf("EasyFL: too many embedded long functions")
 	}
 	if lib.existsFunction(sym) {
@@ -236,7 +345,7 @@ func (lib *Library) embedLongErr(sym string, requiredNumPar int, embeddedFun Emb
 	}
 
 	if traceYN {
-		embeddedFun = wrapWithTracing(embeddedFun, sym)
+		embeddedFun = lib.wrapWithTracing(embeddedFun, sym)
 	}
 	dscr := &funDescriptor{
 		sym:               sym,
@@ -301,6 +410,24 @@ func (lib *Library) extend(sym string, source string) uint16 {
 	return ret
 }
 
+// paramRef is parsed parameter reference
+type paramRef struct {
+	fun EmbeddedFunction
+	sym string
+}
+
+// paramRefs are parameter references $i and $$i indexed by their 1-byte call prefix. They are shared by all
+// expressions, so parsing of the parameter reference does not allocate anything but the expression node
+var paramRefs = makeParamRefs()
+
+func makeParamRefs() (ret [LastEmbeddedReserved + 1]paramRef) {
+	for i := byte(0); i < MaxParameters; i++ {
+		ret[i] = paramRef{fun: evalEvalParamFun(i), sym: fmt.Sprintf("$%d", i)}
+		ret[BytecodeParameterFlag|i] = paramRef{fun: evalBytecodeParamFun(i), sym: fmt.Sprintf("$$%d", i)}
+	}
+	return
+}
+
 func evalEvalParamFun(paramNr byte) EmbeddedFunction {
 	return func(par *CallParams) []byte {
 		return par.EvalParam(paramNr)
@@ -314,29 +441,36 @@ func evalBytecodeParamFun(paramNr byte) EmbeddedFunction {
 }
 
 func (lib *Library) ExtendErr(sym string, source string) (uint16, error) {
-	f, numParam, bytecode, err := lib.CompileExpression(source)
+	f, numParam, bytecode, err := lib.compileExpression(source, true)
 	if err != nil {
 		return 0, fmt.Errorf("error while compiling '%s': %v", sym, err)
 	}
 
-	Assert(lib.numExtended < MaxNumExtendedGlobal, "too many extended functions")
-
 	if lib.existsFunction(sym) {
 		return 0, errors.New("repeating symbol '" + sym + "'")
 	}
+	funCode, err := lib.nextExtendedFunCode(sym)
+	if err != nil {
+		return 0, err
+	}
 	if numParam > 15 {
 		return 0, errors.New("can't be more than 15 parameters")
 	}
+	if err = checkParameterGaps(f, numParam); err != nil {
+		return 0, fmt.Errorf("error while compiling '%s': %v", sym, err)
+	}
 	embeddedFun := makeEmbeddedFunForExpression(sym, f)
 	if traceYN {
-		embeddedFun = wrapWithTracing(embeddedFun, sym)
+		embeddedFun = lib.wrapWithTracing(embeddedFun, sym)
 	}
 	dscr := &funDescriptor{
 		sym:               sym,
-		funCode:           lib.numExtended + FirstExtendedFun,
+		funCode:           funCode,
 		bytecode:          bytecode,
 		requiredNumParams: numParam,
 		embeddedFun:       embeddedFun,
+		staticDepth:       lib.staticDepth(f),
+		bytecodeParams:    bytecodeParamsOf(f),
 	}
 	lib.addDescriptor(dscr)
 
@@ -351,11 +485,87 @@ func (lib *Library) ExtendErr(sym string, source string) (uint16, error) {
 
 }
 
-func wrapWithTracing(f EmbeddedFunction, msg string) EmbeddedFunction {
+func wrapWithEagerArgs(f EmbeddedFunction) EmbeddedFunction {
 	return func(par *CallParams) []byte {
-		fmt.Printf("EvalFunction '%s' - IN\n", msg)
+		par.evalArgsEager()
+		return f(par)
+	}
+}
+
+// SetEagerArgs makes embedded functions to evaluate all call arguments before invocation.
+// Each argument is then evaluated exactly once, no matter how many times the function accesses it.
+// Only suitable for functions which always evaluate all arguments. Functions like 'if', 'and', 'or'
+// must remain lazy. Affects all calls, including calls in already parsed expressions.
+// Must be called before the library is used, it is not synchronized with evaluations
+func (lib *Library) SetEagerArgs(syms ...string) error {
+	for _, sym := range syms {
+		fd, found := lib.funByName[sym]
+		if !found {
+			return fmt.Errorf("no such function in the library: '%s'", sym)
+		}
+		if isEmbedded, _ := fd.isEmbeddedOrShort(); !isEmbedded {
+			return fmt.Errorf("eager arguments can only be set for embedded function: '%s'", sym)
+		}
+		for _, lazy := range lazyArgsBase {
+			if sym == lazy {
+				return fmt.Errorf("function evaluates arguments lazily by definition: '%s'", sym)
+			}
+		}
+		if fd.eagerArgs {
+			continue
+		}
+		fd.embeddedFun = wrapWithEagerArgs(fd.embeddedFun)
+		fd.eagerArgs = true
+	}
+	return nil
+}
+
+// WrapFunction replaces implementation of the embedded or extended function with the one returned
+// by the middleware. The middleware receives the current implementation. Function codes and library hash
+// do not change. The function can be wrapped while the library is used: all calls which start after
+// the wrapping, including calls in already parsed expressions and in bodies of extended functions,
+// call the wrapped implementation
+func (lib *Library) WrapFunction(sym string, mw func(EmbeddedFunction) EmbeddedFunction) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	lib.wrapMutex.Lock()
+	defer lib.wrapMutex.Unlock()
+
+	wrapped := mw(fd.implementation())
+	if wrapped == nil {
+		return fmt.Errorf("middleware returned nil implementation for '%s'", sym)
+	}
+	fd.wrapped.Store(wrapped)
+	return nil
+}
+
+// implementation returns the current implementation of the function, wrapped or not
+func (fd *funDescriptor) implementation() EmbeddedFunction {
+	if wrapped, _ := fd.wrapped.Load().(EmbeddedFunction); wrapped != nil {
+		return wrapped
+	}
+	return fd.embeddedFun
+}
+
+// call calls the current implementation of the function. When the string trace is enabled,
+// the result is traced with the result formatter of the function, if any
+func (fd *funDescriptor) call(par *CallParams) []byte {
+	ret := fd.implementation()(par)
+	if par.Tracing() && par.ctx.state.eventTracer == nil {
+		if format := fd.formatter(); format != nil {
+			par.Trace("'%s' -> %s", fd.sym, format(ret))
+		}
+	}
+	return ret
+}
+
+func (lib *Library) wrapWithTracing(f EmbeddedFunction, msg string) EmbeddedFunction {
+	return func(par *CallParams) []byte {
+		lib.Logger().Printf("EvalFunction '%s' - IN\n", msg)
 		ret := f(par)
-		fmt.Printf("EvalFunction '%s' - OUT: %v\n", msg, ret)
+		lib.Logger().Printf("EvalFunction '%s' - OUT: %v\n", msg, ret)
 		return ret
 	}
 }
@@ -367,7 +577,7 @@ func (lib *Library) ExtendMany(source string) error {
 	}
 	for _, pf := range parsed {
 		if _, err = lib.ExtendErr(pf.Sym, pf.SourceCode); err != nil {
-			return err
+			return fmt.Errorf("%v (line %d)", err, pf.Line)
 		}
 	}
 	return nil
@@ -403,6 +613,7 @@ func (lib *Library) functionByName(sym string, localLib ...*LocalLibrary) (*funI
 		ret.FunCode = fd.funCode
 		ret.NumParams = fd.requiredNumParams
 		ret.IsEmbedded, ret.IsShort = fd.isEmbeddedOrShort()
+		ret.IsInternal = fd.internal
 	} else {
 		if len(localLib) > 0 {
 			if fdLoc, foundLocal := localLib[0].funByName[sym]; foundLocal {
@@ -436,9 +647,8 @@ func (fd *funDescriptor) isEmbeddedOrShort() (isEmbedded bool, isShort bool) {
 
 func (lib *Library) functionByCode(funCode uint16, localLib ...*LocalLibrary) (EmbeddedFunction, int, string, error) {
 	if funCode < FirstLocalFunCode {
-		libData := lib.funByFunCode[funCode]
-		if libData != nil {
-			return libData.embeddedFun, libData.requiredNumParams, libData.sym, nil
+		if libData := lib.funCodeTable[funCode]; libData != nil {
+			return libData.dispatch, libData.requiredNumParams, libData.sym, nil
 		}
 	}
 	funCodeLocal := funCode - FirstLocalFunCode
@@ -446,7 +656,7 @@ func (lib *Library) functionByCode(funCode uint16, localLib ...*LocalLibrary) (E
 		return nil, 0, "", fmt.Errorf("wrong function code %d", funCode)
 	}
 
-	libData := localLib[0].funByFunCode[byte(funCodeLocal)]
+	libData := localLib[0].funByFunCode[funCodeLocal]
 	if libData == nil {
 		return nil, 0, "", fmt.Errorf("wrong local function code %d", funCode)
 	}
@@ -454,6 +664,18 @@ func (lib *Library) functionByCode(funCode uint16, localLib ...*LocalLibrary) (E
 	return libData.embeddedFun, libData.requiredNumParams, sym, nil
 }
 
+// descriptorOfCall returns descriptor of the function called by the prefix or nil if it is not known
+func (lib *Library) descriptorOfCall(callPrefix []byte, localLib ...*LocalLibrary) *funDescriptor {
+	funCode := FunCodeFromPrefix(callPrefix)
+	if funCode < FirstLocalFunCode {
+		return lib.funCodeTable[funCode]
+	}
+	if len(localLib) > 0 && int(funCode-FirstLocalFunCode) < len(localLib[0].funByFunCode) {
+		return localLib[0].funByFunCode[funCode-FirstLocalFunCode]
+	}
+	return nil
+}
+
 func (fi *funInfo) callPrefix(numArgs byte) ([]byte, error) {
 	var ret []byte
 	if fi.IsShort {
@@ -477,12 +699,21 @@ func (fi *funInfo) callPrefix(numArgs byte) ([]byte, error) {
 			ret = make([]byte, 2)
 			binary.BigEndian.PutUint16(ret, u16)
 		} else {
-			Assert(fi.FunCode <= FirstLocalFunCode+255 && FirstLocalFunCode <= fi.FunCode, "fi.FunCode <= FirstLocalFunCode+255 && FirstLocalFunCode <= fi.FunCode")
-			// local function call 3 bytes
+			Assert(FirstLocalFunCode <= fi.FunCode, "FirstLocalFunCode <= fi.FunCode")
 			u16 := (uint16(firstByte) << 8) | FirstLocalFunCode
-			ret = make([]byte, 3)
-			binary.BigEndian.PutUint16(ret[:2], u16)
-			ret[2] = byte(fi.FunCode - FirstLocalFunCode)
+			idx := fi.FunCode - FirstLocalFunCode
+			if idx < LocalFunIndexEscape {
+				// local function call 3 bytes
+				ret = make([]byte, 3)
+				binary.BigEndian.PutUint16(ret[:2], u16)
+				ret[2] = byte(idx)
+			} else {
+				// local function call with 2-byte index, 5 bytes
+				ret = make([]byte, 5)
+				binary.BigEndian.PutUint16(ret[:2], u16)
+				ret[2] = LocalFunIndexEscape
+				binary.BigEndian.PutUint16(ret[3:], idx)
+			}
 		}
 	}
 	return ret, nil
@@ -496,6 +727,92 @@ func (lib *Library) FunctionCallPrefixByName(sym string, numArgs byte) ([]byte,
 	return fi.callPrefix(numArgs)
 }
 
+// VerifyInternalConsistency checks invariants of the library: function codes are in the ranges of their
+// kinds and do not collide with reserved parameter access codes, counters match the registered
+// functions and call prefix of each function round-trips through the bytecode parser
+func (lib *Library) VerifyInternalConsistency() error {
+	if len(lib.funByName) != len(lib.funByFunCode) {
+		return fmt.Errorf("EasyFL: %d functions by name, %d by function code", len(lib.funByName), len(lib.funByFunCode))
+	}
+	var numShort, numLong, numExtended, numHashed uint16
+	for funCode, fd := range lib.funByFunCode {
+		if fd.funCode != funCode {
+			return fmt.Errorf("EasyFL: function '%s' with code %d is registered under code %d", fd.sym, fd.funCode, funCode)
+		}
+		if lib.funByName[fd.sym] != fd {
+			return fmt.Errorf("EasyFL: function '%s' (code %d) is not registered by name", fd.sym, funCode)
+		}
+		if lib.funCodeTable[funCode] != fd {
+			return fmt.Errorf("EasyFL: function '%s' (code %d) is not in the function code table", fd.sym, funCode)
+		}
+		if fd.requiredNumParams > 15 {
+			return fmt.Errorf("EasyFL: function '%s' has %d parameters", fd.sym, fd.requiredNumParams)
+		}
+		switch isEmbedded, isShort := fd.isEmbeddedOrShort(); {
+		case isShort:
+			if funCode <= LastEmbeddedReserved {
+				return fmt.Errorf("EasyFL: function '%s' collides with reserved parameter access code %d", fd.sym, funCode)
+			}
+			if fd.requiredNumParams < 0 {
+				return fmt.Errorf("EasyFL: short embedded function '%s' is vararg", fd.sym)
+			}
+			numShort++
+		case isEmbedded:
+			numLong++
+		default:
+			if funCode > LastGlobalFunCode {
+				return fmt.Errorf("EasyFL: extended function '%s' has code %d out of global range", fd.sym, funCode)
+			}
+			if len(fd.bytecode) == 0 {
+				return fmt.Errorf("EasyFL: extended function '%s' has no bytecode", fd.sym)
+			}
+			if lib.isHashedExtendedFunCode(funCode) {
+				numHashed++
+			}
+			numExtended++
+		}
+		if err := lib.verifyCallPrefixRoundTrip(fd); err != nil {
+			return err
+		}
+	}
+	if numShort != lib.numEmbeddedShort-FirstEmbeddedShort {
+		return fmt.Errorf("EasyFL: %d short embedded functions registered, counter is %d", numShort, lib.numEmbeddedShort-FirstEmbeddedShort)
+	}
+	if numLong != lib.numEmbeddedLong {
+		return fmt.Errorf("EasyFL: %d long embedded functions registered, counter is %d", numLong, lib.numEmbeddedLong)
+	}
+	if numExtended != lib.numExtended {
+		return fmt.Errorf("EasyFL: %d extended functions registered, counter is %d", numExtended, lib.numExtended)
+	}
+	if numHashed != lib.numHashedExtended {
+		return fmt.Errorf("EasyFL: %d extended functions with hashed codes registered, counter is %d", numHashed, lib.numHashedExtended)
+	}
+	return nil
+}
+
+func (lib *Library) verifyCallPrefixRoundTrip(fd *funDescriptor) error {
+	fi, err := lib.functionByName(fd.sym)
+	if err != nil {
+		return err
+	}
+	numArgs := fd.requiredNumParams
+	if numArgs < 0 {
+		numArgs = 0
+	}
+	prefix, err := fi.callPrefix(byte(numArgs))
+	if err != nil {
+		return fmt.Errorf("EasyFL: can't make call prefix of '%s': %v", fd.sym, err)
+	}
+	parsedPrefix, _, arity, sym, err := lib.parseCallPrefix(prefix)
+	if err != nil {
+		return fmt.Errorf("EasyFL: can't parse call prefix %s of '%s': %v", Fmt(prefix), fd.sym, err)
+	}
+	if !bytes.Equal(prefix, parsedPrefix) || sym != fd.sym || arity != numArgs {
+		return fmt.Errorf("EasyFL: call prefix %s of '%s' parses to '%s' with %d arguments", Fmt(prefix), fd.sym, sym, arity)
+	}
+	return nil
+}
+
 func (lib *Library) NumFunctions() uint16 {
 	return lib.numEmbeddedShort + lib.numEmbeddedLong + lib.numExtended
 }
@@ -5,10 +5,19 @@ import (
 	"crypto/ed25519"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
 	"math"
 	"math/rand"
+	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -842,6 +851,11 @@ func TestDecompile(t *testing.T) {
 		}
 		// concatenation of decomposed bytecode is equal to the original
 		require.EqualValues(t, bin, concat(pieces...))
+
+		// composition of decomposed bytecode is equal to the original
+		binBack3, err := lib.ComposeCall(prefix, args...)
+		require.NoError(t, err)
+		require.EqualValues(t, bin, binBack3)
 	})
 	t.Run("bin-expr 6", func(t *testing.T) {
 		const formula = "0x010203"
@@ -876,6 +890,11 @@ func TestDecompile(t *testing.T) {
 		// concatenation of decomposed bytecode is equal to the original
 		require.EqualValues(t, bin, concat(pieces...))
 
+		// composition of decomposed bytecode is equal to the original
+		binBack3, err := lib.ComposeCall(prefix, args...)
+		require.NoError(t, err)
+		require.EqualValues(t, bin, binBack3)
+
 	})
 }
 
@@ -1150,3 +1169,3094 @@ func TestCases(t *testing.T) {
 		require.True(t, len(res) == 0)
 	})
 }
+
+func TestEvalBytecodeDirect(t *testing.T) {
+	lib := NewBase()
+	_, err := lib.ExtendErr("cat3", "concat($0, $1, $0)")
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("bytecode2", "concat($$0, $$1)")
+	require.NoError(t, err)
+	// host function which takes its arguments in all ways
+	lib.UpgradeWthEmbeddedLong(&EmbeddedFunctionData{"argsInfo", -1, func(par *CallParams) []byte {
+		ret := []byte{par.Arity(), byte(len(par.RawArgs())), byte(len(par.BytecodeArg(0)))}
+		return append(ret, par.Slice(1, par.Arity()).Arg(0)...)
+	}})
+
+	sources := []string{
+		"125",
+		"nil",
+		"concat($0,concat($1,$0))",
+		"if(equal(len($0),u64/3), 0x01, 0x05)",
+		"cat3(slice($1,0,0), 5)",
+		"and(concat(1,2), if(1,2,3))",
+		"bytecode(concat(1,2))",
+		"max(u32/100,u32/1)",
+		"concat(bytecode(concat(1,2)), 5)",
+		"bytecode2(concat(1,2), add(1,2))",
+		"argsInfo(concat(1,2), $1, 5)",
+		"cat3(argsInfo(concat($0,2), cat3(1, 2)), 7)",
+	}
+	for _, src := range sources {
+		_, _, code, err := lib.CompileExpression(src)
+		require.NoError(t, err)
+		exp, err := lib.EvalFromBytecode(nil, code, []byte{1, 2, 3}, []byte{4, 5})
+		require.NoError(t, err)
+		ret, err := lib.EvalBytecodeDirect(nil, code, []byte{1, 2, 3}, []byte{4, 5})
+		require.NoError(t, err)
+		require.EqualValues(t, exp, ret, "source: '%s'", src)
+	}
+	t.Run("lazy", func(t *testing.T) {
+		_, _, code, err := lib.CompileExpression("if($0, 1, !!!must_not_be_evaluated)")
+		require.NoError(t, err)
+		ret, err := lib.EvalBytecodeDirect(nil, code, []byte{1})
+		require.NoError(t, err)
+		require.EqualValues(t, []byte{1}, ret)
+		_, err = lib.EvalBytecodeDirect(nil, code, nil)
+		RequireErrorWith(t, err, "must not be evaluated")
+	})
+	t.Run("malformed", func(t *testing.T) {
+		_, _, code, err := lib.CompileExpression("concat(1,2)")
+		require.NoError(t, err)
+		_, err = lib.EvalBytecodeDirect(nil, code[:len(code)-1])
+		require.Error(t, err)
+		_, err = lib.EvalBytecodeDirect(nil, append(code, 1))
+		RequireErrorWith(t, err, "not all bytes have been consumed")
+	})
+}
+
+func TestEagerArgs(t *testing.T) {
+	lib := NewBase()
+	var counter int
+	lib.embedLong("count", 0, func(par *CallParams) []byte {
+		counter++
+		return []byte{1}
+	})
+	lib.embedLong("twice", 1, func(par *CallParams) []byte {
+		return concat(par.Arg(0), par.Arg(0))
+	})
+	ret, err := lib.EvalFromSource(nil, "twice(count)")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 1}, ret)
+	require.EqualValues(t, 2, counter)
+
+	require.NoError(t, lib.SetEagerArgs("twice"))
+	counter = 0
+	ret, err = lib.EvalFromSource(nil, "twice(count)")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 1}, ret)
+	require.EqualValues(t, 1, counter)
+
+	require.Error(t, lib.SetEagerArgs("max"))
+	require.Error(t, lib.SetEagerArgs("noSuchFunction"))
+}
+
+func TestWrapFunction(t *testing.T) {
+	lib := NewBase()
+	var calls int
+	counting := func(f EmbeddedFunction) EmbeddedFunction {
+		return func(par *CallParams) []byte {
+			calls++
+			return f(par)
+		}
+	}
+	require.NoError(t, lib.WrapFunction("concat", counting))
+	require.NoError(t, lib.WrapFunction("max", counting))
+	h := lib.LibraryHash()
+
+	ret, err := lib.EvalFromSource(nil, "concat(max(1,2), concat(3))")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{2, 3}, ret)
+	require.EqualValues(t, 3, calls)
+	require.EqualValues(t, h, lib.LibraryHash())
+
+	require.Error(t, lib.WrapFunction("noSuchFunction", counting))
+
+	t.Run("parsed before wrapping", func(t *testing.T) {
+		lib := NewBase()
+		_, err := lib.ExtendErr("cat3", "concat($0,$1,$2)")
+		require.NoError(t, err)
+		expr, _, _, err := lib.CompileExpression("concat(cat3(1,2,3), 4)")
+		require.NoError(t, err)
+
+		var calls int32
+		require.NoError(t, lib.WrapFunction("concat", func(f EmbeddedFunction) EmbeddedFunction {
+			return func(par *CallParams) []byte {
+				atomic.AddInt32(&calls, 1)
+				return f(par)
+			}
+		}))
+		require.EqualValues(t, []byte{1, 2, 3, 4}, EvalExpression(nil, expr))
+		require.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+		_, _, code, err := lib.CompileExpression("cat3(1,2,3)")
+		require.NoError(t, err)
+		ret, err := lib.EvalFromBytecode(nil, code)
+		require.NoError(t, err)
+		require.EqualValues(t, []byte{1, 2, 3}, ret)
+		require.EqualValues(t, 3, atomic.LoadInt32(&calls))
+		ret, err = lib.EvalBytecodeDirect(nil, code)
+		require.NoError(t, err)
+		require.EqualValues(t, []byte{1, 2, 3}, ret)
+		require.EqualValues(t, 4, atomic.LoadInt32(&calls))
+	})
+	t.Run("concurrent wrapping", func(t *testing.T) {
+		lib := NewBase()
+		expr, _, _, err := lib.CompileExpression("concat(1,2)")
+		require.NoError(t, err)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					require.EqualValues(t, []byte{1, 2}, EvalExpression(nil, expr))
+				}
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			require.NoError(t, lib.WrapFunction("concat", func(f EmbeddedFunction) EmbeddedFunction {
+				return func(par *CallParams) []byte { return f(par) }
+			}))
+		}
+		wg.Wait()
+	})
+}
+
+func TestExpressionConstructors(t *testing.T) {
+	lib := NewBase()
+	p0, err := NewParam(0)
+	require.NoError(t, err)
+	d, err := NewData([]byte{1, 2})
+	require.NoError(t, err)
+	c, err := lib.NewCall("concat", p0, d)
+	require.NoError(t, err)
+	expr, err := lib.NewCall("slice", c, mustNewData(t, 1), mustNewData(t, 2))
+	require.NoError(t, err)
+
+	_, _, code, err := lib.CompileExpression("slice(concat($0,0x0102),1,2)")
+	require.NoError(t, err)
+	require.EqualValues(t, code, ExpressionToBytecode(expr))
+	require.EqualValues(t, "slice(concat($0,0x0102),1,2)", ExpressionToSource(expr))
+	require.EqualValues(t, []byte{1, 2}, EvalExpression(nil, expr, []byte{5}))
+
+	_, err = lib.NewCall("slice", c)
+	RequireErrorWith(t, err, "3 arguments required")
+	_, err = lib.NewCall("noSuchFunction")
+	require.Error(t, err)
+	_, err = NewData(make([]byte, 128))
+	require.Error(t, err)
+	_, err = NewParam(MaxParameters)
+	require.Error(t, err)
+}
+
+func mustNewData(t *testing.T, data ...byte) *Expression {
+	ret, err := NewData(data)
+	require.NoError(t, err)
+	return ret
+}
+
+func TestVerifyInternalConsistency(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.VerifyInternalConsistency())
+
+	_, err := lib.ExtendErr("cat2", "concat($0,$1)")
+	require.NoError(t, err)
+	require.NoError(t, lib.VerifyInternalConsistency())
+
+	lib.numExtended++
+	RequireErrorWith(t, lib.VerifyInternalConsistency(), "extended functions registered")
+}
+
+func TestMemoGetOrCompute(t *testing.T) {
+	lib := NewBase()
+	var computed int
+	lib.embedLong("field", 1, func(par *CallParams) []byte {
+		path := par.Arg(0)
+		return par.MemoGetOrCompute(string(path), func() []byte {
+			computed++
+			return concat(byte(0xaa), path)
+		})
+	})
+	_, err := lib.ExtendErr("field2", "concat(field($0), field(2))")
+	require.NoError(t, err)
+
+	ret, err := lib.EvalFromSource(nil, "concat(field(1), field(2), field(1), field2(1))")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0xaa, 1, 0xaa, 2, 0xaa, 1, 0xaa, 1, 0xaa, 2}, ret)
+	require.EqualValues(t, 2, computed)
+
+	// memo does not survive the evaluation
+	_, err = lib.EvalFromSource(nil, "field(1)")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, computed)
+}
+
+func TestLibraryLock(t *testing.T) {
+	lock, err := NewBase().MakeLock()
+	require.NoError(t, err)
+	require.NoError(t, VerifyLock(lock, NewBase()))
+
+	// lock of the other encoding version
+	for _, field := range []string{"bytecode_version", "canonicalization"} {
+		var raw map[string]interface{}
+		require.NoError(t, json.Unmarshal(lock, &raw))
+		raw[field] = raw[field].(float64) + 1
+		other, err := json.Marshal(raw)
+		require.NoError(t, err)
+		err = VerifyLock(other, NewBase())
+		var errVersion *ErrVersionMismatch
+		require.True(t, errors.As(err, &errVersion), field)
+		RequireErrorWith(t, err, "version mismatch")
+	}
+
+	lib := NewBase()
+	_, err = lib.ExtendErr("cat2", "concat($0,$1)")
+	require.NoError(t, err)
+	err = VerifyLock(lock, lib)
+	RequireErrorWith(t, err, "library has")
+	var errCount *ErrFunctionCountMismatch
+	require.True(t, errors.As(err, &errCount))
+	require.EqualValues(t, errCount.Want+1, errCount.Got)
+
+	lib1 := New()
+	lib1.embedMain()
+	err = VerifyLock(lock, lib1)
+	RequireErrorWith(t, err, "is missing in the library")
+	var errMissing *ErrMissingFunction
+	require.True(t, errors.As(err, &errMissing))
+
+	lib2 := NewBase()
+	var locked LibraryLock
+	require.NoError(t, json.Unmarshal(lock, &locked))
+	for i := range locked.Functions {
+		if locked.Functions[i].Sym == "max" {
+			locked.Functions[i].BytecodeHash = "00"
+		}
+	}
+	changedLock, err := json.Marshal(&locked)
+	require.NoError(t, err)
+	err = VerifyLock(changedLock, lib2)
+	var errMismatch *ErrBytecodeMismatch
+	require.True(t, errors.As(err, &errMismatch))
+	require.EqualValues(t, "max", errMismatch.Sym)
+	require.EqualValues(t, "00", errMismatch.Want.BytecodeHash)
+
+	locked = *lib2.Lock()
+	locked.LibraryHash = "00"
+	changedLock, err = json.Marshal(&locked)
+	require.NoError(t, err)
+	err = VerifyLock(changedLock, lib2)
+	var errHash *ErrHashMismatch
+	require.True(t, errors.As(err, &errHash))
+	require.EqualValues(t, "00", errHash.Want)
+
+	require.Error(t, VerifyLock([]byte("{}"), NewBase()))
+}
+
+func TestExportClosedBytecode(t *testing.T) {
+	lib := NewBase()
+	err := lib.ExtendMany(`
+		func cat3: concat($0, $1, $0)
+		func fun1: cat3(max($0, 1), lessOrEqualThan($1, 5))
+	`)
+	require.NoError(t, err)
+
+	code, err := lib.ExportClosedBytecode("fun1")
+	require.NoError(t, err)
+	src, err := lib.DecompileBytecode(code)
+	require.NoError(t, err)
+	t.Logf("closed source: %s", src)
+
+	embeddedOnly := New()
+	embeddedOnly.embedBase()
+	for _, args := range [][][]byte{{{0}, {3}}, {{7}, {5}}, {{7}, {6}}} {
+		exp, err := lib.EvalFromSource(nil, "fun1($0,$1)", args...)
+		require.NoError(t, err)
+		ret, err := embeddedOnly.EvalFromBytecode(nil, code, args...)
+		require.NoError(t, err)
+		require.EqualValues(t, exp, ret)
+	}
+	_, err = lib.ExportClosedBytecode("concat")
+	require.Error(t, err)
+	_, err = lib.ExportClosedBytecode("bytecode")
+	RequireErrorWith(t, err, "can't be inlined")
+}
+
+func TestCheckSemantics(t *testing.T) {
+	lib := NewBase()
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	require.NoError(t, lib.CheckSemantics(rnd, 100))
+
+	lib.embedLong("brokenHash", -1, func(par *CallParams) []byte {
+		return evalBlake2b(par)[:31]
+	})
+	require.NoError(t, lib.AnnotateSemantics("brokenHash", "", "equal(len($0), u64/32)"))
+	RequireErrorWith(t, lib.CheckSemantics(rnd, 10), "does not hold")
+
+	require.Error(t, lib.AnnotateSemantics("brokenHash", "", "noSuchFunction($0)"))
+}
+
+func TestBaseFunctionCodesStable(t *testing.T) {
+	// function codes of the base library are part of the bytecode. New functions must not shift them
+	lib := NewBase()
+	expected := map[string]uint16{
+		"fail":              16,
+		"isZero":            25,
+		"uint64Bytes":       31,
+		"bitwiseXOR":        36,
+		"concat":            64,
+		"selectCaseByIndex": 70,
+		"rshift64":          72,
+		"blake2b":           74,
+		"eval":              77,
+		"false":             FirstExtendedFun,
+		"min":               FirstExtendedFun + 11,
+	}
+	for sym, funCode := range expected {
+		fi, err := lib.functionByName(sym)
+		require.NoError(t, err)
+		require.EqualValues(t, funCode, fi.FunCode, "function '%s'", sym)
+	}
+}
+
+func TestCompileWarnings(t *testing.T) {
+	lib := NewBase()
+	_, _, _, warnings, err := lib.CompileExpressionWithWarnings("concat($0, $1)")
+	require.NoError(t, err)
+	require.EqualValues(t, 0, len(warnings))
+
+	require.NoError(t, lib.DeprecateFunction("lessThanUint", "use lessThan"))
+	_, n, _, warnings, err := lib.CompileExpressionWithWarnings("concat($0, $$2, 0x, 0x05, 0x0102, lessThanUint(1,2))")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, n)
+	kinds := make([]CompileWarningKind, 0)
+	for _, w := range warnings {
+		t.Logf("%s", w)
+		kinds = append(kinds, w.Kind)
+	}
+	require.EqualValues(t, []CompileWarningKind{
+		WarningNonCanonicalLiteral,
+		WarningNonCanonicalLiteral,
+		WarningDeprecatedFunction,
+		WarningUnusedParameter,
+	}, kinds)
+}
+
+func TestEvalBatch(t *testing.T) {
+	lib := NewBase()
+	_, _, code, err := lib.CompileExpression("concat($0, $1)")
+	require.NoError(t, err)
+	_, _, codeFail, err := lib.CompileExpression("fail(1)")
+	require.NoError(t, err)
+
+	items := make([]BatchItem, 0)
+	for i := 0; i < 100; i++ {
+		items = append(items, BatchItem{Bytecode: code, Args: [][]byte{{byte(i)}, {1, 2}}})
+	}
+	items = append(items, BatchItem{Bytecode: codeFail})
+	items = append(items, BatchItem{Bytecode: []byte{0xff, 0xff, 0xff}})
+
+	for _, numWorkers := range []int{1, 4} {
+		res := lib.EvalBatch(nil, items, numWorkers)
+		require.EqualValues(t, len(items), len(res))
+		for i := 0; i < 100; i++ {
+			require.NoError(t, res[i].Err)
+			require.EqualValues(t, []byte{byte(i), 1, 2}, res[i].Result)
+		}
+		require.Error(t, res[100].Err)
+		require.Error(t, res[101].Err)
+	}
+}
+
+func TestFmtBounded(t *testing.T) {
+	defer SetFmtMaxBytes(0)
+	defer SetFmtRedactor(nil)
+
+	data := bytes.Repeat([]byte{0xab}, 100)
+	require.EqualValues(t, Hex(data), Fmt(data))
+
+	SetFmtMaxBytes(4)
+	require.EqualValues(t, "100xabababab..(+96)", Fmt(data))
+	require.EqualValues(t, "2x0102", Fmt([]byte{1, 2}))
+
+	SetFmtRedactor(func(data []byte) (string, bool) {
+		if len(data) == 64 {
+			return "<redacted>", true
+		}
+		return "", false
+	})
+	require.EqualValues(t, "<redacted>", Fmt(make([]byte, 64)))
+	require.EqualValues(t, "100xabababab..(+96)", Fmt(data))
+
+	SetFmtRedactor(nil)
+	require.EqualValues(t, "64x00000000..(+60)", Fmt(make([]byte, 64)))
+}
+
+func TestJSONTrace(t *testing.T) {
+	lib := NewBase()
+	var buf bytes.Buffer
+	glb := NewGlobalDataJSONTrace(nil, &buf, 2)
+	expr, _, _, err := lib.CompileExpression("concat($0, if(1, 0x010203, 2))")
+	require.NoError(t, err)
+	res := EvalExpression(glb, expr, []byte{5})
+	require.EqualValues(t, []byte{5, 1, 2, 3}, res)
+	require.NoError(t, glb.Err())
+
+	type line struct {
+		Fun    string   `json:"fun"`
+		Args   []string `json:"args"`
+		Result string   `json:"result"`
+		Depth  int      `json:"depth"`
+		Msg    string   `json:"msg"`
+	}
+	events := make(map[string]line)
+	for _, s := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		t.Logf("%s", s)
+		var l line
+		require.NoError(t, json.Unmarshal([]byte(s), &l))
+		if l.Fun != "" {
+			events[l.Fun] = l
+		}
+	}
+	require.EqualValues(t, 0, events["concat"].Depth)
+	require.EqualValues(t, "4x0501..(+2)", events["concat"].Result)
+	require.EqualValues(t, []string{"1x05", "3x0102..(+1)"}, events["concat"].Args)
+	require.EqualValues(t, 1, events["if"].Depth)
+	require.EqualValues(t, 2, events["0x010203"].Depth)
+
+	_, err = lib.EvalFromSource(glb, "concat(fail(7))")
+	require.Error(t, err)
+	require.Contains(t, buf.String(), `"fun":"fail","error":"`)
+}
+
+func TestUsedFunctions(t *testing.T) {
+	lib := NewBase()
+	_, _, code, err := lib.CompileExpression("concat(if(equal($0, 1), add(1,2), concat), not(nil), $$1, min(1,2))")
+	require.NoError(t, err)
+	used, err := lib.UsedFunctions(code)
+	require.NoError(t, err)
+	syms := make([]string, len(used))
+	for i, fi := range used {
+		syms[i] = fi.Sym
+	}
+	require.EqualValues(t, []string{"concat", "if", "equal", "add", "not", "min"}, syms)
+	require.True(t, used[0].IsEmbedded)
+	require.False(t, used[0].IsShort)
+	require.True(t, used[2].IsShort)
+	require.False(t, used[5].IsEmbedded)
+
+	// unknown long call with 1 argument
+	unknownCall := []byte{FirstByteLongCallMask | (1 << 2) | 0x03, 0xf0}
+	code = concat(unknownCall, code)
+	used, err = lib.UsedFunctions(code)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown function codes [1008]")
+	require.EqualValues(t, 6, len(used))
+
+	_, err = lib.UsedFunctions(code[:len(code)-1])
+	require.Error(t, err)
+}
+
+func TestLocalLibraryLongIndex(t *testing.T) {
+	lib := NewBase()
+	const numFun = 300
+	var src strings.Builder
+	src.WriteString("func f0 : concat($0, 1)\n")
+	for i := 1; i < numFun; i++ {
+		src.WriteString(fmt.Sprintf("func f%d : f%d($0)\n", i, i-1))
+	}
+	src.WriteString("func callLast : concat(f299(0), f254(2), f255(3))\n")
+	libData, err := lib.CompileLocalLibrary(src.String())
+	require.NoError(t, err)
+	require.EqualValues(t, numFun+1, len(libData))
+	// f254 calls f253 with the 1-byte index, f256 calls f255 with the 2-byte index
+	require.EqualValues(t, 4, len(libData[254]))
+	require.EqualValues(t, 6, len(libData[256]))
+
+	res, err := lib.EvalFromLibrary(nil, libData, numFun)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0, 1, 2, 1, 3, 1}, res)
+
+	res, err = lib.EvalFromLibrary(nil, libData, 299, []byte{7})
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{7, 1}, res)
+
+	used, err := lib.UsedFunctions(libData[numFun])
+	require.NoError(t, err)
+	require.EqualValues(t, "lib#299", used[1].Sym)
+	require.EqualValues(t, "lib#255", used[3].Sym)
+
+	// 2-byte index below the escape is not canonical
+	nonCanonical := concat(libData[256][:2], LocalFunIndexEscape, 0, 5, libData[256][5:])
+	_, err = lib.LocalLibraryFromBytes(append(append([][]byte{}, libData[:256]...), nonCanonical))
+	require.Error(t, err)
+}
+
+func TestCheckBytecodeCompat(t *testing.T) {
+	oldLib := NewBase()
+	oldLib.MustExtendMany(`
+func ext1 : concat($0, 1)
+func ext2 : concat($0, 2)
+func ext3 : 3
+`)
+	newLib := NewBase()
+	newLib.MustExtendMany(`
+func ext1 : concat($0, 1)
+func ext2 : concat($0, 22)
+func ext3renamed : 3
+`)
+	corpus := make([][]byte, 0)
+	for _, src := range []string{"concat(1,2)", "ext1(5)", "ext2(5)", "ext3", "ext1($0)"} {
+		_, _, code, err := oldLib.CompileExpression(src)
+		require.NoError(t, err)
+		corpus = append(corpus, code)
+	}
+	report, err := CheckBytecodeCompat(oldLib, newLib, corpus, false)
+	require.NoError(t, err)
+	t.Logf("%s", report)
+	require.EqualValues(t, 1, report.NumIncompatible)
+	require.EqualValues(t, "decompiles differently", report.Items[3].Problem)
+
+	report, err = CheckBytecodeCompat(oldLib, newLib, corpus, true)
+	require.NoError(t, err)
+	t.Logf("%s", report)
+	require.False(t, report.OK())
+	require.EqualValues(t, 2, report.NumIncompatible)
+	require.Contains(t, report.Items[2].Problem, "results differ")
+	require.True(t, report.Items[1].Evaluated)
+	require.False(t, report.Items[4].Evaluated)
+
+	report, err = CheckBytecodeCompat(oldLib, oldLib, corpus, true)
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	_, err = CheckBytecodeCompat(oldLib, newLib, [][]byte{{0xff}}, true)
+	require.Error(t, err)
+}
+
+func TestCallParamsArgs(t *testing.T) {
+	lib := NewBase()
+	counter := 0
+	lib.UpgradeWthEmbeddedLong(
+		&EmbeddedFunctionData{"countCalls", 0, func(par *CallParams) []byte {
+			counter++
+			return []byte{byte(counter)}
+		}},
+		&EmbeddedFunctionData{"sumBytes", -1, func(par *CallParams) []byte {
+			require.EqualValues(t, par.Arity(), len(par.RawArgs()))
+			sum := byte(0)
+			for _, a := range par.Args() {
+				for _, b := range a {
+					sum += b
+				}
+			}
+			// already evaluated
+			for i := range par.Args() {
+				par.Arg(byte(i))
+			}
+			return []byte{sum}
+		}},
+	)
+	res, err := lib.EvalFromSource(nil, "sumBytes(0x0102, countCalls, 3)")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{7}, res)
+	require.EqualValues(t, 1, counter)
+
+	res, err = lib.EvalFromSource(nil, "sumBytes")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0}, res)
+}
+
+func TestLibraryTag(t *testing.T) {
+	lib := NewBase()
+	_, _, code, err := lib.CompileExpression("concat(1,2)")
+	require.NoError(t, err)
+
+	wrapped := lib.WrapWithLibraryTag(code)
+	require.True(t, HasLibraryTag(wrapped))
+	require.EqualValues(t, LibraryTagSize+len(code), len(wrapped))
+	unwrapped, err := lib.UnwrapAndVerifyTag(wrapped)
+	require.NoError(t, err)
+	require.EqualValues(t, code, unwrapped)
+
+	_, err = lib.UnwrapAndVerifyTag(code)
+	RequireErrorWith(t, err, "not wrapped")
+
+	libNew := NewBase()
+	libNew.MustExtendMany("func newFun : 1")
+	_, err = libNew.UnwrapAndVerifyTag(wrapped)
+	RequireErrorWith(t, err, "targets library")
+}
+
+func benchmarkEval(b *testing.B, source string, args ...[]byte) {
+	lib := NewBase()
+	expr, _, _, err := lib.CompileExpression(source)
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		EvalExpression(nil, expr, args...)
+	}
+}
+
+func BenchmarkEvalBinary(b *testing.B) {
+	benchmarkEval(b, "and(equal(add($0, 1), u64/3), lessThan($0, 5), equal(concat($1, 2), 0x0102))", []byte{2}, []byte{1})
+}
+
+func BenchmarkEvalVararg(b *testing.B) {
+	benchmarkEval(b, "concat($0, $1, 3, 4, 5, 6, 7)", []byte{1}, []byte{2})
+}
+
+func TestDecompileToBundle(t *testing.T) {
+	lib := NewBase()
+	_, _, code, err := lib.CompileExpression("concat(if(equal($0, 1), 0x0102, nil), min(1,2))")
+	require.NoError(t, err)
+	b, err := lib.DecompileToBundle(code)
+	require.NoError(t, err)
+	require.EqualValues(t, lib.LibraryHash(), b.LibraryHash)
+	require.EqualValues(t, "concat(if(equal($0,1),0x0102,nil),min(1,2))", b.Source)
+	require.EqualValues(t, 10, len(b.Breakdown))
+	require.EqualValues(t, code, b.Breakdown[0].Bytecode)
+	require.EqualValues(t, 3, b.Breakdown[3].Depth)
+	require.EqualValues(t, "$0", b.Breakdown[3].Source)
+	require.EqualValues(t, 4, len(b.Functions))
+
+	y := string(b.YAML())
+	t.Logf("\n%s", y)
+	require.Contains(t, y, "source: \"concat(if(equal($0,1),0x0102,nil),min(1,2))\"\n")
+	require.Contains(t, y, "  - sym: \"min\"\n")
+
+	_, err = lib.DecompileToBundle([]byte{0xff})
+	require.Error(t, err)
+}
+
+func TestRequireErr(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.RegisterErrorCode(1001, "signature is not valid"))
+	RequireErrorWith(t, lib.RegisterErrorCode(1001, "other"), "already registered")
+
+	_, err := lib.EvalFromSource(nil, "requireErr(equal(1,2), u16/1001)")
+	RequireErrorWith(t, err, "SCRIPT FAIL: error #1001: signature is not valid")
+	_, err = lib.EvalFromSource(nil, "requireErr(equal(1,2), u16/1002)")
+	RequireErrorWith(t, err, "SCRIPT FAIL: error #1002")
+
+	res, err := lib.EvalFromSource(nil, "requireErr(equal(1,1), fail(1))")
+	require.NoError(t, err)
+	require.True(t, len(res) > 0)
+}
+
+func TestLibraryHashMemoized(t *testing.T) {
+	lib := NewBase()
+	h1 := lib.LibraryHash()
+	require.EqualValues(t, h1, blake2b.Sum256(lib.libraryBytes()))
+	require.EqualValues(t, h1, lib.LibraryHash())
+
+	lib.MustExtendMany("func newFun : 1")
+	h2 := lib.LibraryHash()
+	require.NotEqualValues(t, h1, h2)
+	require.EqualValues(t, h2, blake2b.Sum256(lib.libraryBytes()))
+	lib2 := NewBase()
+	lib2.MustExtendMany("func newFun : 1")
+	require.EqualValues(t, h2, lib2.LibraryHash())
+}
+
+func BenchmarkLibraryHash(b *testing.B) {
+	lib := NewBase()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lib.LibraryHash()
+	}
+}
+
+func TestLocalLibraryErrorLines(t *testing.T) {
+	lib := NewBase()
+	const source = `
+// comment
+func fun1 : concat($0, $1)
+
+func fun2 : concat(
+    fun1($0,2),
+    unknownFun(3,4)
+)
+`
+	_, err := lib.CompileLocalLibrary(source)
+	RequireErrorWith(t, err, "error while compiling 'fun2' at line 5")
+
+	_, err = lib.CompileLocalLibrary("func fun1 : 1\nfunc fun1 : 2")
+	RequireErrorWith(t, err, "repeating symbol 'fun1' at line 2")
+
+	_, err = lib.CompileLocalLibrary("\n1\nfunc fun1 : 1")
+	RequireErrorWith(t, err, "unexpectected symbols @ line 2")
+
+	err = lib.ExtendMany("func fun1 : 1\nfunc fun2 : fun3")
+	RequireErrorWith(t, err, "(line 2)")
+}
+
+func TestInternalFunctions(t *testing.T) {
+	lib := NewBase()
+	lib.MustExtendMany(`
+func helper : concat($0, 1)
+func public : helper($0)
+`)
+	_, _, codeHelper, err := lib.CompileExpression("helper(5)")
+	require.NoError(t, err)
+	hashBefore := lib.LibraryHash()
+
+	require.NoError(t, lib.MarkInternal("helper"))
+	RequireErrorWith(t, lib.MarkInternal("concat"), "only extended functions")
+	require.NotEqualValues(t, hashBefore, lib.LibraryHash())
+
+	// library functions can still call it
+	lib.MustExtendMany("func public2 : helper(helper($0))")
+	res, err := lib.EvalFromSource(nil, "public2(5)")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{5, 1, 1}, res)
+
+	_, err = lib.EvalFromSource(nil, "helper(5)")
+	RequireErrorWith(t, err, "internal library function")
+	_, err = lib.CompileLocalLibrary("func f : helper($0)")
+	RequireErrorWith(t, err, "internal library function")
+	_, err = lib.NewCall("helper", mustNewData(t, 5))
+	RequireErrorWith(t, err, "internal library function")
+
+	RequireErrorWith(t, lib.CheckExternalBytecode(codeHelper), "internal library function")
+	_, _, codePublic, err := lib.CompileExpression("public(5)")
+	require.NoError(t, err)
+	require.NoError(t, lib.CheckExternalBytecode(codePublic))
+
+	// bytecode evaluated dynamically can't call it
+	for _, src := range []string{"eval(0x%s)", "applyN(0x%s, 1, 0)", "concat(1, eval(concat(0x%s)))"} {
+		_, err = lib.EvalFromSource(nil, fmt.Sprintf(src, hex.EncodeToString(codeHelper)))
+		RequireErrorWith(t, err, "internal library function")
+	}
+	res, err = lib.EvalFromSource(nil, fmt.Sprintf("eval(0x%s)", hex.EncodeToString(codePublic)))
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{5, 1}, res)
+
+	// local library can't call it
+	var libErr *LocalLibraryError
+	_, err = lib.LocalLibraryFromBytes([][]byte{codePublic, codeHelper})
+	require.True(t, errors.As(err, &libErr))
+	require.EqualValues(t, 1, libErr.Index)
+	RequireErrorWith(t, err, "internal library function")
+}
+
+func TestLocalLibraryLimits(t *testing.T) {
+	lib := NewBase()
+	libData, err := lib.CompileLocalLibrary(`
+func fun1 : concat($0, $1)
+func fun2 : fun1($0, 0x0102030405)
+`)
+	require.NoError(t, err)
+
+	_, err = lib.LocalLibraryFromBytesWithLimits(libData, LocalLibraryLimits{MaxFunctions: 2, MaxFunctionSize: 20, MaxTotalSize: 30})
+	require.NoError(t, err)
+
+	var libErr *LocalLibraryError
+	_, err = lib.LocalLibraryFromBytesWithLimits(libData, LocalLibraryLimits{MaxFunctions: 1})
+	require.True(t, errors.Is(err, ErrLocalLibraryTooManyFunctions))
+
+	_, err = lib.LocalLibraryFromBytesWithLimits(libData, LocalLibraryLimits{MaxFunctionSize: 6})
+	require.True(t, errors.Is(err, ErrLocalLibraryFunctionTooLarge))
+	require.True(t, errors.As(err, &libErr))
+	require.EqualValues(t, 1, libErr.Index)
+
+	_, err = lib.LocalLibraryFromBytesWithLimits(libData, LocalLibraryLimits{MaxTotalSize: len(libData[0]) + len(libData[1]) - 1})
+	require.True(t, errors.Is(err, ErrLocalLibraryTooLarge))
+
+	// fun2 calls fun1 with 1 argument instead of 2
+	wrongArity := concat(libData[1][0]&^FirstByteLongCallArityMask|(1<<2), libData[1][1:3], libData[1][3:4])
+	_, err = lib.LocalLibraryFromBytes([][]byte{libData[0], wrongArity})
+	require.True(t, errors.As(err, &libErr))
+	require.EqualValues(t, 1, libErr.Index)
+	RequireErrorWith(t, err, "wrong number of call args")
+}
+
+func TestCanonicalBytecode(t *testing.T) {
+	lib := NewBase()
+	_, _, code, err := lib.CompileExpression("concat(equal($0, 1), $$1, 0x0102, min(1,2))")
+	require.NoError(t, err)
+	canonical, err := lib.CanonicalBytecode(code)
+	require.NoError(t, err)
+	require.EqualValues(t, code, canonical)
+
+	// 'equal' is a short embedded function, encode its call with the long prefix
+	fi, err := lib.functionByName("equal")
+	require.NoError(t, err)
+	require.True(t, fi.IsShort)
+	u16 := uint16(FirstByteLongCallMask|(2<<2))<<8 | fi.FunCode
+	longEqual := []byte{byte(u16 >> 8), byte(u16)}
+	_, _, codeShort, err := lib.CompileExpression("equal(1, 2)")
+	require.NoError(t, err)
+	codeLong := concat(longEqual, codeShort[1:])
+
+	res, err := lib.EvalFromBytecode(nil, codeLong)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, len(res))
+
+	canonical, err = lib.CanonicalBytecode(codeLong)
+	require.NoError(t, err)
+	require.EqualValues(t, codeShort, canonical)
+
+	id1, err := lib.BytecodeID(codeShort)
+	require.NoError(t, err)
+	id2, err := lib.BytecodeID(codeLong)
+	require.NoError(t, err)
+	require.EqualValues(t, id1, id2)
+	require.EqualValues(t, blake2b.Sum256(codeShort), id1)
+
+	_, err = lib.BytecodeID([]byte{0xff})
+	require.Error(t, err)
+}
+
+func TestResultFormatter(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.SetResultFormatter("add", func(data []byte) string {
+		return fmt.Sprintf("%d", binary.BigEndian.Uint64(data))
+	}))
+	RequireErrorWith(t, lib.SetResultFormatter("noSuchFun", nil), "no such function")
+
+	s, ok := lib.FormatResult("add", []byte{0, 0, 0, 0, 0, 0, 1, 0})
+	require.True(t, ok)
+	require.EqualValues(t, "256", s)
+	_, ok = lib.FormatResult("concat", nil)
+	require.False(t, ok)
+
+	var buf bytes.Buffer
+	glb := NewGlobalDataJSONTrace(nil, &buf, 0)
+	glb.SetResultFormatter(lib)
+	_, err := lib.EvalFromSource(glb, "concat(add(u64/250, 6), 1)")
+	require.NoError(t, err)
+	t.Logf("\n%s", buf.String())
+	require.Contains(t, buf.String(), `"fun":"add","args":["8x00000000000000fa","1x06"],"result":"256"`)
+	require.Contains(t, buf.String(), `"fun":"concat","args":["8x0000000000000100","1x01"],"result":"9x000000000000010001"`)
+	require.NotContains(t, buf.String(), `'add' -> 256`)
+
+	// string trace
+	log := NewGlobalDataLog(nil)
+	_, err = lib.EvalFromSource(log, "concat(add(u64/250, 6), 1)")
+	require.NoError(t, err)
+	require.Contains(t, log.Log(), "'add' -> 256")
+	_, _, code, err := lib.CompileExpression("concat(add(u64/250, 6), 1)")
+	require.NoError(t, err)
+	log = NewGlobalDataLog(nil)
+	_, err = lib.EvalBytecodeDirect(log, code)
+	require.NoError(t, err)
+	require.Contains(t, log.Log(), "'add' -> 256")
+
+	require.NoError(t, lib.SetResultFormatter("add", nil))
+	_, ok = lib.FormatResult("add", []byte{0, 0, 0, 0, 0, 0, 1, 0})
+	require.False(t, ok)
+	log = NewGlobalDataLog(nil)
+	_, err = lib.EvalFromSource(log, "concat(add(u64/250, 6), 1)")
+	require.NoError(t, err)
+	require.NotContains(t, log.Log(), "'add' -> 256")
+
+	// formatters can be set while the library is used
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, err := lib.EvalFromSource(NewGlobalDataLog(nil), "add(1, 2)")
+			require.NoError(t, err)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, lib.SetResultFormatter("add", func(data []byte) string { return "sum" }))
+	}
+	wg.Wait()
+}
+
+func TestBytecodeParameterConformance(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.CheckConformance(BytecodeParameterVectors))
+	_, err := lib.ConformanceJSON(BytecodeParameterVectors)
+	require.NoError(t, err)
+
+	// direct interpretation takes the same bytecode of arguments
+	for _, v := range BytecodeParameterVectors {
+		ret, err := lib.EvalBytecodeDirect(nil, mustCompile(t, lib, v.Source))
+		require.NoError(t, err)
+		require.EqualValues(t, v.Result, ret, v.Name)
+	}
+}
+
+func TestParsingConformance(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.CheckConformance(ParsingVectors))
+	data, err := lib.ConformanceJSON(ParsingVectors)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"bytecode": "45408101"`)
+	require.Contains(t, string(data), `"bytecode": "",`)
+
+	fd := lib.funByName["true"]
+	require.EqualValues(t, 0, fd.requiredNumParams)
+	require.EqualValues(t, fd.funCode, FunCodeFromPrefix(ParsingVectors[3].Bytecode))
+	require.EqualValues(t, 1, ArityFromPrefix(ParsingVectors[3].Bytecode))
+}
+
+func TestShortCircuitConformance(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.CheckConformance(ShortCircuitVectors))
+
+	data, err := lib.ConformanceJSON(ShortCircuitVectors)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"source": "and(1, nil, fail(1))"`)
+
+	err = lib.CheckConformance([]ConformanceVector{{Name: "wrong", Source: "and(1, 2)", Result: nil}})
+	RequireErrorWith(t, err, "conformance 'wrong'")
+
+	require.Contains(t, string(data), `"calls": [`)
+	err = lib.CheckConformance([]ConformanceVector{{
+		Name:   "calls",
+		Source: "concat(min(1, 2), if(1, 2, fail(1)))",
+		Result: []byte{1, 2},
+		Calls:  []string{"concat", "min", "if", "lessThan", "if"},
+	}})
+	require.NoError(t, err)
+	err = lib.CheckConformance([]ConformanceVector{{Name: "wrong calls", Source: "or(nil, 1, 2)", Result: []byte{0xff}, Calls: []string{"or", "fail"}}})
+	RequireErrorWith(t, err, "expected calls")
+
+	RequireErrorWith(t, lib.SetEagerArgs("and"), "lazily by definition")
+}
+
+func TestEvalRecursionLimit(t *testing.T) {
+	lib := NewBase()
+	var selfEval []byte
+	lib.UpgradeWthEmbeddedLong(&EmbeddedFunctionData{"selfEvalCode", 0, func(par *CallParams) []byte {
+		return selfEval
+	}})
+	var err error
+	_, _, selfEval, err = lib.CompileExpression("eval(selfEvalCode)")
+	require.NoError(t, err)
+
+	_, err = lib.EvalFromBytecode(nil, selfEval)
+	var errRecursion *ErrEvalRecursion
+	require.True(t, errors.As(err, &errRecursion))
+	require.EqualValues(t, DefaultMaxEvalRecursion, errRecursion.Limit)
+
+	lib.SetMaxEvalRecursion(3)
+	_, _, code, err := lib.CompileExpression("concat(1,2)")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, _, code, err = lib.CompileExpression(fmt.Sprintf("eval(0x%s)", hex.EncodeToString(code)))
+		require.NoError(t, err)
+	}
+	res, err := lib.EvalFromBytecode(nil, code)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 2}, res)
+	_, _, code, err = lib.CompileExpression(fmt.Sprintf("eval(0x%s)", hex.EncodeToString(code)))
+	require.NoError(t, err)
+	_, err = lib.EvalFromBytecode(nil, code)
+	require.True(t, errors.As(err, &errRecursion))
+	_, err = lib.EvalFromBytecode(nil, selfEval)
+	require.True(t, errors.As(err, &errRecursion))
+	require.EqualValues(t, 3, errRecursion.Limit)
+
+	// the limit is per nesting, not per number of evaluations
+	_, err = lib.EvalFromSource(nil, "concat(eval(0x8101), eval(0x8102), eval(0x8103), eval(0x8104))")
+	require.NoError(t, err)
+}
+
+func TestPrefixFields(t *testing.T) {
+	lib := NewBase()
+	for _, sym := range []string{"concat", "equal", "min"} {
+		fi, err := lib.functionByName(sym)
+		require.NoError(t, err)
+		prefix, err := fi.callPrefix(2)
+		require.NoError(t, err)
+		require.EqualValues(t, !fi.IsShort, IsLongCall(prefix))
+		require.EqualValues(t, fi.FunCode, FunCodeFromPrefix(prefix))
+		if fi.IsShort {
+			require.EqualValues(t, -1, ArityFromPrefix(prefix))
+		} else {
+			require.EqualValues(t, 2, ArityFromPrefix(prefix))
+		}
+	}
+	require.False(t, IsLongCall([]byte{0x81, 0x01}))
+	require.False(t, IsLongCall(nil))
+	require.EqualValues(t, 3, FunCodeFromPrefix([]byte{3}))
+
+	local := &funInfo{FunCode: FirstLocalFunCode + 300, IsLocal: true, NumParams: 1}
+	prefix, err := local.callPrefix(1)
+	require.NoError(t, err)
+	require.True(t, IsLongCall(prefix))
+	require.EqualValues(t, 1, ArityFromPrefix(prefix))
+	require.EqualValues(t, FirstLocalFunCode+300, FunCodeFromPrefix(prefix))
+}
+
+func TestShadowLibrary(t *testing.T) {
+	lib := NewBase()
+	lib.MustExtendMany("func fee : u64/100")
+	upgraded := NewBase()
+	upgraded.MustExtendMany("func fee : u64/120")
+
+	divergences := make([]*ShadowDivergence, 0)
+	err := lib.SetShadow(upgraded, func(d *ShadowDivergence) {
+		divergences = append(divergences, d)
+	})
+	require.NoError(t, err)
+
+	_, _, code, err := lib.CompileExpression("concat(1,2)")
+	require.NoError(t, err)
+	res, err := lib.EvalFromBytecode(nil, code)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 2}, res)
+	require.EqualValues(t, 0, len(divergences))
+
+	// the shadow does not consume gas of the evaluation and does not trace to the global data
+	meter := lib.NewGasMeter(1000)
+	_, err = lib.EvalFromBytecode(WithGasMeter(nil, meter), code)
+	require.NoError(t, err)
+	used := meter.Used()
+	exact := lib.NewGasMeter(used)
+	_, err = lib.EvalFromBytecode(WithGasMeter(nil, exact), code)
+	require.NoError(t, err)
+	require.EqualValues(t, used, exact.Used())
+	log := NewGlobalDataLog(nil)
+	_, err = lib.EvalFromBytecode(log, code)
+	require.NoError(t, err)
+	numTraced := len(log.Log())
+	require.NoError(t, lib.SetShadow(nil, nil))
+	log = NewGlobalDataLog(nil)
+	_, err = lib.EvalFromBytecode(log, code)
+	require.NoError(t, err)
+	require.EqualValues(t, numTraced, len(log.Log()))
+	require.EqualValues(t, 0, len(divergences))
+	err = lib.SetShadow(upgraded, nil)
+	RequireErrorWith(t, err, "must not be nil")
+	require.NoError(t, lib.SetShadow(upgraded, func(d *ShadowDivergence) {
+		divergences = append(divergences, d)
+	}))
+
+	_, _, code, err = lib.CompileExpression("fee")
+	require.NoError(t, err)
+	res, err = lib.EvalFromBytecode(nil, code)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, binary.BigEndian.Uint64(res))
+	require.EqualValues(t, 1, len(divergences))
+	require.EqualValues(t, 120, binary.BigEndian.Uint64(divergences[0].ShadowResult))
+
+	require.NoError(t, lib.SetShadow(nil, nil))
+	_, err = lib.EvalFromBytecode(nil, code)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, len(divergences))
+
+	// the shadow can be switched while the library is used. Run with -race
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = lib.EvalFromBytecode(nil, code)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, lib.SetShadow(upgraded, func(d *ShadowDivergence) {}))
+		require.NoError(t, lib.SetShadow(nil, nil))
+	}
+	wg.Wait()
+}
+
+func TestTypedEval(t *testing.T) {
+	lib := NewBase()
+	compile := func(src string) []byte {
+		_, _, code, err := lib.CompileExpression(src)
+		require.NoError(t, err)
+		return code
+	}
+	v, err := lib.EvalUint64(nil, compile("add($0, 5)"), []byte{10})
+	require.NoError(t, err)
+	require.EqualValues(t, 15, v)
+	v, err = lib.EvalUint64(nil, compile("0x0102"))
+	require.NoError(t, err)
+	require.EqualValues(t, 0x0102, v)
+	_, err = lib.EvalUint64(nil, compile("nil"))
+	RequireErrorWith(t, err, "is not uint64")
+	_, err = lib.EvalUint64(nil, compile("fail(1)"))
+	RequireErrorWith(t, err, "error #1")
+
+	b, err := lib.EvalBool(nil, compile("equal(1,1)"))
+	require.NoError(t, err)
+	require.True(t, b)
+	b, err = lib.EvalBool(nil, compile("equal(1,2)"))
+	require.NoError(t, err)
+	require.False(t, b)
+
+	res, err := lib.EvalBytesN(nil, 32, compile("blake2b(1)"))
+	require.NoError(t, err)
+	require.EqualValues(t, 32, len(res))
+	_, err = lib.EvalBytesN(nil, 32, compile("1"))
+	RequireErrorWith(t, err, "expected to be 32 bytes long")
+}
+
+func randomSource(lib *Library, rnd *rand.Rand, syms []string, depth int) string {
+	if depth == 0 || rnd.Intn(4) == 0 {
+		switch rnd.Intn(5) {
+		case 0:
+			return fmt.Sprintf("$%d", rnd.Intn(MaxParameters))
+		case 1:
+			return fmt.Sprintf("$$%d", rnd.Intn(MaxParameters))
+		case 2:
+			return "nil"
+		case 3:
+			return fmt.Sprintf("%d", rnd.Intn(256))
+		default:
+			data := make([]byte, 2+rnd.Intn(20))
+			rnd.Read(data)
+			return fmt.Sprintf("0x%s", hex.EncodeToString(data))
+		}
+	}
+	sym := syms[rnd.Intn(len(syms))]
+	fi, err := lib.functionByName(sym)
+	AssertNoError(err)
+	n := fi.NumParams
+	if n < 0 {
+		n = rnd.Intn(4)
+	}
+	if n == 0 {
+		return sym
+	}
+	args := make([]string, n)
+	for i := range args {
+		args[i] = randomSource(lib, rnd, syms, depth-1)
+	}
+	return fmt.Sprintf("%s(%s)", sym, strings.Join(args, ","))
+}
+
+func TestCompileDecompileRoundTrip(t *testing.T) {
+	lib := NewBase()
+	syms := make([]string, 0)
+	for sym, fd := range lib.funByName {
+		// functions taking bytecode of arguments reject parameter references, which are random leaves
+		if fd.requiredNumParams <= 3 && fd.bytecodeParams == 0 {
+			syms = append(syms, sym)
+		}
+	}
+	sort.Strings(syms)
+	rnd := rand.New(rand.NewSource(31415))
+	for i := 0; i < 1000; i++ {
+		src := randomSource(lib, rnd, syms, 4)
+		_, _, code1, err := lib.CompileExpression(src)
+		require.NoError(t, err, src)
+		src2, err := lib.DecompileBytecode(code1)
+		require.NoError(t, err, src)
+		_, _, code2, err := lib.CompileExpression(src2)
+		require.NoError(t, err, src2)
+		require.EqualValues(t, code1, code2, "%s -> %s", src, src2)
+		src3, err := lib.DecompileBytecode(code2)
+		require.NoError(t, err)
+		require.EqualValues(t, src2, src3)
+	}
+	// literals are normalized
+	src, err := lib.DecompileBytecode(mustCompile(t, lib, "or(0x, 0x05, 0x0005)"))
+	require.NoError(t, err)
+	require.EqualValues(t, "or(nil,5,0x0005)", src)
+
+	_, _, _, err = lib.CompileExpression("concat($8)")
+	require.Error(t, err)
+	_, _, _, err = lib.CompileExpression("concat($$8)")
+	require.Error(t, err)
+}
+
+func mustCompile(t testing.TB, lib *Library, src string) []byte {
+	_, _, code, err := lib.CompileExpression(src)
+	require.NoError(t, err, src)
+	return code
+}
+
+// FuzzDecompileReparse checks that any bytecode accepted by the parser decompiles into the source,
+// which compiles back into the canonical form of the bytecode
+func FuzzDecompileReparse(f *testing.F) {
+	lib := NewBase()
+	for _, src := range []string{"concat(1,2)", "if(equal($0,1),$$1,0x0102)", "min(u64/1, u64/2)", "or(nil,0x)", "blake2b"} {
+		f.Add(mustCompile(f, lib, src))
+	}
+	f.Fuzz(func(t *testing.T, code []byte) {
+		if _, err := lib.ExpressionFromBytecode(code); err != nil {
+			return
+		}
+		src, err := lib.DecompileBytecode(code)
+		require.NoError(t, err)
+		canonical, err := lib.CanonicalBytecode(code)
+		require.NoError(t, err)
+		_, _, recompiled, err := lib.CompileExpression(src)
+		require.NoError(t, err, src)
+		require.EqualValues(t, canonical, recompiled, src)
+	})
+}
+
+func TestCorpusRecorder(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "concat($0, $1)")
+	records := make([]*CorpusRecord, 0)
+	lib.SetCorpusRecorder(func(r *CorpusRecord) {
+		records = append(records, r)
+	}, CorpusSampling{Every: 2, MaxRecords: 3})
+
+	for i := 0; i < 10; i++ {
+		_, err := lib.EvalFromBytecode(nil, code, []byte{1, 2}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 3, len(records))
+	require.EqualValues(t, blake2b.Sum256(code), records[0].Hash)
+	require.EqualValues(t, code, records[0].Bytecode)
+	require.EqualValues(t, []int{2, 1}, records[0].ArgSizes)
+
+	lib.SetCorpusRecorder(nil, CorpusSampling{})
+	_, err := lib.EvalFromBytecode(nil, code, nil, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, len(records))
+}
+
+type depthTracer struct {
+	maxDepth int
+}
+
+func (d *depthTracer) Data() interface{} { return nil }
+func (d *depthTracer) Trace() bool       { return true }
+func (d *depthTracer) PutTrace(string)   {}
+func (d *depthTracer) PutTraceEvent(e *TraceEvent) {
+	if e.Depth+1 > d.maxDepth {
+		d.maxDepth = e.Depth + 1
+	}
+}
+
+func TestMaxStaticDepth(t *testing.T) {
+	lib := NewBase()
+	_, err := lib.ExtendErr("depthF1", "concat($0, 1)")
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("depthF2", "depthF1(depthF1($0))")
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("depthF3", "concat(0x01)")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		src   string
+		depth int
+	}{
+		{"1", 1},
+		{"concat", 1},
+		{"concat(1, concat(2))", 3},
+		{"depthF1(5)", 3},
+		{"depthF3", 3},
+		{"depthF2(concat(1))", 7},
+		{"if(1, 2, concat(concat(concat(3))))", 5},
+	} {
+		code := mustCompile(t, lib, tc.src)
+		d, err := lib.MaxStaticDepth(code)
+		require.NoError(t, err)
+		require.EqualValues(t, tc.depth, d, tc.src)
+
+		glb := &depthTracer{}
+		_, err = lib.EvalFromBytecode(glb, code)
+		require.NoError(t, err)
+		require.True(t, glb.maxDepth <= d, tc.src)
+	}
+
+	libBin, err := lib.CompileLocalLibrary("func l0: depthF1($0)\nfunc l1: concat(l0(l0($0)))")
+	require.NoError(t, err)
+	libLoc, err := lib.LocalLibraryFromBytes(libBin)
+	require.NoError(t, err)
+	d, err := lib.MaxStaticDepth(libBin[1], libLoc)
+	require.NoError(t, err)
+	require.EqualValues(t, 8, d)
+}
+
+func TestDecompileCache(t *testing.T) {
+	lib := NewBase()
+	code1 := mustCompile(t, lib, "concat($0, add(1, 2))")
+	code2 := mustCompile(t, lib, "not(1)")
+	code3 := mustCompile(t, lib, "len(0x0102)")
+
+	d, err := lib.DecompileCached(code1)
+	require.NoError(t, err)
+	require.EqualValues(t, "concat($0,add(1,2))", d.Source)
+	require.EqualValues(t, CacheStats{}, lib.DecompileCacheStats())
+
+	lib.SetDecompileCache(2)
+	for i := 0; i < 3; i++ {
+		src, err := lib.DecompileBytecode(code1)
+		require.NoError(t, err)
+		require.EqualValues(t, "concat($0,add(1,2))", src)
+	}
+	d, err = lib.DecompileCached(code1)
+	require.NoError(t, err)
+	sym, prefix, args, err := lib.ParseBytecodeOneLevel(code1)
+	require.NoError(t, err)
+	require.EqualValues(t, sym, d.Sym)
+	require.EqualValues(t, prefix, d.Prefix)
+	require.EqualValues(t, args, d.Args)
+
+	stats := lib.DecompileCacheStats()
+	require.EqualValues(t, 3, stats.Hits)
+	require.EqualValues(t, 1, stats.Misses)
+	require.EqualValues(t, 0.75, stats.HitRate())
+
+	// code1 is evicted as the least recently used
+	_, err = lib.DecompileBytecode(code2)
+	require.NoError(t, err)
+	_, err = lib.DecompileBytecode(code3)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, lib.DecompileCacheStats().Size)
+	_, err = lib.DecompileBytecode(code1)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, lib.DecompileCacheStats().Misses)
+
+	_, err = lib.DecompileBytecode([]byte{0xff})
+	require.Error(t, err)
+	require.EqualValues(t, 2, lib.DecompileCacheStats().Size)
+}
+
+func TestParameterGaps(t *testing.T) {
+	lib := NewBase()
+	_, err := lib.ExtendErr("gapFun", "concat($0, $2)")
+	RequireErrorWith(t, err, "parameter $1 is not used while $2 is")
+	_, err = lib.ExtendErr("gapFun", "concat($$1, $2, $0)")
+	require.NoError(t, err)
+
+	_, err = lib.CompileLocalLibrary("func l0: concat($1)")
+	RequireErrorWith(t, err, "parameter $0 is not used while $1 is")
+	_, err = lib.CompileLocalLibrary("func l0: concat($0)\nfunc l1: l0($1)")
+	RequireErrorWith(t, err, "at line 2")
+
+	// expressions are not checked
+	_, n, _, err := lib.CompileExpression("concat($1)")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, n)
+}
+
+func TestBytecodeArgs(t *testing.T) {
+	lib := NewBase()
+	_, err := lib.ExtendErr("bytecode2", "concat($$0, $$1)")
+	require.NoError(t, err)
+
+	// bytecode of the argument does not include bytecode of the following arguments
+	argCode := mustCompile(t, lib, "concat(1,2)")
+	ret, err := lib.EvalFromSource(nil, "bytecode2(concat(1,2), 5)")
+	require.NoError(t, err)
+	require.EqualValues(t, concat(argCode, []byte{0x81, 5}), ret)
+
+	_, err = lib.ExtendErr("passBytecode", "bytecode($0)")
+	RequireErrorWith(t, err, "'bytecode' takes bytecode of the argument 0 with $$0, it can't be parameter reference '$0'")
+	_, _, _, err = lib.CompileExpression("bytecode2(1, $$1)")
+	RequireErrorWith(t, err, "takes bytecode of the argument 1")
+	_, err = lib.CompileLocalLibrary("func l0: concat($$0)\nfunc l1: l0($0)")
+	RequireErrorWith(t, err, "'l0' takes bytecode")
+
+	// embedded function taking bytecode of its argument
+	err = lib.UpgradeWithEmbedLongErr(&EmbeddedFunctionData{
+		Sym:            "bytecodeLen",
+		RequiredNumPar: 1,
+		EmbeddedFun: func(par *CallParams) []byte {
+			return []byte{byte(len(par.BytecodeArg(0)))}
+		},
+	})
+	require.NoError(t, err)
+	ret, err = lib.EvalFromSource(nil, "concat(bytecodeLen(concat(1,2)), 7)")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{byte(len(argCode)), 7}, ret)
+	ret, err = lib.EvalFromSource(nil, "bytecodeLen(0x010203)")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{4}, ret)
+	_, err = lib.EvalFromSource(nil, "bytecodeLen($0)", []byte{1})
+	RequireErrorWith(t, err, "its bytecode is not known")
+
+	// embedded function called in the body of the extended function, taking bytecode of its first argument
+	err = lib.UpgradeWithEmbedLongErr(&EmbeddedFunctionData{
+		Sym:            "firstArgBytecode",
+		RequiredNumPar: 0,
+		EmbeddedFun: func(par *CallParams) []byte {
+			return par.GetBytecode(0)
+		},
+	})
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("withCode", "concat($0, firstArgBytecode)")
+	require.NoError(t, err)
+	ret, err = lib.EvalFromSource(nil, "withCode(concat(1,2))")
+	require.NoError(t, err)
+	require.EqualValues(t, concat([]byte{1, 2}, argCode), ret)
+	// not checked by the compiler: the argument is parameter reference, its bytecode is not known
+	_, err = lib.ExtendErr("passWithCode", "withCode($0)")
+	require.NoError(t, err)
+	ret, err = lib.EvalFromSource(nil, "passWithCode(concat(1,2))")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 2}, ret)
+
+	// arguments of the evaluation are values
+	_, err = lib.EvalFromSource(nil, "concat($0, $$1)", []byte{1}, []byte{2})
+	RequireErrorWith(t, err, "$$1 can't be used in the expression evaluated with values of arguments")
+	_, err = lib.EvalFromSource(nil, "concat(bytecodeLen(1), $$0)", []byte{1})
+	RequireErrorWith(t, err, "$$0 can't be used")
+}
+
+func TestCheckDeterminism(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "concat($0, blake2b($1))")
+	require.NoError(t, CheckDeterminism(lib, nil, code, [][]byte{{1}, {2}}, 10, false))
+	require.NoError(t, CheckDeterminism(lib, nil, code, [][]byte{{1}, {2}}, 10, true))
+
+	failing := mustCompile(t, lib, "fail(1)")
+	require.NoError(t, CheckDeterminism(lib, nil, failing, nil, 3, false))
+
+	var counter uint32
+	err := lib.UpgradeWithEmbedLongErr(&EmbeddedFunctionData{
+		Sym:            "counter",
+		RequiredNumPar: 0,
+		EmbeddedFun: func(_ *CallParams) []byte {
+			return []byte{byte(atomic.AddUint32(&counter, 1))}
+		},
+	})
+	require.NoError(t, err)
+	code = mustCompile(t, lib, "concat(1, counter)")
+	RequireErrorWith(t, CheckDeterminism(lib, nil, code, nil, 3, false), "evaluation #1 differs from #0")
+	require.Error(t, CheckDeterminism(lib, nil, code, nil, 3, true))
+}
+
+func TestLintLibrary(t *testing.T) {
+	lib := NewBase()
+	findings := LintLibrary(lib, LintAliases(), LintUnusedInternal(), LintBytecodeSize(DefaultMaxLintBytecodeSize))
+	require.EqualValues(t, 1, len(findings))
+	require.EqualValues(t, "alias: 'require': function is an alias of 'or'", findings[0].String())
+
+	_, err := lib.ExtendErr("lintHelper", "concat($0, 0x0102030405060708090a0b0c0d0e0f)")
+	require.NoError(t, err)
+	require.NoError(t, lib.MarkInternal("lintHelper"))
+	_, err = lib.ExtendErr("lintCat", "concat($0, $1)")
+	require.NoError(t, err)
+
+	findings = LintLibrary(lib, LintAliases(), LintUnusedInternal(), LintBytecodeSize(16))
+	syms := make([]string, 0)
+	for _, f := range findings {
+		t.Logf("%s", f)
+		syms = append(syms, f.Rule+":"+f.Sym)
+	}
+	require.EqualValues(t, []string{"alias:require", "unused internal:lintHelper", "bytecode size:lintHelper", "alias:lintCat"}, syms)
+
+	_, err = lib.ExtendErr("lintUser", "lintHelper($0)")
+	require.NoError(t, err)
+	findings = LintLibrary(lib, LintUnusedInternal())
+	require.EqualValues(t, 0, len(findings))
+
+	findings = LintLibrary(lib)
+	require.True(t, len(findings) > 0)
+	for _, f := range findings {
+		require.NotEqualValues(t, "blake2b", f.Sym)
+	}
+
+	// unused extended functions, except entry points
+	lib = NewBase()
+	_, err = lib.ExtendErr("lintHelper", "concat($0, 1)")
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("lintEntry", "lintHelper($0)")
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("lintDead", "concat($0, 2)")
+	require.NoError(t, err)
+	unused := func(entryPoints ...string) []string {
+		ret := make([]string, 0)
+		for _, f := range LintLibrary(lib, LintUnusedExtended(entryPoints...)) {
+			if f.Sym == "lintHelper" || f.Sym == "lintEntry" || f.Sym == "lintDead" || f.Sym == "noSuchFunction" || f.Sym == "" {
+				ret = append(ret, f.Sym)
+			}
+		}
+		return ret
+	}
+	require.EqualValues(t, []string{"lintEntry", "lintDead"}, unused())
+	require.EqualValues(t, []string{"lintDead"}, unused("lintEntry"))
+	// unknown symbols are sorted last
+	require.EqualValues(t, []string{"lintDead", "noSuchFunction", ""}, unused("noSuchFunction", "lintEntry", ""))
+
+	findings = LintLibrary(lib, LintRule{
+		Name: "unknown",
+		Check: func(lib *Library) []LintFinding {
+			return []LintFinding{{Rule: "unknown", Sym: "", Message: "empty"}, {Rule: "unknown", Sym: "concat", Message: "known"}}
+		},
+	})
+	require.EqualValues(t, []string{"concat", ""}, []string{findings[0].Sym, findings[1].Sym})
+}
+
+func TestResultMemo(t *testing.T) {
+	lib := NewBase()
+	var counter int
+	err := lib.UpgradeWithEmbedLongErr(&EmbeddedFunctionData{
+		Sym:            "countedConcat",
+		RequiredNumPar: 2,
+		EmbeddedFun: func(par *CallParams) []byte {
+			counter++
+			return concat(par.Arg(0), par.Arg(1))
+		},
+	})
+	require.NoError(t, err)
+	code := mustCompile(t, lib, "countedConcat($0, $1)")
+
+	// not enabled
+	_, err = lib.EvalFromBytecodeMemoized(nil, nil, code, []byte{1}, []byte{2})
+	require.NoError(t, err)
+	_, err = lib.EvalFromBytecodeMemoized(nil, nil, code, []byte{1}, []byte{2})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, counter)
+
+	lib.SetResultMemo(10)
+	for i := 0; i < 3; i++ {
+		ret, err := lib.EvalFromBytecodeMemoized(nil, []byte("tx1"), code, []byte{1}, []byte{2})
+		require.NoError(t, err)
+		require.EqualValues(t, []byte{1, 2}, ret)
+	}
+	require.EqualValues(t, 3, counter)
+
+	// different split of the same bytes, different context
+	ret, err := lib.EvalFromBytecodeMemoized(nil, []byte("tx1"), code, []byte{1, 2}, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 2}, ret)
+	require.EqualValues(t, 4, counter)
+	_, err = lib.EvalFromBytecodeMemoized(nil, []byte("tx2"), code, []byte{1}, []byte{2})
+	require.NoError(t, err)
+	require.EqualValues(t, 5, counter)
+
+	// errors are memoized too
+	failing := mustCompile(t, lib, "countedConcat($0, fail(1))")
+	for i := 0; i < 2; i++ {
+		_, err = lib.EvalFromBytecodeMemoized(nil, nil, failing, []byte{1})
+		require.Error(t, err)
+	}
+	require.EqualValues(t, 6, counter)
+
+	lib.InvalidateResultMemo()
+	_, err = lib.EvalFromBytecodeMemoized(nil, []byte("tx1"), code, []byte{1}, []byte{2})
+	require.NoError(t, err)
+	require.EqualValues(t, 7, counter)
+	stats := lib.ResultMemoStats()
+	require.EqualValues(t, 3, stats.Hits)
+	require.EqualValues(t, 1, stats.Size)
+
+	// metered evaluations consume gas each time and are not memoized
+	for i := 0; i < 2; i++ {
+		meter := lib.NewGasMeter(1000)
+		_, err = lib.EvalFromBytecodeMemoized(WithGasMeter(nil, meter), []byte("tx1"), code, []byte{1}, []byte{2})
+		require.NoError(t, err)
+		require.NotZero(t, meter.Used())
+	}
+	require.EqualValues(t, 9, counter)
+
+	// errors of limits are not memoized
+	lib.SetMaxEvalRecursion(1)
+	nested := mustCompile(t, lib, "eval(bytecode(eval(bytecode(countedConcat(1, 2)))))")
+	_, err = lib.EvalFromBytecodeMemoized(nil, nil, nested)
+	var errRecursion *ErrEvalRecursion
+	require.True(t, errors.As(err, &errRecursion))
+	lib.SetMaxEvalRecursion(0)
+	ret, err = lib.EvalFromBytecodeMemoized(nil, nil, nested)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 2}, ret)
+
+	// results memoized before the library is extended are not found after
+	counter = 0
+	_, err = lib.EvalFromBytecodeMemoized(nil, []byte("tx3"), code, []byte{1}, []byte{2})
+	require.NoError(t, err)
+	_, err = lib.ExtendErr("someNewFunction", "concat($0, 1)")
+	require.NoError(t, err)
+	_, err = lib.EvalFromBytecodeMemoized(nil, []byte("tx3"), code, []byte{1}, []byte{2})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, counter)
+}
+
+func TestSharedGlobalData(t *testing.T) {
+	// run with -race
+	lib := NewBase()
+	code := mustCompile(t, lib, "concat($0, add(1, 2))")
+	var buf bytes.Buffer
+	shared := []GlobalData{
+		NewGlobalDataNoTrace(nil),
+		NewGlobalDataLog(nil),
+		NewGlobalDataJSONTrace(nil, &buf, 0),
+	}
+	const numEvals = 50
+	for _, glb := range shared {
+		var wg sync.WaitGroup
+		results := make([][]byte, numEvals)
+		errs := make([]error, numEvals)
+		wg.Add(numEvals)
+		for i := 0; i < numEvals; i++ {
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = lib.EvalFromBytecode(glb, code, []byte{byte(i)})
+			}(i)
+		}
+		wg.Wait()
+		for i := 0; i < numEvals; i++ {
+			require.NoError(t, errs[i])
+			require.EqualValues(t, concat([]byte{byte(i)}, []byte{0, 0, 0, 0, 0, 0, 0, 3}), results[i])
+		}
+	}
+	msgs := shared[1].(*GlobalDataLog).Log()
+	require.EqualValues(t, 0, len(msgs)%numEvals)
+	require.True(t, len(msgs) > 0)
+	require.NoError(t, shared[2].(*GlobalDataJSONTrace).Err())
+}
+
+func TestLongEvalArgs(t *testing.T) {
+	lib := NewBase()
+	long := bytes.Repeat([]byte{1, 2, 3}, 100)
+	code := mustCompile(t, lib, "concat($0, len($0))")
+	ret, err := lib.EvalFromBytecode(nil, code, long)
+	require.NoError(t, err)
+	require.EqualValues(t, concat(long, []byte{0, 0, 0, 0, 0, 0, 0x01, 0x2c}), ret)
+	ret, err = lib.EvalBytecodeDirect(nil, code, long)
+	require.NoError(t, err)
+	require.EqualValues(t, concat(long, []byte{0, 0, 0, 0, 0, 0, 0x01, 0x2c}), ret)
+}
+
+type bufLogger struct {
+	bytes.Buffer
+}
+
+func (l *bufLogger) Printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(&l.Buffer, format, args...)
+}
+
+func TestLogger(t *testing.T) {
+	lib := NewBase()
+	require.True(t, lib.Logger() == DefaultLogger)
+
+	logger := &bufLogger{}
+	lib.SetLogger(logger)
+	lib.PrintLibraryStats()
+	require.True(t, strings.HasPrefix(logger.String(), "EasyFL function library"))
+
+	logger.Reset()
+	tr := NewGlobalDataLog(nil)
+	_, err := lib.EvalFromSource(tr, "add(1, 2)")
+	require.NoError(t, err)
+	tr.PrintLogTo(logger)
+	require.True(t, strings.HasPrefix(logger.String(), "--- trace begin ---\n"))
+	require.True(t, strings.HasSuffix(logger.String(), "--- trace end ---\n"))
+
+	logger.Reset()
+	_, err = lib.EvalFromSource(NewGlobalDataTracePrintTo(nil, logger), "add(1, 2)")
+	require.NoError(t, err)
+	require.EqualValues(t, len(tr.Log()), strings.Count(logger.String(), "\n"))
+
+	lib.SetLogger(nil)
+	require.True(t, lib.Logger() == DefaultLogger)
+}
+
+func TestExpressionMetrics(t *testing.T) {
+	lib := NewBase()
+	expr, _, _, err := lib.CompileExpression("concat($0, add(1, 0x0203), add($1, nil), 0x)")
+	require.NoError(t, err)
+	m := ExpressionMetrics(expr)
+	require.EqualValues(t, 9, m.NumNodes)
+	require.EqualValues(t, 3, m.MaxDepth)
+	require.EqualValues(t, 3, m.NumDataBytes)
+	require.EqualValues(t, map[string]int{"concat": 1, "add": 2}, m.NumCallsBySym)
+
+	expr, _, _, err = lib.CompileExpression("$0")
+	require.NoError(t, err)
+	m = ExpressionMetrics(expr)
+	require.EqualValues(t, 1, m.NumNodes)
+	require.EqualValues(t, 1, m.MaxDepth)
+	require.EqualValues(t, 0, len(m.NumCallsBySym))
+
+	// the same metrics after decompiling
+	code := mustCompile(t, lib, "if(equal($0, 1), blake2b($1), nil)")
+	expr, err = lib.ExpressionFromBytecode(code)
+	require.NoError(t, err)
+	m = ExpressionMetrics(expr)
+	require.EqualValues(t, 7, m.NumNodes)
+	require.EqualValues(t, 3, m.MaxDepth)
+	require.EqualValues(t, 1, m.NumDataBytes)
+	require.EqualValues(t, map[string]int{"if": 1, "equal": 1, "blake2b": 1}, m.NumCallsBySym)
+}
+
+func TestPinVarargArity(t *testing.T) {
+	t.Run("pinned", func(t *testing.T) {
+		lib := NewBase()
+		h := lib.LibraryHash()
+		codeVararg := mustCompile(t, lib, "blake2b(1, 2)")
+		code := mustCompile(t, lib, "blake2b($0)")
+
+		err := lib.PinVarargArity("blake2b", 1)
+		require.NoError(t, err)
+		require.NotEqualValues(t, h, lib.LibraryHash())
+		require.NoError(t, lib.VerifyInternalConsistency())
+
+		ret, err := lib.EvalFromBytecode(nil, code, []byte{1})
+		require.NoError(t, err)
+		exp := blake2b.Sum256([]byte{1})
+		require.EqualValues(t, exp[:], ret)
+
+		_, _, _, err = lib.CompileExpression("blake2b(1, 2)")
+		require.Error(t, err)
+		_, err = lib.ExpressionFromBytecode(codeVararg)
+		require.Error(t, err)
+
+		err = lib.PinVarargArity("blake2b", 2)
+		require.Error(t, err)
+	})
+	t.Run("used by library", func(t *testing.T) {
+		lib := NewBase()
+		lib.MustExtendMany("func hash2 : blake2b($0, $1)")
+		err := lib.PinVarargArity("blake2b", 1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "'hash2' calls it")
+
+		// base library calls blake2b with 1 argument
+		err = lib.PinVarargArity("blake2b", 2)
+		require.Error(t, err)
+	})
+	t.Run("not vararg", func(t *testing.T) {
+		lib := NewBase()
+		require.Error(t, lib.PinVarargArity("add", 2))
+		require.Error(t, lib.PinVarargArity("max", 2))
+		require.Error(t, lib.PinVarargArity("blake2b", 16))
+		require.Error(t, lib.PinVarargArity("notExisting", 1))
+	})
+}
+
+func TestMigrateBytecode(t *testing.T) {
+	lib := NewBase()
+	fi, err := lib.functionByName("equal")
+	require.NoError(t, err)
+	u16 := uint16(FirstByteLongCallMask|(2<<2))<<8 | fi.FunCode
+	codeShort := mustCompile(t, lib, "equal(1, 2)")
+	codeLong := concat([]byte{byte(u16 >> 8), byte(u16)}, codeShort[1:])
+
+	migrated, err := lib.MigrateBytecode(codeLong, CanonicalizationNone, CanonicalizationLatest)
+	require.NoError(t, err)
+	require.EqualValues(t, codeShort, migrated)
+
+	migrated, err = lib.MigrateBytecode(codeLong, CanonicalizationNone, CanonicalizationNone)
+	require.NoError(t, err)
+	require.EqualValues(t, codeLong, migrated)
+
+	_, err = lib.MigrateBytecode(codeShort, CanonicalizationV1, CanonicalizationNone)
+	require.Error(t, err)
+	_, err = lib.MigrateBytecode(codeShort, CanonicalizationNone, CanonicalizationLatest+1)
+	require.Error(t, err)
+	_, err = lib.MigrateBytecode([]byte{0xff}, CanonicalizationV1, CanonicalizationV1)
+	require.Error(t, err)
+
+	results := lib.MigrateBytecodes([][]byte{codeLong, codeShort, {0xff}}, CanonicalizationNone, CanonicalizationV1)
+	require.EqualValues(t, 3, len(results))
+	require.NoError(t, results[0].Err)
+	require.True(t, results[0].Changed)
+	require.EqualValues(t, blake2b.Sum256(codeLong), results[0].OldID)
+	id, err := lib.BytecodeID(codeLong)
+	require.NoError(t, err)
+	require.EqualValues(t, id, results[0].NewID)
+	require.NoError(t, results[1].Err)
+	require.False(t, results[1].Changed)
+	require.Error(t, results[2].Err)
+
+	ids := MigratedIDs(results)
+	require.EqualValues(t, 1, len(ids))
+	require.EqualValues(t, id, ids[blake2b.Sum256(codeLong)])
+}
+
+func TestScriptFail(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.RegisterErrorCode(1001, "signature is not valid"))
+	soft := []string{
+		"fail(100)",
+		"fail(!!!ciao)",
+		"requireErr(nil, u16/1001)",
+		"eval(bytecode(fail(1)))",
+		"evalArgumentBytecode(bytecode(lessOrEqualThan(1, fail(1))), #lessOrEqualThan, 1)",
+	}
+	for _, src := range soft {
+		_, err := lib.EvalFromSource(nil, src)
+		require.Error(t, err)
+		require.True(t, IsScriptFail(err), "%s: %v", src, err)
+		var errFail *ErrScriptFail
+		require.True(t, errors.As(err, &errFail))
+		require.True(t, strings.HasPrefix(err.Error(), "SCRIPT FAIL: "))
+	}
+	hard := []string{
+		"requireErr(nil, 1)",
+		"add(nil, 1)",
+		"eval(0xff)",
+	}
+	for _, src := range hard {
+		_, err := lib.EvalFromSource(nil, src)
+		require.Error(t, err)
+		require.False(t, IsScriptFail(err), "%s: %v", src, err)
+	}
+	code := mustCompile(t, lib, "requireErr($0, u16/1001)")
+	_, err := lib.EvalFromBytecode(nil, code, nil)
+	require.True(t, IsScriptFail(err))
+	RequireErrorWith(t, err, "SCRIPT FAIL: error #1001: signature is not valid")
+	_, err = lib.EvalBytecodeDirect(nil, code, nil)
+	require.True(t, IsScriptFail(err))
+}
+
+func TestFailCodes(t *testing.T) {
+	lib := NewBase()
+	require.NoError(t, lib.RegisterFailCode(100, "noSignature", "signature is missing"))
+	require.NoError(t, lib.RegisterFailCode(7, "expired", ""))
+	RequireErrorWith(t, lib.RegisterFailCode(100, "other", ""), "already registered")
+	RequireErrorWith(t, lib.RegisterFailCode(101, "expired", ""), "already registered")
+	require.Error(t, lib.RegisterFailCode(102, "", ""))
+	require.EqualValues(t, []FailCode{{7, "expired", ""}, {100, "noSignature", "signature is missing"}}, lib.FailCodes())
+
+	_, err := lib.EvalFromSource(nil, "fail(100)")
+	RequireErrorWith(t, err, "SCRIPT FAIL: error #100: noSignature: signature is missing")
+	var errFail *ErrScriptFail
+	require.True(t, errors.As(err, &errFail))
+	require.True(t, errFail.HasCode)
+	require.EqualValues(t, 100, errFail.Code)
+
+	tr := NewGlobalDataLog(nil)
+	_, err = lib.EvalFromSource(tr, "fail(7)")
+	RequireErrorWith(t, err, "SCRIPT FAIL: error #7: expired")
+	require.Contains(t, strings.Join(tr.Log(), "\n"), "SCRIPT FAIL: error #7: expired")
+
+	_, err = lib.EvalFromSource(nil, "fail(8)")
+	require.True(t, errors.As(err, &errFail))
+	require.EqualValues(t, 8, errFail.Code)
+
+	_, err = lib.EvalFromSource(nil, "fail(!!!no_code)")
+	require.True(t, errors.As(err, &errFail))
+	require.False(t, errFail.HasCode)
+
+	_, err = lib.EvalFromSource(nil, "requireErr(nil, u16/1000)")
+	require.True(t, errors.As(err, &errFail))
+	require.True(t, errFail.HasCode)
+	require.EqualValues(t, 1000, errFail.Code)
+
+	lib.MustExtendMany(`
+func lintFail1 : if($0, fail(100), fail(101))
+func lintFail2 : requireErr($0, u16/1001)
+`)
+	findings := LintLibrary(lib, LintUnregisteredFailCodes())
+	msgs := make([]string, 0)
+	for _, f := range findings {
+		msgs = append(msgs, f.String())
+	}
+	require.EqualValues(t, []string{
+		"unregistered fail code: 'lintFail1': fail code 101 is not registered",
+		"unregistered fail code: 'lintFail2': requireErr error code 1001 is not registered",
+	}, msgs)
+	require.NoError(t, lib.RegisterErrorCode(1001, "signature is not valid"))
+	require.NoError(t, lib.RegisterFailCode(101, "other", ""))
+	require.EqualValues(t, 0, len(LintLibrary(lib, LintUnregisteredFailCodes())))
+}
+
+func TestLibraryReport(t *testing.T) {
+	lib := NewBase()
+	r := lib.Report()
+	t.Logf("\n%s", r)
+	require.True(t, len(r.Functions) > 0)
+	require.EqualValues(t, r.TotalSize, r.Functions[len(r.Functions)-1].CumulativeSize)
+	require.EqualValues(t, r.TotalSize, r.DataBytes+r.CallBytes+r.ParamBytes)
+	numInHistogram := 0
+	for _, n := range r.Histogram {
+		numInHistogram += n
+	}
+	require.EqualValues(t, len(r.Functions), numInHistogram)
+
+	_, err := lib.ExtendErr("reportLarge", "concat($0, 0x0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20)")
+	require.NoError(t, err)
+	r = lib.Report()
+	require.True(t, len(r.Largest) <= ReportTopN)
+	largest := r.Largest[0]
+	require.EqualValues(t, "reportLarge", largest.Sym)
+	require.EqualValues(t, 33, largest.DataBytes)
+	require.EqualValues(t, 1, largest.ParamBytes)
+	require.EqualValues(t, largest.Size, largest.DataBytes+largest.CallBytes+largest.ParamBytes)
+	for i := 1; i < len(r.Largest); i++ {
+		require.True(t, r.Largest[i-1].Size >= r.Largest[i].Size)
+	}
+}
+
+func TestDecompileBytecodeWithNames(t *testing.T) {
+	lib := NewBase()
+	lib.MustExtendMany("func accessor : slice($0, 0, 7)")
+	code := mustCompile(t, lib, "lessThan(accessor($0), accessor(0x0000000000000001))")
+	fi, err := lib.functionByName("accessor")
+	require.NoError(t, err)
+
+	src, err := lib.DecompileBytecodeWithNames(code, map[uint16]string{fi.FunCode: "amount"})
+	require.NoError(t, err)
+	require.EqualValues(t, "lessThan(amount($0),amount(0x0000000000000001))", src)
+
+	// display names can't be compiled
+	_, _, _, err = lib.CompileExpression(src)
+	require.Error(t, err)
+
+	src, err = lib.DecompileBytecodeWithNames(code, nil)
+	require.NoError(t, err)
+	srcCanonical, err := lib.DecompileBytecode(code)
+	require.NoError(t, err)
+	require.EqualValues(t, srcCanonical, src)
+
+	_, err = lib.DecompileBytecodeWithNames(code, map[uint16]string{fi.FunCode: "concat"})
+	require.Error(t, err)
+	_, err = lib.DecompileBytecodeWithNames(code, map[uint16]string{fi.FunCode: "accessor"})
+	require.NoError(t, err)
+	_, err = lib.DecompileBytecodeWithNames(code, map[uint16]string{FirstLocalFunCode: "x"})
+	require.Error(t, err)
+	_, err = lib.DecompileBytecodeWithNames(code, map[uint16]string{LastGlobalFunCode: "x"})
+	require.Error(t, err)
+}
+
+func TestProfiler(t *testing.T) {
+	lib := NewBase()
+	err := lib.UpgradeWithEmbedLongErr(&EmbeddedFunctionData{
+		Sym:            "slowFun",
+		RequiredNumPar: 1,
+		EmbeddedFun: func(par *CallParams) []byte {
+			time.Sleep(3 * time.Millisecond)
+			return par.Arg(0)
+		},
+	})
+	require.NoError(t, err)
+	lib.MustExtendMany("func slowWrapper : concat(slowFun($0), 1)")
+	code := mustCompile(t, lib, "equal(slowWrapper($0), slowWrapper(2))")
+
+	p := NewProfiler(time.Millisecond)
+	defer p.Stop()
+	glb := WithProfiler(nil, p)
+	for i := 0; i < 10; i++ {
+		ret, err := lib.EvalFromBytecode(glb, code, []byte{2})
+		require.NoError(t, err)
+		require.True(t, len(ret) > 0)
+	}
+	p.Stop()
+	samples := p.Samples()
+	require.True(t, len(samples) > 0)
+	hottest := ""
+	for stack, n := range samples {
+		require.True(t, strings.HasPrefix(stack, "equal"), stack)
+		if n > samples[hottest] {
+			hottest = stack
+		}
+	}
+	require.EqualValues(t, "equal;slowWrapper;concat;slowFun", hottest)
+
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteFolded(&buf))
+	t.Logf("\n%s", buf.String())
+	require.EqualValues(t, len(samples), strings.Count(buf.String(), "\n"))
+
+	p.Reset()
+	require.EqualValues(t, 0, len(p.Samples()))
+
+	// not profiled
+	_, err = lib.EvalFromBytecode(nil, code, []byte{2})
+	require.NoError(t, err)
+	require.EqualValues(t, 0, len(p.Samples()))
+}
+
+func TestMetadata(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "concat($0, add(1, 2))")
+	withMd, err := WithMetadata(code, []byte("template:lock"), []byte{1, 0})
+	require.NoError(t, err)
+	require.True(t, len(withMd) > len(code))
+
+	md, script, err := BytecodeMetadata(withMd)
+	require.NoError(t, err)
+	require.EqualValues(t, [][]byte{[]byte("template:lock"), {1, 0}}, md)
+	require.EqualValues(t, code, script)
+	stripped, err := StripMetadata(withMd)
+	require.NoError(t, err)
+	require.EqualValues(t, code, stripped)
+
+	md, script, err = BytecodeMetadata(code)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, len(md))
+	require.EqualValues(t, code, script)
+
+	// metadata does not change semantics, canonical form and ID
+	exp, err := lib.EvalFromBytecode(nil, code, []byte{5})
+	require.NoError(t, err)
+	ret, err := lib.EvalFromBytecode(nil, withMd, []byte{5})
+	require.NoError(t, err)
+	require.EqualValues(t, exp, ret)
+	ret, err = lib.EvalBytecodeDirect(nil, withMd, []byte{5})
+	require.NoError(t, err)
+	require.EqualValues(t, exp, ret)
+
+	canonical, err := lib.CanonicalBytecode(withMd)
+	require.NoError(t, err)
+	require.EqualValues(t, code, canonical)
+	id1, err := lib.BytecodeID(code)
+	require.NoError(t, err)
+	id2, err := lib.BytecodeID(withMd)
+	require.NoError(t, err)
+	require.EqualValues(t, id1, id2)
+
+	src, err := lib.DecompileBytecode(withMd)
+	require.NoError(t, err)
+	require.EqualValues(t, "concat($0,add(1,2))", src)
+
+	used, err := lib.UsedFunctions(withMd)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, len(used))
+
+	// headers only at the beginning
+	_, err = lib.ExpressionFromBytecode(concat(code[:1], withMd))
+	require.Error(t, err)
+
+	_, err = WithMetadata(code, bytes.Repeat([]byte{1}, 128))
+	require.Error(t, err)
+	_, _, err = BytecodeMetadata(concat(withMd[:2], code))
+	require.Error(t, err)
+}
+
+func TestInlineDataBytecode(t *testing.T) {
+	lib := NewBase()
+	for _, data := range [][]byte{nil, {1}, {1, 2, 3}, bytes.Repeat([]byte{0xff}, MaxInlineDataSize)} {
+		code, err := InlineDataBytecode(data)
+		require.NoError(t, err)
+		require.EqualValues(t, 1+len(data), len(code))
+		ret, err := lib.EvalFromBytecode(nil, code)
+		require.NoError(t, err)
+		require.EqualValues(t, len(data), len(ret))
+		require.True(t, bytes.Equal(data, ret))
+	}
+	code, err := InlineDataBytecode([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.EqualValues(t, mustCompile(t, lib, "0x010203"), code)
+
+	_, err = InlineDataBytecode(make([]byte, MaxInlineDataSize+1))
+	require.Error(t, err)
+}
+
+func TestComposeCall(t *testing.T) {
+	lib := NewBase()
+	bin := mustCompile(t, lib, "if(equal($0, 1), concat(0x0102, $1), nil)")
+	_, prefix, args, err := lib.ParseBytecodeOneLevel(bin, 3)
+	require.NoError(t, err)
+	ret, err := lib.ComposeCall(prefix, args...)
+	require.NoError(t, err)
+	require.EqualValues(t, bin, ret)
+
+	prefixConcat, err := lib.FunctionCallPrefixByName("concat", 2)
+	require.NoError(t, err)
+	ret, err = lib.ComposeCall(prefixConcat, mustCompile(t, lib, "0x01"), mustCompile(t, lib, "len($0)"))
+	require.NoError(t, err)
+	require.EqualValues(t, mustCompile(t, lib, "concat(0x01, len($0))"), ret)
+
+	// wrong arity
+	_, err = lib.ComposeCall(prefix, args[:2]...)
+	require.Error(t, err)
+	_, err = lib.ComposeCall(prefixConcat, args[0])
+	require.Error(t, err)
+	// argument is not an expression or more than one expression
+	_, err = lib.ComposeCall(prefix, args[0], args[1][:1], args[2])
+	require.Error(t, err)
+	_, err = lib.ComposeCall(prefix, args[0], args[1], concat(args[2], args[2]))
+	require.Error(t, err)
+	_, err = lib.ComposeCall(prefix, args[0], args[1], nil)
+	require.Error(t, err)
+	// not a prefix
+	_, err = lib.ComposeCall(bin, args...)
+	require.Error(t, err)
+	_, err = lib.ComposeCall(nil)
+	require.Error(t, err)
+	// inline data
+	ret, err = lib.ComposeCall([]byte{0x81, 0x05})
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0x81, 0x05}, ret)
+	_, err = lib.ComposeCall([]byte{0x81, 0x05}, args[0])
+	require.Error(t, err)
+}
+
+func TestEvalMany(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "if(lessThan($0, 100), concat($0, $1), fail(!!!too_big))")
+	const n = 200
+	argSets := make([][][]byte, n)
+	for i := range argSets {
+		argSets[i] = [][]byte{{byte(i)}, {0xaa}}
+	}
+	for _, numWorkers := range []int{1, 4} {
+		results, errs := lib.EvalMany(nil, code, argSets, numWorkers)
+		require.EqualValues(t, n, len(results))
+		require.EqualValues(t, n, len(errs))
+		for i := range argSets {
+			exp, expErr := lib.EvalFromBytecode(nil, code, argSets[i]...)
+			if i < 100 {
+				require.NoError(t, errs[i])
+				require.EqualValues(t, []byte{byte(i), 0xaa}, results[i])
+			} else {
+				RequireErrorWith(t, errs[i], "too big")
+			}
+			require.EqualValues(t, exp, results[i])
+			require.EqualValues(t, expErr == nil, errs[i] == nil)
+		}
+	}
+	// different number of arguments in sets
+	results, errs := lib.EvalMany(nil, mustCompile(t, lib, "concat($0, $1)"), [][][]byte{{{1}, {2}}, {{1}}, {{3}, {4}}}, 1)
+	require.NoError(t, errs[0])
+	require.EqualValues(t, []byte{1, 2}, results[0])
+	require.Error(t, errs[1])
+	require.NoError(t, errs[2])
+	require.EqualValues(t, []byte{3, 4}, results[2])
+
+	_, errs = lib.EvalMany(nil, []byte{0xff}, argSets[:3], 1)
+	for _, err := range errs {
+		require.Error(t, err)
+	}
+}
+
+func BenchmarkEvalMany(b *testing.B) {
+	lib := NewBase()
+	code := mustCompile(b, lib, "and(equal(add($0, 1), u64/3), lessThan($0, 5), equal(concat($1, 2), 0x0102))")
+	argSets := make([][][]byte, 1000)
+	for i := range argSets {
+		argSets[i] = [][]byte{{2}, {1}}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lib.EvalMany(nil, code, argSets, 1)
+	}
+}
+
+func TestPureDataScript(t *testing.T) {
+	lib := NewBase()
+	for _, src := range []string{"nil", "0x0102030405", "200"} {
+		code := mustCompile(t, lib, src)
+		exp, err := lib.EvalFromSource(nil, src)
+		require.NoError(t, err)
+		ret, err := lib.EvalFromBytecode(nil, code)
+		require.NoError(t, err)
+		require.EqualValues(t, exp, ret)
+		// arguments are ignored, as by the regular path
+		ret, err = lib.EvalFromBytecode(nil, code, []byte{1})
+		require.NoError(t, err)
+		require.EqualValues(t, exp, ret)
+
+		// traced evaluation takes the regular path
+		tr := NewGlobalDataLog(nil)
+		ret, err = lib.EvalFromBytecode(tr, code)
+		require.NoError(t, err)
+		require.EqualValues(t, exp, ret)
+		require.EqualValues(t, 1, len(tr.Log()))
+	}
+	code := mustCompile(t, lib, "0x0102030405")
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = lib.MustEvalFromBytecode(nil, code)
+	})
+	require.EqualValues(t, 0, allocs)
+
+	// not fully consumed and truncated bytecode
+	_, err := lib.EvalFromBytecode(nil, concat(code, code))
+	require.Error(t, err)
+	_, err = lib.EvalFromBytecode(nil, code[:3])
+	require.Error(t, err)
+}
+
+func TestValidateExtensions(t *testing.T) {
+	lib := NewBase()
+	h := lib.LibraryHash()
+	long := strings.Repeat("ab", 128)
+	src := fmt.Sprintf(`
+func vOk : concat($0, 1)
+func vLongLiteral : concat($0, 0x%s)
+func vUnknown : unknownFun($0)
+func vUsesOk : vOk(vOk($0))
+func vBig : concat(0x%s, 0x%s, $0)
+func vUsesLong : vLongLiteral($0)
+`, long, strings.Repeat("cd", 60), strings.Repeat("ef", 60))
+
+	err := lib.ValidateExtensions(src, 100)
+	require.Error(t, err)
+	var problems ExtensionProblems
+	require.True(t, errors.As(err, &problems))
+	t.Logf("%v", err)
+	syms := make([]string, 0)
+	for _, p := range problems {
+		syms = append(syms, p.Sym)
+	}
+	require.EqualValues(t, []string{"vLongLiteral", "vUnknown", "vBig", "vUsesLong"}, syms)
+	require.EqualValues(t, 3, problems[0].Line)
+	require.Contains(t, problems[0].Err.Error(), "can't be longer than 127 bytes")
+	require.Contains(t, problems[2].Err.Error(), "more than 100")
+
+	// the library is not modified
+	require.EqualValues(t, h, lib.LibraryHash())
+	_, err = lib.functionByName("vOk")
+	require.Error(t, err)
+
+	require.NoError(t, lib.ValidateExtensions("func vOk : concat($0, 1)\nfunc vUsesOk : vOk(vOk($0))", 0))
+	require.Error(t, lib.ValidateExtensions("func vOk : concat($0, 1)\nfunc vOk : concat($0, 2)", 0))
+	require.Error(t, lib.ValidateExtensions("func min : concat($0, 1)", 0))
+}
+
+func TestGenerateGoBindings(t *testing.T) {
+	lib := NewBase()
+	src, err := GenerateGoBindings(lib, "bindings")
+	require.NoError(t, err)
+	t.Logf("\n%s", src[:400])
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "bindings.go", src, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, "bindings", f.Name.Name)
+
+	s := string(src)
+	fi, err := lib.functionByName("validSignatureED25519")
+	require.NoError(t, err)
+	require.Contains(t, s, fmt.Sprintf("FuncCodeValidSignatureED25519 = %d", fi.FunCode))
+	h := lib.LibraryHash()
+	require.Contains(t, s, fmt.Sprintf("const LibraryHash = \"%x\"", h[:]))
+	require.Contains(t, s, "func LessOrEqualThan(lib *easyfl.Library, glb easyfl.GlobalData, arg0, arg1 []byte) ([]byte, error)")
+	require.Contains(t, s, "func True(lib *easyfl.Library, glb easyfl.GlobalData) ([]byte, error)")
+
+	// wrapper bytecode evaluates the call
+	code, err := lib.FunctionCallPrefixByName("max", 2)
+	require.NoError(t, err)
+	code = append(code, 0, 1)
+	ret, err := lib.EvalFromBytecode(nil, code, []byte{1}, []byte{5})
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{5}, ret)
+
+	require.EqualValues(t, "BitwiseAND", goIdentifier("bitwiseAND"))
+	require.EqualValues(t, "Blake2bTest", goIdentifier("blake2b-test"))
+	require.EqualValues(t, "", goIdentifier("1abc"))
+
+	lib.MustExtendMany("func lessOr-EqualThan : lessOrEqualThan($0, $1)")
+	_, err = GenerateGoBindings(lib, "bindings")
+	RequireErrorWith(t, err, "the same Go identifier")
+}
+
+func TestPortability(t *testing.T) {
+	lib := NewBase()
+	p, err := lib.Portability("concat")
+	require.NoError(t, err)
+	require.EqualValues(t, PortabilityCore, p)
+	p, err = lib.Portability("blake2b")
+	require.NoError(t, err)
+	require.EqualValues(t, PortabilityCrypto, p)
+	// extended functions are classified by the functions they call
+	p, err = lib.Portability("max")
+	require.NoError(t, err)
+	require.EqualValues(t, PortabilityCore, p)
+	p, err = lib.Portability("commitment")
+	require.NoError(t, err)
+	require.EqualValues(t, PortabilityCrypto, p)
+	// whole base library is portable
+	require.EqualValues(t, len(lib.funByName), len(lib.PortableSubset()))
+	require.EqualValues(t, lib.LibraryHash(), lib.PortableOnly().LibraryHash())
+
+	err = lib.UpgradeWithEmbedLongErr(&EmbeddedFunctionData{"hostNow", 0, func(par *CallParams) []byte { return []byte{1} }})
+	require.NoError(t, err)
+	lib.MustExtendMany(`
+func nowOrNil : or(hostNow, nil)
+func portableExt : concat($0, 1)
+`)
+	p, err = lib.Portability("hostNow")
+	require.NoError(t, err)
+	require.EqualValues(t, PortabilityGoOnly, p)
+	p, err = lib.Portability("nowOrNil")
+	require.NoError(t, err)
+	require.EqualValues(t, PortabilityGoOnly, p)
+	_, err = lib.Portability("unknownFunction")
+	require.Error(t, err)
+
+	subset := lib.PortableSubset()
+	require.EqualValues(t, len(lib.funByName)-2, len(subset))
+	for _, fi := range subset {
+		require.True(t, fi.Sym != "hostNow" && fi.Sym != "nowOrNil")
+	}
+
+	// portable build keeps function codes, its hash doesn't depend on not portable functions
+	portable := lib.PortableOnly()
+	require.NoError(t, portable.VerifyInternalConsistency())
+	require.EqualValues(t, len(subset), len(portable.funByName))
+	require.NotEqualValues(t, lib.LibraryHash(), portable.LibraryHash())
+	code := mustCompile(t, lib, "portableExt(2)")
+	ret, err := portable.EvalFromBytecode(nil, code)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{2, 1}, ret)
+	_, _, _, err = portable.CompileExpression("hostNow")
+	require.Error(t, err)
+
+	// tagging the host function makes dependent functions portable
+	require.NoError(t, lib.SetPortability(PortabilityCore, "hostNow"))
+	require.EqualValues(t, len(lib.funByName), len(lib.PortableSubset()))
+	RequireErrorWith(t, lib.SetPortability(PortabilityCore, "max"), "only be set for embedded")
+	RequireErrorWith(t, lib.SetPortability(Portability(5), "hostNow"), "wrong portability class")
+}
+
+func TestArithmeticProfile(t *testing.T) {
+	def := NewBase()
+	require.EqualValues(t, ArithmeticDefault, def.ArithmeticProfile())
+	require.NoError(t, def.CheckConformance(DefaultArithmeticVectors))
+	require.Error(t, def.CheckConformance(StrictArithmeticVectors))
+	require.Error(t, def.CheckConformance(WrappingArithmeticVectors))
+	require.EqualValues(t, def.LibraryHash(), NewBase(WithArithmeticProfile(ArithmeticDefault)).LibraryHash())
+
+	strict := NewBase(WithArithmeticProfile(ArithmeticStrict))
+	require.EqualValues(t, ArithmeticStrict, strict.ArithmeticProfile())
+	require.NoError(t, strict.CheckConformance(StrictArithmeticVectors))
+	require.Error(t, strict.CheckConformance(WrappingArithmeticVectors))
+	require.Error(t, strict.CheckConformance(DefaultArithmeticVectors))
+	require.NoError(t, strict.VerifyInternalConsistency())
+
+	wrapping := NewBase(WithArithmeticProfile(ArithmeticWrapping))
+	require.EqualValues(t, ArithmeticWrapping, wrapping.ArithmeticProfile())
+	require.NoError(t, wrapping.CheckConformance(WrappingArithmeticVectors))
+	require.Error(t, wrapping.CheckConformance(StrictArithmeticVectors))
+	require.NoError(t, wrapping.VerifyInternalConsistency())
+
+	// same functions, but the profile other than default is part of the hash
+	require.EqualValues(t, len(strict.funByName), len(wrapping.funByName))
+	require.NotEqualValues(t, def.LibraryHash(), strict.LibraryHash())
+	require.NotEqualValues(t, def.LibraryHash(), wrapping.LibraryHash())
+	require.NotEqualValues(t, strict.LibraryHash(), wrapping.LibraryHash())
+
+	require.Panics(t, func() {
+		WithArithmeticProfile(ArithmeticProfile(3))
+	})
+}
+
+func TestMaxFailedBranches(t *testing.T) {
+	lib := NewBase()
+	// no limit by default
+	lib.MustTrue("or(nil, nil, nil, nil, 1)")
+
+	lib.SetMaxFailedBranches(3)
+	lib.MustTrue("or(nil, nil, nil, 1)")
+	lib.MustEqual("firstCaseIndex(nil, nil, nil, 1)", "3")
+	lib.MustEqual("cond(nil, 1, nil, 2, nil, 3, 4)", "4")
+
+	var errBudget *ErrBranchBudget
+	for _, src := range []string{
+		"or(nil, nil, nil, nil, 1)",
+		"firstCaseIndex(nil, nil, nil, nil, 1)",
+		"cond(nil, 1, nil, 2, or(nil, nil), 3, 4)",
+		// failed branches are counted across the whole evaluation
+		"concat(or(nil, nil, 1), or(nil, nil, 1))",
+		"or(nil, firstCaseIndex(nil, nil, nil))",
+	} {
+		_, err := lib.EvalFromSource(nil, src)
+		require.True(t, errors.As(err, &errBudget), "%s: %v", src, err)
+		require.EqualValues(t, 3, errBudget.Limit)
+	}
+	// the counter is per evaluation
+	lib.MustTrue("or(nil, nil, 1)")
+	lib.MustTrue("or(nil, nil, 1)")
+
+	// exhausted budget is propagated from the dynamic evaluation as is
+	code := mustCompile(t, lib, "or(nil, nil, nil, nil, 1)")
+	_, err := lib.EvalFromSource(nil, fmt.Sprintf("eval(0x%s)", hex.EncodeToString(code)))
+	require.True(t, errors.As(err, &errBudget))
+	RequireErrorWith(t, err, "more than 3 failed branches evaluated")
+
+	lib.SetMaxFailedBranches(0)
+	lib.MustTrue("or(nil, nil, nil, nil, 1)")
+}
+
+func TestApplyN(t *testing.T) {
+	lib := NewBase()
+	// '$0' in the argument of 'bytecode' is the parameter of the bytecode, but also counts as the parameter of the source
+	eval := func(src string) ([]byte, error) {
+		return lib.EvalFromSource(nil, src, nil)
+	}
+	h := blake2b.Sum256([]byte{1})
+	for i := 0; i < 9; i++ {
+		h = blake2b.Sum256(h[:])
+	}
+	res, err := eval("applyN(bytecode(blake2b($0)), 10, 1)")
+	require.NoError(t, err)
+	require.EqualValues(t, h[:], res)
+	// PoW-style check with the parameter of the script
+	code := mustCompile(t, lib, "equal(applyN(bytecode(blake2b($0)), 10, $0), $1)")
+	ok, err := lib.EvalBool(nil, code, []byte{1}, h[:])
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	lib5 := NewBase(WithMaxApplyN(5))
+	require.EqualValues(t, 5, lib5.MaxApplyN())
+	require.NotEqualValues(t, lib.LibraryHash(), lib5.LibraryHash())
+	require.EqualValues(t, lib.LibraryHash(), NewBase(WithMaxApplyN(DefaultMaxApplyN)).LibraryHash())
+	res, err = lib5.EvalFromSource(nil, "applyN(bytecode(add($0, 1)), 5, 0)", nil)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0, 0, 0, 0, 0, 0, 0, 5}, res)
+	_, err = lib5.EvalFromSource(nil, "applyN(bytecode(add($0, 1)), 6, 0)", nil)
+	RequireErrorWith(t, err, "count 6 exceeds limit 5")
+	_, err = eval("applyN(bytecode(add($0, 1)), 6, 0)")
+	require.NoError(t, err)
+
+	// the limit is the budget of the whole evaluation, shared by consecutive and nested calls
+	var errBudget *ErrApplyNBudget
+	_, err = lib5.EvalFromSource(nil, "concat(applyN(bytecode(add($0, 1)), 3, 0), applyN(bytecode(add($0, 1)), 3, 0))", nil)
+	require.True(t, errors.As(err, &errBudget))
+	require.EqualValues(t, 5, errBudget.Limit)
+	// iterations of outer and inner calls are counted: 2 + 2*1
+	res, err = lib5.EvalFromSource(nil, "applyN(bytecode(applyN(bytecode(add($0, 1)), 1, $0)), 2, 0)", nil)
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0, 0, 0, 0, 0, 0, 0, 2}, res)
+	// 2 + 2*2
+	_, err = lib5.EvalFromSource(nil, "applyN(bytecode(applyN(bytecode(add($0, 1)), 2, $0)), 2, 0)", nil)
+	require.True(t, errors.As(err, &errBudget))
+	// nested calls with maximal counts stop after DefaultMaxApplyN iterations, instead of 1024^3
+	start := time.Now()
+	_, err = eval("applyN(bytecode(applyN(bytecode(applyN(bytecode(add($0, 1)), u16/1024, $0)), u16/1024, $0)), u16/1024, 0)")
+	require.True(t, errors.As(err, &errBudget))
+	require.EqualValues(t, DefaultMaxApplyN, errBudget.Limit)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	// bytecode must have at most one parameter
+	_, err = lib.EvalFromSource(nil, "applyN(bytecode(concat($0, $1)), 1, 0)", nil, nil)
+	RequireErrorWith(t, err, "applyN")
+	// nested applyN is nested dynamic evaluation
+	lib.SetMaxEvalRecursion(1)
+	_, err = eval("applyN(bytecode(applyN(0x00, 1, $0)), 1, 0)")
+	var errRecursion *ErrEvalRecursion
+	require.True(t, errors.As(err, &errRecursion))
+}
+
+func TestWithoutLocalLibraries(t *testing.T) {
+	lib := NewBase()
+	require.True(t, lib.LocalLibrariesEnabled())
+	libBin, err := lib.CompileLocalLibrary(`
+func fun1 : concat($0, 1)
+func fun2 : fun1(fun1($0))
+`)
+	require.NoError(t, err)
+
+	noLocal := NewBase(WithoutLocalLibraries())
+	require.False(t, noLocal.LocalLibrariesEnabled())
+	require.NoError(t, noLocal.VerifyInternalConsistency())
+	require.NotEqualValues(t, lib.LibraryHash(), noLocal.LibraryHash())
+	// global bytecode is the same
+	require.EqualValues(t, mustCompile(t, lib, "max(1, 2)"), mustCompile(t, noLocal, "max(1, 2)"))
+
+	_, err = noLocal.CompileLocalLibrary("func fun1 : concat($0, 1)")
+	require.True(t, errors.Is(err, ErrLocalLibrariesDisabled))
+	_, err = noLocal.LocalLibraryFromBytes(libBin[:1])
+	require.True(t, errors.Is(err, ErrLocalLibrariesDisabled))
+	_, err = noLocal.EvalFromLibrary(nil, libBin, 1, []byte{0})
+	require.True(t, errors.Is(err, ErrLocalLibrariesDisabled))
+	res, err := lib.EvalFromLibrary(nil, libBin, 1, []byte{0})
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0, 1, 1}, res)
+
+	// bytecode with local calls is rejected at admission
+	_, err = noLocal.ExpressionFromBytecode(libBin[1])
+	require.True(t, errors.Is(err, ErrLocalLibrariesDisabled))
+	_, err = noLocal.UsedFunctions(libBin[1])
+	require.True(t, errors.Is(err, ErrLocalLibrariesDisabled))
+	require.True(t, errors.Is(noLocal.CheckExternalBytecode(libBin[1]), ErrLocalLibrariesDisabled))
+	used, err := lib.UsedFunctions(libBin[1])
+	require.NoError(t, err)
+	require.True(t, used[0].IsLocal)
+}
+
+func TestParseBytecodeOneLevelPartial(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "concat(1, slice(0x0102, 0, 1), 2)")
+	sym, prefix, args, err := lib.ParseBytecodeOneLevel(code)
+	require.NoError(t, err)
+
+	p, err := lib.ParseBytecodeOneLevelPartial(code)
+	require.NoError(t, err)
+	require.EqualValues(t, -1, p.ErrOffset)
+	require.EqualValues(t, sym, p.Sym)
+	require.EqualValues(t, prefix, p.Prefix)
+	require.EqualValues(t, 3, p.Arity)
+	require.EqualValues(t, args, p.Args)
+
+	// truncated in the second argument
+	_, _, _, err = lib.ParseBytecodeOneLevel(code[:len(prefix)+len(args[0])+3])
+	require.Error(t, err)
+	p, err = lib.ParseBytecodeOneLevelPartial(code[:len(prefix)+len(args[0])+3])
+	require.True(t, errors.Is(err, io.EOF))
+	RequireErrorWith(t, err, "argument 1 of 'concat'")
+	require.EqualValues(t, "concat", p.Sym)
+	require.EqualValues(t, prefix, p.Prefix)
+	require.EqualValues(t, args[:1], p.Args)
+	// the offset of the truncated inline data in 'slice'
+	require.EqualValues(t, len(prefix)+len(args[0])+1, p.ErrOffset)
+
+	// trailing bytes
+	p, err = lib.ParseBytecodeOneLevelPartial(append(append([]byte{}, code...), 0x81, 0x01))
+	RequireErrorWith(t, err, "not all bytes have been consumed")
+	require.EqualValues(t, args, p.Args)
+	require.EqualValues(t, len(code), p.ErrOffset)
+
+	// wrong call prefix
+	p, err = lib.ParseBytecodeOneLevelPartial([]byte{0x7f, 0xff})
+	require.Error(t, err)
+	require.EqualValues(t, 0, p.ErrOffset)
+	require.EqualValues(t, 0, len(p.Prefix))
+
+	// data
+	p, err = lib.ParseBytecodeOneLevelPartial([]byte{0x82, 1, 2})
+	require.NoError(t, err)
+	require.EqualValues(t, "0x0102", p.Sym)
+	require.EqualValues(t, 0, len(p.Args))
+
+	// offsets include the metadata header
+	withMeta, err := WithMetadata(code[:len(code)-1], []byte("meta"))
+	require.NoError(t, err)
+	p, err = lib.ParseBytecodeOneLevelPartial(withMeta)
+	require.True(t, errors.Is(err, io.EOF))
+	// the last argument '2' is inline data with the length but no data
+	require.EqualValues(t, len(withMeta)-1, p.ErrOffset)
+	require.EqualValues(t, args[:2], p.Args)
+}
+
+func TestEvalWithOptions(t *testing.T) {
+	lib := NewBase()
+	hash := mustCompile(t, lib, "blake2b($0)")
+	check := mustCompile(t, lib, "equal($0, 1)")
+
+	res, err := lib.EvalWithOptions(nil, EvalOptions{}, check, []byte{2})
+	require.NoError(t, err)
+	require.EqualValues(t, 0, len(res))
+
+	res, err = lib.EvalWithOptions(nil, ExactLen(32), hash, []byte{1})
+	require.NoError(t, err)
+	require.EqualValues(t, 32, len(res))
+	_, err = lib.EvalWithOptions(nil, ExactLen(8), hash, []byte{1})
+	var errShape *ErrResultShape
+	require.True(t, errors.As(err, &errShape))
+	require.EqualValues(t, 32, len(errShape.Result))
+	RequireErrorWith(t, err, "is expected to be 8 bytes long")
+
+	res, err = lib.EvalWithOptions(nil, TruthyOnly(), check, []byte{1})
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{0xff}, res)
+	_, err = lib.EvalWithOptions(nil, TruthyOnly(), check, []byte{2})
+	require.True(t, errors.As(err, &errShape))
+	RequireErrorWith(t, err, "is expected to be true")
+
+	// both constraints
+	opts := EvalOptions{CheckLen: true, Len: 1, TruthyOnly: true}
+	_, err = lib.EvalWithOptions(nil, opts, check, []byte{1})
+	require.NoError(t, err)
+	_, err = lib.EvalWithOptions(nil, opts, check, []byte{2})
+	RequireErrorWith(t, err, "is expected to be true")
+	// exact length 0 is a constraint too
+	_, err = lib.EvalWithOptions(nil, ExactLen(0), check, []byte{1})
+	RequireErrorWith(t, err, "is expected to be 0 bytes long")
+
+	// evaluation errors are returned as is
+	_, err = lib.EvalWithOptions(nil, TruthyOnly(), mustCompile(t, lib, "fail(1)"))
+	require.True(t, IsScriptFail(err))
+
+	_, err = lib.EvalBytesN(nil, 8, hash, []byte{1})
+	require.True(t, errors.As(err, &errShape))
+}
+
+type correlatedMetered struct {
+	GlobalData
+	id    string
+	meter *GasMeter
+}
+
+func (g *correlatedMetered) CorrelationID() string { return g.id }
+func (g *correlatedMetered) GasMeter() *GasMeter   { return g.meter }
+
+func TestCorrelationID(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "concat(slice($0, 0, 0), 2)")
+
+	log := NewGlobalDataLog(nil)
+	res, err := lib.EvalFromBytecode(WithCorrelationID(log, "tx:0102"), code, []byte{1, 5})
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{1, 2}, res)
+	require.True(t, len(log.Log()) > 0)
+	for _, s := range log.Log() {
+		require.True(t, strings.HasPrefix(s, "[tx:0102] "), s)
+	}
+
+	// panic messages carry the ID, with and without tracing
+	for _, glb := range []GlobalData{WithCorrelationID(NewGlobalDataLog(nil), "tx:0304"), WithCorrelationID(nil, "tx:0304")} {
+		_, err = lib.EvalFromBytecode(glb, code, nil)
+		RequireErrorWith(t, err, "[tx:0304] panic: ")
+	}
+	_, err = lib.EvalFromBytecode(nil, code, nil)
+	require.Error(t, err)
+	require.False(t, strings.Contains(err.Error(), "[tx:"))
+
+	// script failures and exhausted budgets carry the ID
+	failLog := NewGlobalDataLog(nil)
+	_, err = lib.EvalFromSource(WithCorrelationID(failLog, "tx:05"), "fail(1)")
+	var errFail *ErrScriptFail
+	require.True(t, errors.As(err, &errFail))
+	require.EqualValues(t, "tx:05", errFail.CorrelationID)
+	RequireErrorWith(t, err, "[tx:05] SCRIPT FAIL: ")
+	require.Contains(t, failLog.Log(), "[tx:05] SCRIPT FAIL: error #1")
+	_, err = lib.EvalFromSource(nil, "fail(1)")
+	require.True(t, errors.As(err, &errFail))
+	require.EqualValues(t, "", errFail.CorrelationID)
+
+	_, err = lib.EvalFromSource(WithCorrelationID(nil, "tx:06"), "requireErr(nil, u16/1)")
+	require.True(t, errors.As(err, &errFail))
+	require.EqualValues(t, "tx:06", errFail.CorrelationID)
+
+	_, err = lib.EvalFromSource(&correlatedMetered{
+		GlobalData: NewGlobalDataNoTrace(nil),
+		id:         "tx:07",
+		meter:      lib.NewGasMeter(1),
+	}, "concat(1)")
+	var errGas *ErrOutOfGas
+	require.True(t, errors.As(err, &errGas))
+	RequireErrorWith(t, err, "[tx:07] out of gas")
+
+	libBudget := NewBase()
+	libBudget.SetMaxFailedBranches(1)
+	_, err = libBudget.EvalFromSource(WithCorrelationID(nil, "tx:08"), "or(nil, nil, 1)")
+	var errBudget *ErrBranchBudget
+	require.True(t, errors.As(err, &errBudget))
+	RequireErrorWith(t, err, "[tx:08] more than 1 failed branches")
+
+	// concurrent evaluations sharing the log are attributed
+	shared := NewGlobalDataLog(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = lib.EvalFromBytecode(WithCorrelationID(shared, fmt.Sprintf("v%d", i)), code, []byte{byte(i)})
+		}(i)
+	}
+	wg.Wait()
+	counts := make(map[string]int)
+	for _, s := range shared.Log() {
+		counts[s[:4]]++
+	}
+	require.EqualValues(t, 4, len(counts))
+	require.EqualValues(t, counts["[v0]"], counts["[v3]"])
+}
+
+func TestSpec(t *testing.T) {
+	// published document is up to date. Regenerate with 'go generate'
+	published, err := os.ReadFile("spec.json")
+	require.NoError(t, err)
+	data, err := SpecJSON()
+	require.NoError(t, err)
+	require.EqualValues(t, string(data), string(published))
+
+	// the bytecode is decoded with the spec only
+	spec := Spec()
+	lib := NewBase()
+	fi, err := lib.functionByName("chainHash3")
+	require.NoError(t, err)
+	code := mustCompile(t, lib, "chainHash3(nil, 1, 0x0203, $3)")
+	require.True(t, code[0]&spec.DataPrefixMask == 0 && code[0]&spec.LongCallMask != 0)
+	require.EqualValues(t, 4, int((code[0]&spec.LongCallArityMask)>>spec.LongCallArityShift))
+	require.EqualValues(t, fi.FunCode, binary.BigEndian.Uint16(code)&spec.LongCallCodeMask)
+	require.True(t, spec.Extended.First <= fi.FunCode && fi.FunCode <= spec.Extended.Last)
+	require.EqualValues(t, spec.DataPrefixMask, code[2])
+	require.EqualValues(t, 2, int(code[5]&spec.DataLenMask))
+	require.True(t, code[len(code)-1] >= byte(spec.Parameters.First) && code[len(code)-1] <= byte(spec.Parameters.Last))
+	require.EqualValues(t, 3, code[len(code)-1])
+}
+
+func TestGasMetering(t *testing.T) {
+	lib := NewBase()
+	gasOf := func(src string, args ...[]byte) uint64 {
+		m := lib.NewGasMeter(math.MaxUint64)
+		_, err := lib.EvalFromBytecode(WithGasMeter(nil, m), mustCompile(t, lib, src), args...)
+		require.NoError(t, err)
+		return m.Used()
+	}
+	require.EqualValues(t, 1, gasOf("1"))
+	require.EqualValues(t, 3, gasOf("add(1, 2)"))
+	require.EqualValues(t, 2, gasOf("len($0)", []byte{1}))
+	cost, err := lib.GasCost("blake2b")
+	require.NoError(t, err)
+	require.EqualValues(t, 20, cost)
+	require.EqualValues(t, 2+cost+GasPerHashedBlock, gasOf("blake2b(1)"))
+	// lazy arguments which are not evaluated are not charged
+	require.EqualValues(t, gasOf("or(1)"), gasOf("or(1, blake2b(1))"))
+	// arguments of extended functions are charged when evaluated
+	h1, h2 := blake2b.Sum256([]byte{1}), blake2b.Sum256([]byte{2})
+	maxOfData := fmt.Sprintf("max(0x%s, 0x%s)", hex.EncodeToString(h1[:]), hex.EncodeToString(h2[:]))
+	require.EqualValues(t, gasOf(maxOfData)+2*(1+cost+GasPerHashedBlock), gasOf("max(blake2b(1), blake2b(2))"))
+
+	// hashing and building of data consume gas for each started block of the data
+	data64, data65 := bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{1}, 65)
+	require.EqualValues(t, gasOf("blake2b($0)", data64)+GasPerHashedBlock, gasOf("blake2b($0)", data65))
+	require.EqualValues(t, gasOf("chainHash($0, 1)", data64[1:])+GasPerHashedBlock, gasOf("chainHash($0, 1)", data64))
+	require.EqualValues(t, gasOf("concat($0, 1)", data64[1:])+GasPerCopiedBlock, gasOf("concat($0, 1)", data64))
+	require.EqualValues(t, 3+4*GasPerCopiedBlock, gasOf("repeat(1, 255)"))
+	require.EqualValues(t, 3, gasOf("repeat(1, 0)"))
+	// multi-signature consumes gas for each verified signature
+	msg := []byte("message")
+	pubKeys := make([]byte, 0)
+	entries := make([]byte, 0)
+	for i := 0; i < 3; i++ {
+		privKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i)}, ed25519.SeedSize))
+		pubKeys = append(pubKeys, privKey.Public().(ed25519.PublicKey)...)
+		entries = append(entries, byte(i))
+		entries = append(entries, ed25519.Sign(privKey, msg)...)
+	}
+	require.EqualValues(t, 4+GasPerSignatureVerification+2*GasPerHashedBlock,
+		gasOf("validSignatureED25519($0, 1, $1)", data65, pubKeys[:32]))
+	multiSig := func(m byte, numEntries int) uint64 {
+		return gasOf("validMultiSigED25519($0, $1, $2, $3)", msg, []byte{m}, pubKeys, entries[:numEntries*65])
+	}
+	require.EqualValues(t, multiSig(1, 1)+signatureGas(len(msg)), multiSig(2, 2))
+	require.EqualValues(t, multiSig(1, 1)+2*signatureGas(len(msg)), multiSig(3, 3))
+	require.EqualValues(t, multiSig(1, 1), multiSig(1, 3))
+
+	// direct evaluation consumes the same gas
+	for _, src := range []string{"add(1, 2)", "max(blake2b(1), blake2b(2))", "concat(if(1, 2, 3), min(5, 6))"} {
+		code := mustCompile(t, lib, src)
+		m := lib.NewGasMeter(math.MaxUint64)
+		_, err = lib.EvalBytecodeDirect(WithGasMeter(nil, m), code)
+		require.NoError(t, err)
+		require.EqualValues(t, gasOf(src), m.Used(), src)
+	}
+
+	// budget
+	code := mustCompile(t, lib, "blake2b(blake2b(1))")
+	m := lib.NewGasMeter(gasOf("blake2b(blake2b(1))") - 1)
+	_, err = lib.EvalFromBytecode(WithGasMeter(nil, m), code)
+	var errGas *ErrOutOfGas
+	require.True(t, errors.As(err, &errGas))
+	require.EqualValues(t, m.Limit(), errGas.Limit)
+	require.EqualValues(t, m.Limit(), m.Used())
+	require.EqualValues(t, 0, m.Remaining())
+	// data script is metered too
+	_, err = lib.EvalFromBytecode(WithGasMeter(nil, lib.NewGasMeter(0)), mustCompile(t, lib, "1"))
+	require.True(t, errors.As(err, &errGas))
+	// exhausted budget is propagated from the dynamic evaluation as is
+	_, err = lib.EvalFromSource(WithGasMeter(nil, lib.NewGasMeter(10)),
+		fmt.Sprintf("eval(0x%s)", hex.EncodeToString(code)))
+	require.True(t, errors.As(err, &errGas))
+	RequireErrorWith(t, err, "out of gas: limit 10")
+	// not metered evaluation
+	_, err = lib.EvalFromBytecode(nil, code)
+	require.NoError(t, err)
+
+	// configurable costs
+	require.NoError(t, lib.SetGasCost("concat", 100))
+	require.EqualValues(t, 101, gasOf("concat"))
+	require.Error(t, lib.SetGasCost("unknownFunction", 1))
+
+	// host functions consume gas depending on arguments
+	lib.UpgradeWthEmbeddedLong(&EmbeddedFunctionData{"hostLookup", 1, func(par *CallParams) []byte {
+		key := par.Arg(0)
+		par.ConsumeGas(uint64(len(key)))
+		return key
+	}})
+	require.EqualValues(t, 2+5, gasOf("hostLookup(0x0102030405)"))
+	_, err = lib.EvalFromSource(nil, "hostLookup(0x0102030405)")
+	require.NoError(t, err)
+}
+
+func TestHashedExtensionCodes(t *testing.T) {
+	require.False(t, NewBase().HashedExtensionCodes())
+
+	lib := NewBase(WithHashedExtensionCodes())
+	require.True(t, lib.HashedExtensionCodes())
+	// base library is the same
+	require.EqualValues(t, NewBase().LibraryHash(), lib.LibraryHash())
+
+	// codes do not depend on the order of extensions
+	libReverse := NewBase(WithHashedExtensionCodes())
+	syms := []string{"hostA", "hostB", "hostC"}
+	for i := range syms {
+		code, err := lib.ExtendErr(syms[i], "concat($0, 1)")
+		require.NoError(t, err)
+		require.EqualValues(t, HashedFunCode(syms[i]), code)
+		require.True(t, code >= FirstHashedExtendedFun && code <= LastHashedExtendedFun)
+
+		sym := syms[len(syms)-1-i]
+		code, err = libReverse.ExtendErr(sym, "concat($0, 1)")
+		require.NoError(t, err)
+		require.EqualValues(t, HashedFunCode(sym), code)
+	}
+	require.NoError(t, lib.VerifyInternalConsistency())
+	require.NoError(t, libReverse.VerifyInternalConsistency())
+	require.EqualValues(t, lib.LibraryHash(), libReverse.LibraryHash())
+	require.EqualValues(t, mustCompile(t, lib, "hostB(hostA(2))"), mustCompile(t, libReverse, "hostB(hostA(2))"))
+	res, err := lib.EvalFromSource(nil, "hostB(hostA(2))")
+	require.NoError(t, err)
+	require.EqualValues(t, []byte{2, 1, 1}, res)
+
+	// collision
+	var sym string
+	for i := 0; ; i++ {
+		sym = fmt.Sprintf("host%d", i)
+		if HashedFunCode(sym) == HashedFunCode("hostA") {
+			break
+		}
+	}
+	_, err = lib.ExtendErr(sym, "concat($0, 2)")
+	var errCollision *ErrFunCodeCollision
+	require.True(t, errors.As(err, &errCollision))
+	require.EqualValues(t, "hostA", errCollision.Other)
+	require.NoError(t, lib.VerifyInternalConsistency())
+
+	require.NoError(t, lib.clone().VerifyInternalConsistency())
+
+	// without the option the whole extended range is allocated sequentially
+	libSeq := NewBase()
+	for i := 0; ; i++ {
+		code, err := libSeq.ExtendErr(fmt.Sprintf("seq%d", i), "concat($0, 1)")
+		if err != nil {
+			RequireErrorWith(t, err, "too many extended functions")
+			break
+		}
+		require.EqualValues(t, FirstExtendedFun+libSeq.numExtended-1, code)
+	}
+	require.EqualValues(t, MaxNumExtendedGlobal, libSeq.numExtended)
+	require.NoError(t, libSeq.VerifyInternalConsistency())
+}
+
+func TestEstimateCost(t *testing.T) {
+	lib := NewBase()
+	estimate := func(src string) uint64 {
+		ret, err := lib.EstimateCost(mustCompile(t, lib, src))
+		require.NoError(t, err)
+		return ret
+	}
+	blake2bCost, err := lib.GasCost("blake2b")
+	require.NoError(t, err)
+	require.EqualValues(t, 1+1, estimate("1"))
+	require.EqualValues(t, 3+2, estimate("add(1, 2)"))
+	require.EqualValues(t, 1+3, estimate("0x010203"))
+	// all branches are taken
+	require.EqualValues(t, 1+2+(1+blake2bCost+2+GasPerHashedBlock)+2, estimate("if(1, blake2b(2), 3)"))
+
+	// estimate is the upper bound of the consumed gas
+	for _, src := range []string{
+		"add(1, 2)",
+		"if(1, blake2b(2), 3)",
+		"max(blake2b(1), blake2b(2))",
+		"or(0x, and(1, 2), validSignatureED25519(1, 2, 3))",
+		"concat(min(1, 2), lessThan(5, 6), not(0x))",
+		"blake2b(repeat(concat(1, 2, blake2b(3)), 200), prand(1, 200))",
+	} {
+		m := lib.NewGasMeter(math.MaxUint64)
+		_, _ = lib.EvalFromBytecode(WithGasMeter(nil, m), mustCompile(t, lib, src))
+		require.True(t, m.Used() <= estimate(src), src)
+	}
+	// weights are costs of functions
+	require.NoError(t, lib.SetGasCost("concat", 100))
+	require.EqualValues(t, 1+100+2+2+GasPerCopiedBlock, estimate("concat(1, 2)"))
+
+	// gas depending on the size of data is estimated with bounds of the size
+	// the count of 'repeat' is up to 255, so the size of the result is up to 255 bytes
+	require.EqualValues(t, 1+blake2bCost+4*GasPerHashedBlock, estimate("blake2b(repeat(1, 64))")-estimate("repeat(1, 64)"))
+	_, err = lib.EstimateCost(mustCompile(t, lib, "blake2b($0)"))
+	var errNotBounded *ErrCostNotBounded
+	require.True(t, errors.As(err, &errNotBounded))
+	require.True(t, errNotBounded.SizeDependent)
+	require.EqualValues(t, "blake2b", errNotBounded.Sym)
+	code := mustCompile(t, lib, "blake2b($0, $1)")
+	withArgs, err := lib.EstimateCostWithArgSize(code, 64)
+	require.NoError(t, err)
+	require.EqualValues(t, 1+blake2bCost+1+1+2*GasPerHashedBlock, withArgs)
+	m := lib.NewGasMeter(math.MaxUint64)
+	_, err = lib.EvalFromBytecode(WithGasMeter(nil, m), code, bytes.Repeat([]byte{1}, 64), bytes.Repeat([]byte{2}, 64))
+	require.NoError(t, err)
+	require.EqualValues(t, withArgs, m.Used())
+	// sizes of arguments are propagated into bodies of extended functions
+	_, err = lib.ExtendErr("hashTwice", "blake2b($0, $0)")
+	require.NoError(t, err)
+	withArgs, err = lib.EstimateCostWithArgSize(mustCompile(t, lib, "hashTwice($0)"), 64)
+	require.NoError(t, err)
+	require.EqualValues(t, 1+1+(1+blake2bCost+1+1+2*GasPerHashedBlock), withArgs)
+	// multi-signature is estimated by the number of signature entries
+	msEstimate, err := lib.EstimateCostWithArgSize(mustCompile(t, lib, "validMultiSigED25519($0, 1, $1, $2)"), 3*65)
+	require.NoError(t, err)
+	require.True(t, msEstimate >= 3*GasPerSignatureVerification)
+
+	// dynamic evaluation
+	_, err = lib.EstimateCost(mustCompile(t, lib, "eval(0x01)"))
+	require.True(t, errors.As(err, &errNotBounded))
+	require.False(t, errNotBounded.SizeDependent)
+	require.EqualValues(t, "eval", errNotBounded.Sym)
+	_, err = lib.ExtendErr("evalTwice", "concat(eval($0), eval($0))")
+	require.NoError(t, err)
+	_, err = lib.EstimateCost(mustCompile(t, lib, "concat(1, evalTwice(2))"))
+	require.True(t, errors.As(err, &errNotBounded))
+
+	// parsing bytecode does not evaluate it
+	require.EqualValues(t, 1+estimate("0x88010203")+estimate("0x88")+estimate("1"),
+		estimate("parseArgumentBytecode(0x88010203, 0x88, 1)"))
+
+	_, err = lib.EstimateCost([]byte{0xff})
+	require.Error(t, err)
+}
+
+func TestGasMeteringParallel(t *testing.T) {
+	lib := NewBase()
+	code := mustCompile(t, lib, "blake2b($0)")
+	argSets := [][][]byte{{{1}}, {{2}}, {{3}}, {{4}}}
+	items := make([]BatchItem, len(argSets))
+	for i := range argSets {
+		items[i] = BatchItem{Bytecode: code, Args: argSets[i]}
+	}
+
+	m := lib.NewGasMeter(math.MaxUint64)
+	glb := WithGasMeter(nil, m)
+	_, errs := lib.EvalMany(glb, code, argSets, 4)
+	for _, err := range errs {
+		require.True(t, errors.Is(err, ErrGasMeterConcurrent))
+	}
+	for _, r := range lib.EvalBatch(glb, items, 4) {
+		require.True(t, errors.Is(r.Err, ErrGasMeterConcurrent))
+	}
+	require.EqualValues(t, 0, m.Used())
+
+	// sequentially the meter is charged for all evaluations
+	_, errs = lib.EvalMany(glb, code, argSets, 1)
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	used := m.Used()
+	single := lib.NewGasMeter(math.MaxUint64)
+	_, err := lib.EvalFromBytecode(WithGasMeter(nil, single), code, []byte{1})
+	require.NoError(t, err)
+	require.EqualValues(t, uint64(len(argSets))*single.Used(), used)
+	for _, r := range lib.EvalBatch(glb, items, 1) {
+		require.NoError(t, r.Err)
+	}
+	require.EqualValues(t, 2*used, m.Used())
+}
+
+func TestParsedCallPrefixes(t *testing.T) {
+	lib := NewBase()
+	libData, err := lib.CompileLocalLibrary(`
+ func fun1 : concat($0, $$1)
+ func fun2 : fun1(and($0, 1), lessThan(2, 3))
+`)
+	require.NoError(t, err)
+	localLib, err := lib.LocalLibraryFromBytes(libData)
+	require.NoError(t, err)
+
+	code := libData[1]
+	expr, err := lib.ExpressionFromBytecode(code, localLib)
+	require.NoError(t, err)
+	// call prefixes of parsed nodes are not copied, they share the bytecode
+	inCode := func(prefix []byte) bool {
+		for i := range code {
+			if &code[i] == &prefix[0] {
+				return true
+			}
+		}
+		return false
+	}
+	lengths := make(map[string]int)
+	var walk func(e *Expression)
+	walk = func(e *Expression) {
+		require.True(t, inCode(e.CallPrefix), e.FunctionName)
+		lengths[e.FunctionName] = len(e.CallPrefix)
+		for _, arg := range e.Args {
+			walk(arg)
+		}
+	}
+	walk(expr)
+	// local call, long call, short call, parameter reference, inline data
+	require.EqualValues(t, 3, len(expr.CallPrefix))
+	require.EqualValues(t, 2, lengths["and"])
+	require.EqualValues(t, 1, lengths["lessThan"])
+	require.EqualValues(t, 1, lengths["$0"])
+	require.EqualValues(t, 2, lengths["1"])
+
+	// parameter references and calls without arguments allocate only the expression node
+	code = mustCompile(t, lib, "and($0, $1, $$2, $3)")
+	require.EqualValues(t, 6, testing.AllocsPerRun(100, func() {
+		_, _ = lib.ExpressionFromBytecode(code)
+	}))
+}
+
+func TestDirectCodeBoundaries(t *testing.T) {
+	lib := NewBase()
+	src := "if(equal($0, 1), concat(0x0102, and($1, or(nil, 2)), $$1), max(u64/5, len($0)))"
+	code := mustCompile(t, lib, src)
+	d, err := lib.newDirectCode(code)
+	require.NoError(t, err)
+	require.EqualValues(t, len(code), d.ends[0])
+
+	// boundaries computed in one pass are the same as lengths of fragments
+	expr, err := lib.ExpressionFromBytecode(code)
+	require.NoError(t, err)
+	pos := 0
+	var walk func(e *Expression)
+	walk = func(e *Expression) {
+		n, err := lib.bytecodeFragmentLength(code[pos:])
+		require.NoError(t, err)
+		require.EqualValues(t, pos+n, d.ends[pos], e.FunctionName)
+		pos += len(e.CallPrefix)
+		for _, arg := range e.Args {
+			walk(arg)
+		}
+	}
+	walk(expr)
+	require.EqualValues(t, len(code), pos)
+
+	for _, args := range [][][]byte{{{1}, {2}}, {{3}, {4}}} {
+		exp, err := lib.EvalFromBytecode(nil, code, args...)
+		require.NoError(t, err)
+		ret, err := lib.EvalBytecodeDirect(nil, code, args...)
+		require.NoError(t, err)
+		require.EqualValues(t, exp, ret)
+	}
+	_, err = lib.newDirectCode(code[:len(code)-1])
+	require.Error(t, err)
+
+	// data and parameter references are evaluated without allocations, each call allocates only its CallParams
+	flat := mustCompile(t, lib, "concat($0, 1, $1, 0x0203)")
+	allocsDirect := testing.AllocsPerRun(100, func() {
+		_, _ = lib.EvalBytecodeDirect(nil, flat, []byte{1}, []byte{2})
+	})
+	allocsTree := testing.AllocsPerRun(100, func() {
+		_, _ = lib.EvalFromBytecode(nil, flat, []byte{1}, []byte{2})
+	})
+	require.Less(t, allocsDirect, allocsTree)
+}
+
+var benchmarkDirectSources = map[string]string{
+	"binary": "and(equal(add($0, 1), u64/3), lessThan($0, 5), equal(concat($1, 2), 0x0102))",
+	"nested": "if(equal($0, 1), concat(0x0102, and($1, or(nil, 2)), max(u64/5, len($0))), min(u64/5, len($1)))",
+}
+
+// BenchmarkEvalBytecodeDirect compares evaluation of the bytecode without building the expression tree with
+// the evaluation through the tree, both evaluated once per parsing
+func BenchmarkEvalBytecodeDirect(b *testing.B) {
+	lib := NewBase()
+	for name, src := range benchmarkDirectSources {
+		code := mustCompile(b, lib, src)
+		b.Run(name+"/direct", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = lib.EvalBytecodeDirect(nil, code, []byte{2}, []byte{1})
+			}
+		})
+		b.Run(name+"/tree", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = lib.EvalFromBytecode(nil, code, []byte{2}, []byte{1})
+			}
+		})
+	}
+}
+
+func TestExportClosedBytecodeLimit(t *testing.T) {
+	lib := NewBase()
+	// each level doubles uses of the argument, so the inlined bytecode grows as 2^(2^level)
+	src := "func nest0: concat($0, $0)\n"
+	for i := 1; i <= 8; i++ {
+		src += fmt.Sprintf("func nest%d: nest%d(nest%d($0))\n", i, i-1, i-1)
+	}
+	require.NoError(t, lib.ExtendMany(src))
+
+	code, err := lib.ExportClosedBytecode("nest2")
+	require.NoError(t, err)
+	exp, err := lib.EvalFromSource(nil, "nest2($0)", []byte{7})
+	require.NoError(t, err)
+	ret, err := lib.EvalFromBytecode(nil, code, []byte{7})
+	require.NoError(t, err)
+	require.EqualValues(t, exp, ret)
+
+	for _, sym := range []string{"nest4", "nest8"} {
+		_, err = lib.ExportClosedBytecode(sym)
+		require.True(t, errors.Is(err, ErrClosedBytecodeTooLong), sym)
+	}
+}
@@ -0,0 +1,272 @@
+package easyfl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+type (
+	// LintFinding is a problem of the library function, found by the lint rule
+	LintFinding struct {
+		Rule    string
+		Sym     string
+		Message string
+	}
+
+	// LintRule checks functions of the library
+	LintRule struct {
+		Name  string
+		Check func(lib *Library) []LintFinding
+	}
+)
+
+// DefaultMaxLintBytecodeSize is the bytecode size of extended function reported by the default rules
+const DefaultMaxLintBytecodeSize = 256
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: '%s': %s", f.Rule, f.Sym, f.Message)
+}
+
+// DefaultLintRules are rules used by LintLibrary when no rules are provided.
+// LintUnusedExtended is not among them, because it needs entry points of the library
+func DefaultLintRules() []LintRule {
+	return []LintRule{
+		LintUnusedInternal(),
+		LintAliases(),
+		LintBytecodeSize(DefaultMaxLintBytecodeSize),
+		LintMissingSemantics(),
+		LintUnregisteredFailCodes(),
+	}
+}
+
+// LintLibrary checks the library with the rules. Findings are sorted by function code.
+// Findings about symbols which are not library functions follow them, in the order of rules.
+// Literals are not checked, because the library keeps only bytecode of the functions.
+// Use CompileExpressionWithWarnings to check sources
+func LintLibrary(lib *Library, rules ...LintRule) []LintFinding {
+	if len(rules) == 0 {
+		rules = DefaultLintRules()
+	}
+	ret := make([]LintFinding, 0)
+	for _, rule := range rules {
+		ret = append(ret, rule.Check(lib)...)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		fdi, foundi := lib.funByName[ret[i].Sym]
+		fdj, foundj := lib.funByName[ret[j].Sym]
+		switch {
+		case foundi && foundj:
+			return fdi.funCode < fdj.funCode
+		default:
+			return foundi && !foundj
+		}
+	})
+	return ret
+}
+
+// extendedBodies returns parsed bodies of extended functions in the order of function codes
+func (lib *Library) extendedBodies() ([]*funDescriptor, []*Expression) {
+	descriptors := make([]*funDescriptor, 0)
+	bodies := make([]*Expression, 0)
+	for _, fd := range lib.descriptorsByFunCode() {
+		if len(fd.bytecode) == 0 {
+			continue
+		}
+		expr, err := lib.ExpressionFromBytecode(fd.bytecode)
+		AssertNoError(err)
+		descriptors = append(descriptors, fd)
+		bodies = append(bodies, expr)
+	}
+	return descriptors, bodies
+}
+
+// calledByLibrary returns functions called by extended functions of the library
+func (lib *Library) calledByLibrary() map[*funDescriptor]bool {
+	ret := make(map[*funDescriptor]bool)
+	_, bodies := lib.extendedBodies()
+	var mark func(e *Expression)
+	mark = func(e *Expression) {
+		if !IsDataPrefix(e.CallPrefix) && !isParameterReference(e.CallPrefix) {
+			ret[lib.descriptorOfCall(e.CallPrefix)] = true
+		}
+		for _, arg := range e.Args {
+			mark(arg)
+		}
+	}
+	for _, body := range bodies {
+		mark(body)
+	}
+	return ret
+}
+
+// LintUnusedExtended reports extended functions which are not called by any other library function and are not
+// entry points of the library, i.e. functions called by scripts. Internal functions are reported even if listed
+// as entry points, because they can't be called from outside. Entry points, which are not extended functions
+// of the library, are reported too
+func LintUnusedExtended(entryPoints ...string) LintRule {
+	const name = "unused extended"
+	return LintRule{
+		Name: name,
+		Check: func(lib *Library) []LintFinding {
+			ret := make([]LintFinding, 0)
+			isEntryPoint := make(map[string]bool)
+			for _, sym := range entryPoints {
+				isEntryPoint[sym] = true
+				if fd, found := lib.funByName[sym]; !found || len(fd.bytecode) == 0 {
+					ret = append(ret, LintFinding{Rule: name, Sym: sym, Message: "entry point is not an extended function of the library"})
+				}
+			}
+			called := lib.calledByLibrary()
+			for _, fd := range lib.descriptorsByFunCode() {
+				if len(fd.bytecode) == 0 || called[fd] || (isEntryPoint[fd.sym] && !fd.internal) {
+					continue
+				}
+				ret = append(ret, LintFinding{Rule: name, Sym: fd.sym, Message: "extended function is not called by any library function and is not an entry point"})
+			}
+			return ret
+		},
+	}
+}
+
+// LintUnusedInternal reports internal functions which are not called by any other library function.
+// They can't be called from outside, so they are dead code
+func LintUnusedInternal() LintRule {
+	const name = "unused internal"
+	return LintRule{
+		Name: name,
+		Check: func(lib *Library) []LintFinding {
+			called := lib.calledByLibrary()
+			ret := make([]LintFinding, 0)
+			for _, fd := range lib.descriptorsByFunCode() {
+				if fd.internal && !called[fd] {
+					ret = append(ret, LintFinding{Rule: name, Sym: fd.sym, Message: "internal function is not called by any library function"})
+				}
+			}
+			return ret
+		},
+	}
+}
+
+// LintAliases reports extended functions which only call another function with all own parameters
+// in the same order, i.e. duplicate its semantics under another name
+func LintAliases() LintRule {
+	const name = "alias"
+	return LintRule{
+		Name: name,
+		Check: func(lib *Library) []LintFinding {
+			ret := make([]LintFinding, 0)
+			descriptors, bodies := lib.extendedBodies()
+			for i, body := range bodies {
+				if IsDataPrefix(body.CallPrefix) || isParameterReference(body.CallPrefix) {
+					continue
+				}
+				if len(body.Args) != descriptors[i].requiredNumParams {
+					continue
+				}
+				same := true
+				for j, arg := range body.Args {
+					if len(arg.CallPrefix) != 1 || arg.CallPrefix[0] != byte(j) {
+						same = false
+						break
+					}
+				}
+				if same {
+					ret = append(ret, LintFinding{
+						Rule:    name,
+						Sym:     descriptors[i].sym,
+						Message: fmt.Sprintf("function is an alias of '%s'", body.FunctionName),
+					})
+				}
+			}
+			return ret
+		},
+	}
+}
+
+// LintBytecodeSize reports extended functions with bytecode longer than maxSize
+func LintBytecodeSize(maxSize int) LintRule {
+	const name = "bytecode size"
+	return LintRule{
+		Name: name,
+		Check: func(lib *Library) []LintFinding {
+			ret := make([]LintFinding, 0)
+			for _, fd := range lib.descriptorsByFunCode() {
+				if len(fd.bytecode) > maxSize {
+					ret = append(ret, LintFinding{
+						Rule:    name,
+						Sym:     fd.sym,
+						Message: fmt.Sprintf("bytecode is %d bytes long, more than %d", len(fd.bytecode), maxSize),
+					})
+				}
+			}
+			return ret
+		},
+	}
+}
+
+// LintMissingSemantics reports embedded functions without semantics annotations.
+// Semantics annotations are the only description of the embedded function the library keeps
+func LintMissingSemantics() LintRule {
+	const name = "missing semantics"
+	return LintRule{
+		Name: name,
+		Check: func(lib *Library) []LintFinding {
+			ret := make([]LintFinding, 0)
+			for _, fd := range lib.descriptorsByFunCode() {
+				if isEmbedded, _ := fd.isEmbeddedOrShort(); isEmbedded && fd.semantics == nil {
+					ret = append(ret, LintFinding{Rule: name, Sym: fd.sym, Message: "embedded function has no semantics annotation"})
+				}
+			}
+			return ret
+		},
+	}
+}
+
+// LintUnregisteredFailCodes reports library functions which fail with the error code, not registered
+// with RegisterFailCode for 'fail' or with RegisterErrorCode for 'requireErr'
+func LintUnregisteredFailCodes() LintRule {
+	const name = "unregistered fail code"
+	return LintRule{
+		Name: name,
+		Check: func(lib *Library) []LintFinding {
+			ret := make([]LintFinding, 0)
+			descriptors, bodies := lib.extendedBodies()
+			for i, body := range bodies {
+				var check func(e *Expression)
+				check = func(e *Expression) {
+					// error codes are inline data: 1 byte for 'fail', 2 bytes for 'requireErr'
+					switch {
+					case e.FunctionName == "fail" && isInlineDataOfSize(e.Args[0], 1):
+						code := e.Args[0].CallPrefix[1]
+						if _, found := lib.FailCodeInfo(code); !found {
+							ret = append(ret, LintFinding{
+								Rule:    name,
+								Sym:     descriptors[i].sym,
+								Message: fmt.Sprintf("fail code %d is not registered", code),
+							})
+						}
+					case e.FunctionName == "requireErr" && isInlineDataOfSize(e.Args[1], 2):
+						code := binary.BigEndian.Uint16(e.Args[1].CallPrefix[1:])
+						if _, found := lib.ErrorCodeMessage(code); !found {
+							ret = append(ret, LintFinding{
+								Rule:    name,
+								Sym:     descriptors[i].sym,
+								Message: fmt.Sprintf("requireErr error code %d is not registered", code),
+							})
+						}
+					}
+					for _, arg := range e.Args {
+						check(arg)
+					}
+				}
+				check(body)
+			}
+			return ret
+		},
+	}
+}
+
+func isInlineDataOfSize(e *Expression, size int) bool {
+	return IsDataPrefix(e.CallPrefix) && len(e.CallPrefix) == size+1
+}
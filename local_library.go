@@ -10,8 +10,54 @@ type (
 		funByName    map[string]*funDescriptor
 		funByFunCode []*funDescriptor // code of the function respective to the baseline of numExtended+FirstExtendedFun+1
 	}
+
+	// LocalLibraryLimits bounds local library coming from untrusted source. 0 means no limit
+	LocalLibraryLimits struct {
+		MaxFunctions    int
+		MaxFunctionSize int
+		MaxTotalSize    int
+	}
+
+	// LocalLibraryError is returned when local library is not valid or exceeds limits
+	LocalLibraryError struct {
+		// index of the function or -1 if error is about the whole library
+		Index int
+		Err   error
+	}
 )
 
+var (
+	ErrLocalLibraryTooManyFunctions = errors.New("too many functions in the local library")
+	ErrLocalLibraryFunctionTooLarge = errors.New("local library function is too large")
+	ErrLocalLibraryTooLarge         = errors.New("local library is too large")
+	ErrLocalLibrariesDisabled       = errors.New("local libraries are disabled in the library")
+)
+
+func (e *LocalLibraryError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("local library: %v", e.Err)
+	}
+	return fmt.Sprintf("local library function #%d: %v", e.Index, e.Err)
+}
+
+func (e *LocalLibraryError) Unwrap() error {
+	return e.Err
+}
+
+// WithoutLocalLibraries disables local libraries: they can't be compiled or parsed, and bytecode
+// with local library calls is rejected by parsing and by UsedFunctions. Disabled local libraries are part
+// of the library hash
+func WithoutLocalLibraries() LibraryOption {
+	return func(lib *Library) {
+		lib.noLocalLibraries = true
+	}
+}
+
+// LocalLibrariesEnabled returns false if the library was constructed WithoutLocalLibraries
+func (lib *Library) LocalLibrariesEnabled() bool {
+	return !lib.noLocalLibraries
+}
+
 func NewLocalLibrary() *LocalLibrary {
 	return &LocalLibrary{
 		funByName:    make(map[string]*funDescriptor),
@@ -20,6 +66,9 @@ func NewLocalLibrary() *LocalLibrary {
 }
 
 func (lib *Library) CompileLocalLibrary(source string) ([][]byte, error) {
+	if lib.noLocalLibraries {
+		return nil, ErrLocalLibrariesDisabled
+	}
 	libLoc := NewLocalLibrary()
 	ret := make([][]byte, 0)
 	parsed, err := parseFunctions(source)
@@ -29,20 +78,25 @@ func (lib *Library) CompileLocalLibrary(source string) ([][]byte, error) {
 	for _, pf := range parsed {
 		f, numParam, binCode, err := lib.CompileExpression(pf.SourceCode, libLoc)
 		if err != nil {
-			return nil, fmt.Errorf("error while compiling '%s': %v", pf.Sym, err)
+			return nil, fmt.Errorf("error while compiling '%s' at line %d: %v", pf.Sym, pf.Line, err)
 		}
 
-		Assert(len(lib.funByName) <= 255, "a local library can contain uo to 255 functions")
+		if len(libLoc.funByFunCode) >= MaxNumLocalFunctions {
+			return nil, fmt.Errorf("a local library can contain up to %d functions", MaxNumLocalFunctions)
+		}
 
 		if lib.existsFunction(pf.Sym, libLoc) {
-			return nil, errors.New("repeating symbol '" + pf.Sym + "'")
+			return nil, fmt.Errorf("repeating symbol '%s' at line %d", pf.Sym, pf.Line)
 		}
 		if numParam > 15 {
 			return nil, errors.New("can't be more than 15 parameters")
 		}
+		if err = checkParameterGaps(f, numParam); err != nil {
+			return nil, fmt.Errorf("error while compiling '%s' at line %d: %v", pf.Sym, pf.Line, err)
+		}
 		embeddedFun := makeEmbeddedFunForExpression(pf.Sym, f)
 		if traceYN {
-			embeddedFun = wrapWithTracing(embeddedFun, pf.Sym)
+			embeddedFun = lib.wrapWithTracing(embeddedFun, pf.Sym)
 		}
 		funCode := FirstLocalFunCode + uint16(len(libLoc.funByName))
 		dscr := &funDescriptor{
@@ -50,6 +104,8 @@ func (lib *Library) CompileLocalLibrary(source string) ([][]byte, error) {
 			funCode:           funCode,
 			requiredNumParams: numParam,
 			embeddedFun:       embeddedFun,
+			staticDepth:       lib.staticDepth(f, libLoc),
+			bytecodeParams:    bytecodeParamsOf(f),
 		}
 		libLoc.funByName[pf.Sym] = dscr
 		libLoc.funByFunCode = append(libLoc.funByFunCode, dscr)
@@ -58,19 +114,62 @@ func (lib *Library) CompileLocalLibrary(source string) ([][]byte, error) {
 	return ret, nil
 }
 
+// LocalLibraryFromBytesWithLimits checks the binary local library against limits and parses it.
+// Errors are of type *LocalLibraryError
+func (lib *Library) LocalLibraryFromBytesWithLimits(bin [][]byte, limits LocalLibraryLimits) (*LocalLibrary, error) {
+	if limits.MaxFunctions > 0 && len(bin) > limits.MaxFunctions {
+		return nil, &LocalLibraryError{
+			Index: -1,
+			Err:   fmt.Errorf("%w: %d > %d", ErrLocalLibraryTooManyFunctions, len(bin), limits.MaxFunctions),
+		}
+	}
+	total := 0
+	for i, data := range bin {
+		if limits.MaxFunctionSize > 0 && len(data) > limits.MaxFunctionSize {
+			return nil, &LocalLibraryError{
+				Index: i,
+				Err:   fmt.Errorf("%w: %d > %d bytes", ErrLocalLibraryFunctionTooLarge, len(data), limits.MaxFunctionSize),
+			}
+		}
+		total += len(data)
+	}
+	if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+		return nil, &LocalLibraryError{
+			Index: -1,
+			Err:   fmt.Errorf("%w: %d > %d bytes", ErrLocalLibraryTooLarge, total, limits.MaxTotalSize),
+		}
+	}
+	return lib.LocalLibraryFromBytes(bin)
+}
+
+// LocalLibraryFromBytes parses binary local library. Each function can call only functions with smaller indices
+// and only with the number of arguments it requires. Internal library functions can't be called.
+// Errors are of type *LocalLibraryError
 func (lib *Library) LocalLibraryFromBytes(bin [][]byte) (*LocalLibrary, error) {
-	if len(bin) > 255 {
-		return nil, fmt.Errorf("local library can contain up to 255 elements")
+	if lib.noLocalLibraries {
+		return nil, &LocalLibraryError{Index: -1, Err: ErrLocalLibrariesDisabled}
+	}
+	if len(bin) > MaxNumLocalFunctions {
+		return nil, &LocalLibraryError{
+			Index: -1,
+			Err:   fmt.Errorf("%w: local library can contain up to %d elements", ErrLocalLibraryTooManyFunctions, MaxNumLocalFunctions),
+		}
 	}
 	ret := NewLocalLibrary()
 
 	for i, data := range bin {
 		expr, remaining, maxParam, err := lib.expressionFromBytecode(data, ret)
 		if err != nil {
-			return nil, err
+			return nil, &LocalLibraryError{Index: i, Err: err}
 		}
 		if len(remaining) != 0 {
-			return nil, fmt.Errorf("not all bytes have been consumed")
+			return nil, &LocalLibraryError{
+				Index: i,
+				Err:   fmt.Errorf("not all bytes have been consumed"),
+			}
+		}
+		if err = lib.checkNotInternal(expr); err != nil {
+			return nil, &LocalLibraryError{Index: i, Err: err}
 		}
 		sym := fmt.Sprintf("lib#%d", i)
 		numParams := 0
@@ -83,6 +182,8 @@ func (lib *Library) LocalLibraryFromBytes(bin [][]byte) (*LocalLibrary, error) {
 			funCode:           uint16(FirstLocalFunCode + i),
 			requiredNumParams: numParams,
 			embeddedFun:       makeEmbeddedFunForExpression(sym, expr),
+			staticDepth:       lib.staticDepth(expr, ret),
+			bytecodeParams:    bytecodeParamsOf(expr),
 		}
 		ret.funByFunCode = append(ret.funByFunCode, dscr)
 	}
@@ -0,0 +1,167 @@
+package easyfl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// LockFormatVersion is the version of the library lock format produced by MakeLock
+const LockFormatVersion = 2
+
+type (
+	// LibraryLock captures the library, so that downstream projects can prove the library they test against
+	// is bit-identical to the deployed one. Bytecode hashes of extended functions make changes in the
+	// compiler visible, not only changes in the sources. Versions of the bytecode format and of its canonical
+	// encoding make visible changes in the encoding, which do not change the bytecode of the library itself
+	LibraryLock struct {
+		FormatVersion    int                     `json:"format_version"`
+		BytecodeVersion  int                     `json:"bytecode_version"`
+		Canonicalization CanonicalizationVersion `json:"canonicalization"`
+		LibraryHash      string                  `json:"library_hash"`
+		Functions        []LockedFunction        `json:"functions"`
+	}
+
+	// LockedFunction is a function entry of the library lock
+	LockedFunction struct {
+		Sym       string `json:"sym"`
+		FunCode   uint16 `json:"fun_code"`
+		NumParams int    `json:"num_params"`
+		// hex encoded blake2b hash of the bytecode. Empty for embedded functions
+		BytecodeHash string `json:"bytecode_hash,omitempty"`
+	}
+)
+
+// Errors of VerifyLock. They are returned as pointers, so the cause can be checked with errors.As
+type (
+	// ErrMissingFunction is returned when locked function is not in the library
+	ErrMissingFunction struct {
+		Sym     string
+		FunCode uint16
+	}
+
+	// ErrBytecodeMismatch is returned when the function differs from the locked one: function code,
+	// number of parameters or the bytecode
+	ErrBytecodeMismatch struct {
+		Sym     string
+		FunCode uint16
+		Want    LockedFunction
+		Got     LockedFunction
+	}
+
+	// ErrFunctionCountMismatch is returned when the library has functions which are not locked
+	ErrFunctionCountMismatch struct {
+		Want int
+		Got  int
+	}
+
+	// ErrHashMismatch is returned when hash of the library differs from the locked one
+	ErrHashMismatch struct {
+		Want string
+		Got  string
+	}
+
+	// ErrVersionMismatch is returned when the library was locked with another version of the bytecode format
+	// or of the canonical encoding
+	ErrVersionMismatch struct {
+		Name string
+		Want int
+		Got  int
+	}
+)
+
+func (e *ErrMissingFunction) Error() string {
+	return fmt.Sprintf("VerifyLock: function '%s' is missing in the library", e.Sym)
+}
+
+func (e *ErrBytecodeMismatch) Error() string {
+	return fmt.Sprintf("VerifyLock: function '%s' differs from the locked one", e.Sym)
+}
+
+func (e *ErrFunctionCountMismatch) Error() string {
+	return fmt.Sprintf("VerifyLock: library has %d functions, locked %d", e.Got, e.Want)
+}
+
+func (e *ErrVersionMismatch) Error() string {
+	return fmt.Sprintf("VerifyLock: %s version mismatch: locked %d, got %d", e.Name, e.Want, e.Got)
+}
+
+func (e *ErrHashMismatch) Error() string {
+	return fmt.Sprintf("VerifyLock: library hash mismatch: locked %s, got %s", e.Want, e.Got)
+}
+
+// Lock makes lock of the library
+func (lib *Library) Lock() *LibraryLock {
+	h := lib.LibraryHash()
+	ret := &LibraryLock{
+		FormatVersion:    LockFormatVersion,
+		BytecodeVersion:  SpecVersion,
+		Canonicalization: CanonicalizationLatest,
+		LibraryHash:      hex.EncodeToString(h[:]),
+		Functions:        make([]LockedFunction, 0, len(lib.funByFunCode)),
+	}
+	for _, fd := range lib.descriptorsByFunCode() {
+		lf := LockedFunction{
+			Sym:       fd.sym,
+			FunCode:   fd.funCode,
+			NumParams: fd.requiredNumParams,
+		}
+		if len(fd.bytecode) > 0 {
+			bh := blake2b.Sum256(fd.bytecode)
+			lf.BytecodeHash = hex.EncodeToString(bh[:])
+		}
+		ret.Functions = append(ret.Functions, lf)
+	}
+	return ret
+}
+
+// MakeLock serializes lock of the library into the lockfile
+func (lib *Library) MakeLock() ([]byte, error) {
+	return json.MarshalIndent(lib.Lock(), "", "  ")
+}
+
+// VerifyLock checks if the library is identical to the one captured by the lockfile.
+// Differences are reported as ErrVersionMismatch, ErrMissingFunction, ErrBytecodeMismatch, ErrFunctionCountMismatch
+// or ErrHashMismatch
+func VerifyLock(lock []byte, lib *Library) error {
+	var locked LibraryLock
+	dec := json.NewDecoder(bytes.NewReader(lock))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&locked); err != nil {
+		return fmt.Errorf("VerifyLock: can't parse lockfile: %v", err)
+	}
+	if locked.FormatVersion != LockFormatVersion {
+		return fmt.Errorf("VerifyLock: unsupported lock format version %d", locked.FormatVersion)
+	}
+	actual := lib.Lock()
+	if locked.BytecodeVersion != actual.BytecodeVersion {
+		return &ErrVersionMismatch{Name: "bytecode format", Want: locked.BytecodeVersion, Got: actual.BytecodeVersion}
+	}
+	if locked.Canonicalization != actual.Canonicalization {
+		return &ErrVersionMismatch{Name: "canonicalization", Want: int(locked.Canonicalization), Got: int(actual.Canonicalization)}
+	}
+	actualByName := make(map[string]*LockedFunction, len(actual.Functions))
+	for i := range actual.Functions {
+		actualByName[actual.Functions[i].Sym] = &actual.Functions[i]
+	}
+	// first report differences in functions, because they explain hash mismatch
+	for _, lf := range locked.Functions {
+		af, found := actualByName[lf.Sym]
+		if !found {
+			return &ErrMissingFunction{Sym: lf.Sym, FunCode: lf.FunCode}
+		}
+		if *af != lf {
+			return &ErrBytecodeMismatch{Sym: lf.Sym, FunCode: lf.FunCode, Want: lf, Got: *af}
+		}
+	}
+	if len(locked.Functions) != len(actual.Functions) {
+		return &ErrFunctionCountMismatch{Want: len(locked.Functions), Got: len(actual.Functions)}
+	}
+	if locked.LibraryHash != actual.LibraryHash {
+		return &ErrHashMismatch{Want: locked.LibraryHash, Got: actual.LibraryHash}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package easyfl
+
+import "fmt"
+
+// Logger receives diagnostic output of the library: library statistics, debug tracing of embedded functions
+// and printed evaluation traces. The embedding application sets its own logger to capture or silence it
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+type (
+	stdoutLogger struct{}
+	nopLogger    struct{}
+)
+
+// DefaultLogger prints to the standard output. It is used when no logger is set
+var DefaultLogger Logger = stdoutLogger{}
+
+// NopLogger discards all output
+var NopLogger Logger = nopLogger{}
+
+func (stdoutLogger) Printf(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// SetLogger sets logger of the library diagnostics. nil means DefaultLogger
+func (lib *Library) SetLogger(logger Logger) {
+	lib.logger = logger
+}
+
+// Logger returns logger of the library diagnostics
+func (lib *Library) Logger() Logger {
+	if lib.logger == nil {
+		return DefaultLogger
+	}
+	return lib.logger
+}
@@ -0,0 +1,98 @@
+package easyfl
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a bounded cache of values by hash keys, which evicts the least recently used values.
+// It is safe for concurrent use
+type lruCache struct {
+	mutex    sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[[32]byte]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+type lruEntry struct {
+	key   [32]byte
+	value interface{}
+}
+
+// CacheStats are counters of the cache
+type CacheStats struct {
+	Hits     uint64
+	Misses   uint64
+	Size     int
+	Capacity int
+}
+
+// HitRate is a share of hits among all lookups
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func newLRUCache(capacity int) *lruCache {
+	Assert(capacity > 0, "newLRUCache: capacity must be positive")
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[[32]byte]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key [32]byte) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, found := c.items[key]; found {
+		c.hits++
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).value, true
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *lruCache) put(key [32]byte, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, found := c.items[key]; found {
+		e.Value.(*lruEntry).value = value
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// reset removes all values. Counters are kept
+func (c *lruCache) reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[[32]byte]*list.Element)
+}
+
+func (c *lruCache) stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return CacheStats{
+		Hits:     c.hits,
+		Misses:   c.misses,
+		Size:     c.ll.Len(),
+		Capacity: c.capacity,
+	}
+}
@@ -0,0 +1,66 @@
+package easyfl
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Metadata headers.
+// Toolchains may watermark the generated script, for example with the template id and version, by prepending
+// metadata headers to the bytecode. Each header is the call prefix of MetadataFunCode with arity 0, followed by
+// inline data with the metadata. Headers are skipped by the parser, so they don't change semantics of the script,
+// its canonical form and its BytecodeID. Headers are allowed only at the beginning of the bytecode
+
+// MetadataFunCode is the function code of the metadata header. Extended functions never get this code
+const MetadataFunCode = uint16(LastGlobalFunCode)
+
+var metadataPrefix = func() []byte {
+	ret := make([]byte, 2)
+	binary.BigEndian.PutUint16(ret, uint16(FirstByteLongCallMask)<<8|MetadataFunCode)
+	return ret
+}()
+
+// WithMetadata prepends metadata headers to the bytecode. Each metadata is up to MaxInlineDataSize bytes long
+func WithMetadata(code []byte, metadata ...[]byte) ([]byte, error) {
+	ret := make([]byte, 0, len(code)+len(metadata)*(len(metadataPrefix)+1))
+	for _, md := range metadata {
+		data, err := InlineDataBytecode(md)
+		if err != nil {
+			return nil, fmt.Errorf("WithMetadata: metadata is %d bytes long: %v", len(md), err)
+		}
+		ret = append(ret, metadataPrefix...)
+		ret = append(ret, data...)
+	}
+	return append(ret, code...), nil
+}
+
+// BytecodeMetadata splits the bytecode into metadata of the headers and the script without headers.
+// The script is not parsed
+func BytecodeMetadata(code []byte) ([][]byte, []byte, error) {
+	ret := make([][]byte, 0)
+	for hasMetadataHeader(code) {
+		dataPrefix, itIsData, err := ParseBytecodeInlineDataPrefix(code[len(metadataPrefix):])
+		if err != nil {
+			return nil, nil, err
+		}
+		if !itIsData {
+			return nil, nil, fmt.Errorf("BytecodeMetadata: inline data expected after the metadata header")
+		}
+		ret = append(ret, dataPrefix[1:])
+		code = code[len(metadataPrefix)+len(dataPrefix):]
+	}
+	return ret, code, nil
+}
+
+// StripMetadata returns bytecode without metadata headers
+func StripMetadata(code []byte) ([]byte, error) {
+	if !hasMetadataHeader(code) {
+		return code, nil
+	}
+	_, ret, err := BytecodeMetadata(code)
+	return ret, err
+}
+
+func hasMetadataHeader(code []byte) bool {
+	return len(code) >= len(metadataPrefix) && code[0] == metadataPrefix[0] && code[1] == metadataPrefix[1]
+}
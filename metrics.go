@@ -0,0 +1,38 @@
+package easyfl
+
+// Metrics are size and complexity measures of the expression tree, for admission policies and inlining decisions
+type Metrics struct {
+	// number of all nodes: calls, inline data and parameter references
+	NumNodes int
+	// depth of the tree. Expression without arguments has depth 1
+	MaxDepth int
+	// total length of inline data, without prefixes
+	NumDataBytes int
+	// number of calls of each function. Inline data and parameter references are not calls
+	NumCallsBySym map[string]int
+}
+
+// ExpressionMetrics computes metrics of the expression tree in one walk.
+// Bodies of the called extended functions are not included, see MaxStaticDepth for that
+func ExpressionMetrics(f *Expression) Metrics {
+	ret := Metrics{NumCallsBySym: make(map[string]int)}
+	ret.add(f, 1)
+	return ret
+}
+
+func (m *Metrics) add(f *Expression, depth int) {
+	m.NumNodes++
+	if depth > m.MaxDepth {
+		m.MaxDepth = depth
+	}
+	switch {
+	case IsDataPrefix(f.CallPrefix):
+		m.NumDataBytes += len(f.CallPrefix) - 1
+	case isParameterReference(f.CallPrefix):
+	default:
+		m.NumCallsBySym[f.FunctionName]++
+	}
+	for _, arg := range f.Args {
+		m.add(arg, depth+1)
+	}
+}
@@ -0,0 +1,92 @@
+package easyfl
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// CanonicalizationVersion identifies rules of the canonical bytecode encoding. Stored scripts are migrated
+// from one version to another with MigrateBytecode, when the rules change
+type CanonicalizationVersion byte
+
+const (
+	// CanonicalizationNone is the bytecode as accepted by the parser, without any canonicalization
+	CanonicalizationNone = CanonicalizationVersion(iota)
+	// CanonicalizationV1 are the rules of CanonicalBytecode: calls are encoded with the shortest call prefix
+	CanonicalizationV1
+
+	// CanonicalizationLatest is the version of CanonicalBytecode and BytecodeID
+	CanonicalizationLatest = CanonicalizationV1
+)
+
+// migrationSteps[v] rewrites bytecode of version v into version v+1
+var migrationSteps = map[CanonicalizationVersion]func(lib *Library, code []byte) ([]byte, error){
+	CanonicalizationNone: (*Library).CanonicalBytecode,
+}
+
+// MigrateBytecode rewrites bytecode, canonical by the rules of the version 'from', into the canonical form of
+// the version 'to', step by step through all intermediate versions. Migration is deterministic.
+// Migrating to the same version only checks that the bytecode can be parsed. Downgrade is not possible
+func (lib *Library) MigrateBytecode(code []byte, from, to CanonicalizationVersion) ([]byte, error) {
+	if to > CanonicalizationLatest {
+		return nil, fmt.Errorf("MigrateBytecode: unknown canonicalization version %d", to)
+	}
+	if from > to {
+		return nil, fmt.Errorf("MigrateBytecode: can't migrate from version %d down to %d", from, to)
+	}
+	if from == to {
+		if _, err := lib.ExpressionFromBytecode(code); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), code...), nil
+	}
+	ret := code
+	for v := from; v < to; v++ {
+		var err error
+		if ret, err = migrationSteps[v](lib, ret); err != nil {
+			return nil, fmt.Errorf("MigrateBytecode: from version %d to %d: %v", v, v+1, err)
+		}
+	}
+	return ret, nil
+}
+
+// MigrationResult is the result of migration of one stored bytecode
+type MigrationResult struct {
+	Bytecode []byte
+	// hash of the bytecode before and after migration
+	OldID [32]byte
+	NewID [32]byte
+	// true if bytecode has been changed by the migration
+	Changed bool
+	Err     error
+}
+
+// MigrateBytecodes migrates each of the bytecodes and recomputes its ID. Results are in the order of bytecodes.
+// Migration of each bytecode is independent, errors are reported in the results
+func (lib *Library) MigrateBytecodes(codes [][]byte, from, to CanonicalizationVersion) []MigrationResult {
+	ret := make([]MigrationResult, len(codes))
+	for i, code := range codes {
+		ret[i].OldID = blake2b.Sum256(code)
+		migrated, err := lib.MigrateBytecode(code, from, to)
+		if err != nil {
+			ret[i].Err = err
+			continue
+		}
+		ret[i].Bytecode = migrated
+		ret[i].NewID = blake2b.Sum256(migrated)
+		ret[i].Changed = ret[i].NewID != ret[i].OldID
+	}
+	return ret
+}
+
+// MigratedIDs maps old IDs to new IDs of the successfully migrated bytecodes which have been changed
+func MigratedIDs(results []MigrationResult) map[[32]byte][32]byte {
+	ret := make(map[[32]byte][32]byte)
+	for i := range results {
+		if results[i].Err == nil && results[i].Changed {
+			ret[results[i].OldID] = results[i].NewID
+		}
+	}
+	return ret
+}
@@ -0,0 +1,158 @@
+package easyfl
+
+import "fmt"
+
+// Portability is the class of the function with respect to implementing it outside Go,
+// for example by an alternative VM written in Rust or compiled with TinyGo
+type Portability byte
+
+const (
+	// PortabilityGoOnly is the default class of embedded functions: implementation may depend on Go-specific packages
+	// or behavior, so the function can't be assumed to be implementable elsewhere
+	PortabilityGoOnly = Portability(iota)
+	// PortabilityCore function only manipulates bytes, needs no dependencies
+	PortabilityCore
+	// PortabilityCrypto function needs standard cryptographic primitives (blake2b, ed25519), available on all platforms
+	PortabilityCrypto
+)
+
+// embedded base functions which need cryptographic primitives. All other base embedded functions are PortabilityCore
+var cryptoBase = []string{"validSignatureED25519", "blake2b", "prand", "validMultiSigED25519", "chainHash"}
+
+func (p Portability) String() string {
+	switch p {
+	case PortabilityGoOnly:
+		return "go-only"
+	case PortabilityCore:
+		return "core"
+	case PortabilityCrypto:
+		return "crypto"
+	}
+	return fmt.Sprintf("portability(%d)", byte(p))
+}
+
+func (p Portability) isPortable() bool {
+	return p == PortabilityCore || p == PortabilityCrypto
+}
+
+// SetPortability tags embedded functions with the portability class. Portability of extended functions
+// is derived from the functions they call. Portability is not part of the library hash
+func (lib *Library) SetPortability(p Portability, syms ...string) error {
+	if p > PortabilityCrypto {
+		return fmt.Errorf("wrong portability class: %s", p)
+	}
+	for _, sym := range syms {
+		fd, found := lib.funByName[sym]
+		if !found {
+			return fmt.Errorf("no such function in the library: '%s'", sym)
+		}
+		if isEmbedded, _ := fd.isEmbeddedOrShort(); !isEmbedded {
+			return fmt.Errorf("portability can only be set for embedded function: '%s'", sym)
+		}
+		fd.portability = p
+	}
+	return nil
+}
+
+// annotatePortabilityBase tags all base embedded functions as portable
+func (lib *Library) annotatePortabilityBase() {
+	for _, fd := range lib.descriptorsByFunCode() {
+		if isEmbedded, _ := fd.isEmbeddedOrShort(); isEmbedded {
+			fd.portability = PortabilityCore
+		}
+	}
+	AssertNoError(lib.SetPortability(PortabilityCrypto, cryptoBase...))
+}
+
+// Portability returns portability class of the function. Extended function is portable if all functions
+// it calls are portable. Its class is PortabilityCrypto if any of them needs cryptographic primitives
+func (lib *Library) Portability(sym string) (Portability, error) {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return PortabilityGoOnly, fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	return lib.portabilityByFunCode()[fd.funCode], nil
+}
+
+// portabilityByFunCode derives portability of all functions. Extended functions call only functions
+// with smaller function codes, so one pass in the order of function codes is enough
+func (lib *Library) portabilityByFunCode() map[uint16]Portability {
+	ret := make(map[uint16]Portability)
+	for _, fd := range lib.descriptorsByFunCode() {
+		if isEmbedded, _ := fd.isEmbeddedOrShort(); isEmbedded {
+			ret[fd.funCode] = fd.portability
+		}
+	}
+	descriptors, bodies := lib.extendedBodies()
+	for i, body := range bodies {
+		p := PortabilityCore
+		var visit func(e *Expression)
+		visit = func(e *Expression) {
+			if !IsDataPrefix(e.CallPrefix) && !isParameterReference(e.CallPrefix) {
+				switch callee := ret[lib.descriptorOfCall(e.CallPrefix).funCode]; {
+				case !callee.isPortable():
+					p = PortabilityGoOnly
+				case callee == PortabilityCrypto && p == PortabilityCore:
+					p = PortabilityCrypto
+				}
+			}
+			for _, arg := range e.Args {
+				visit(arg)
+			}
+		}
+		visit(body)
+		ret[descriptors[i].funCode] = p
+	}
+	return ret
+}
+
+// PortableSubset returns all portable functions of the library in the order of function codes
+func (lib *Library) PortableSubset() []FunctionInfo {
+	portability := lib.portabilityByFunCode()
+	ret := make([]FunctionInfo, 0)
+	for _, fd := range lib.descriptorsByFunCode() {
+		if !portability[fd.funCode].isPortable() {
+			continue
+		}
+		isEmbedded, isShort := fd.isEmbeddedOrShort()
+		ret = append(ret, FunctionInfo{
+			Sym:        fd.sym,
+			FunCode:    fd.funCode,
+			IsEmbedded: isEmbedded,
+			IsShort:    isShort,
+			IsInternal: fd.internal,
+			NumParams:  fd.requiredNumParams,
+		})
+	}
+	return ret
+}
+
+// PortableOnly returns copy of the library with only portable functions. Function codes are the same as
+// in the original library, so bytecode which calls only portable functions is valid in both.
+// Not portable functions are not included into the hash of the returned library.
+// The returned library is for evaluation and hashing: function codes are not allocated contiguously anymore,
+// so it must not be extended
+func (lib *Library) PortableOnly() *Library {
+	portability := lib.portabilityByFunCode()
+	ret := lib.clone()
+	for _, fd := range lib.descriptorsByFunCode() {
+		if portability[fd.funCode].isPortable() {
+			continue
+		}
+		delete(ret.funByName, fd.sym)
+		delete(ret.funByFunCode, fd.funCode)
+		ret.funCodeTable[fd.funCode] = nil
+		switch isEmbedded, isShort := fd.isEmbeddedOrShort(); {
+		case isShort:
+			ret.numEmbeddedShort--
+		case isEmbedded:
+			ret.numEmbeddedLong--
+		default:
+			ret.numExtended--
+			if lib.isHashedExtendedFunCode(fd.funCode) {
+				ret.numHashedExtended--
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,151 @@
+package easyfl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Profiler is a wall-clock sampling profiler of evaluations. Each interval it requests a sample, and the next call
+// boundary of the profiled evaluation, upon entering or leaving the call, records the current stack of called functions.
+// Stacks include calls inside bodies of extended functions. The cost is two atomic loads per call, much less than tracing.
+// One profiler is usually shared by all evaluations of the batch, it is safe for concurrent use
+type Profiler struct {
+	// 1 if the sample is due
+	due     uint32
+	mutex   sync.Mutex
+	samples map[string]uint64
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+// Profiled is optionally implemented by GlobalData. If Profiler returns not nil, evaluation is profiled
+type Profiled interface {
+	Profiler() *Profiler
+}
+
+type globalDataProfiled struct {
+	GlobalData
+	profiler *Profiler
+}
+
+// NewProfiler starts the profiler, which takes samples each interval. It must be stopped with Stop
+func NewProfiler(interval time.Duration) *Profiler {
+	ret := &Profiler{
+		samples: make(map[string]uint64),
+		stop:    make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				atomic.StoreUint32(&ret.due, 1)
+			case <-ret.stop:
+				return
+			}
+		}
+	}()
+	return ret
+}
+
+// WithProfiler wraps global data so that evaluations with it are profiled.
+// The wrapper does not report trace events, even if the wrapped global data does
+func WithProfiler(glb GlobalData, p *Profiler) GlobalData {
+	if isNil(glb) {
+		glb = NewGlobalDataNoTrace(nil)
+	}
+	return &globalDataProfiled{GlobalData: glb, profiler: p}
+}
+
+func (g *globalDataProfiled) Profiler() *Profiler {
+	return g.profiler
+}
+
+func profilerOf(glb GlobalData) *Profiler {
+	if isNil(glb) {
+		return nil
+	}
+	if p, ok := glb.(Profiled); ok {
+		return p.Profiler()
+	}
+	return nil
+}
+
+// Stop stops taking samples. Samples taken so far remain available
+func (p *Profiler) Stop() {
+	p.stopped.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Samples returns number of samples of each stack. Stack is in the folded form: function names from the
+// outermost call, separated by ';'
+func (p *Profiler) Samples() map[string]uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ret := make(map[string]uint64, len(p.samples))
+	for stack, n := range p.samples {
+		ret[stack] = n
+	}
+	return ret
+}
+
+// Reset discards samples taken so far
+func (p *Profiler) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.samples = make(map[string]uint64)
+}
+
+// WriteFolded writes samples in the folded stacks format, one 'stack count' per line, sorted by stack.
+// The format is accepted by flame graph tools
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	samples := p.Samples()
+	stacks := make([]string, 0, len(samples))
+	for stack := range samples {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, samples[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Profiler) sampleIfDue(stack []string) {
+	if atomic.LoadUint32(&p.due) == 0 || !atomic.CompareAndSwapUint32(&p.due, 1, 0) {
+		return
+	}
+	folded := strings.Join(stack, ";")
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.samples[folded]++
+}
+
+// evalProfiled keeps stack of called functions for the profiler. Inline data and parameter references are not calls
+func (ctx *evalContext) evalProfiled(f *Expression) []byte {
+	if IsDataPrefix(f.CallPrefix) || isParameterReference(f.CallPrefix) {
+		return ctx.evalNotProfiled(f)
+	}
+	st := ctx.state
+	st.stack = append(st.stack, f.FunctionName)
+	// sample is taken both upon entering and upon leaving the call, so the time spent inside embedded
+	// function is attributed to it, not to the next call
+	st.profiler.sampleIfDue(st.stack)
+	defer func() {
+		st.profiler.sampleIfDue(st.stack)
+		st.stack = st.stack[:len(st.stack)-1]
+	}()
+	return ctx.evalNotProfiled(f)
+}
@@ -0,0 +1,63 @@
+package easyfl
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// CorpusRecord is the bytecode evaluated by EvalFromBytecode, recorded for the corpus of real-world scripts
+type CorpusRecord struct {
+	Hash     [32]byte
+	Bytecode []byte
+	ArgSizes []int
+}
+
+// CorpusSampling bounds overhead of the recorder
+type CorpusSampling struct {
+	// records each Every-th evaluation. 0 or 1 means each evaluation
+	Every uint64
+	// stops recording after MaxRecords records. 0 means no limit
+	MaxRecords uint64
+}
+
+type corpusRecorder struct {
+	// counters are first for 64-bit alignment of atomic access on 32-bit platforms
+	numEvals   uint64
+	numRecords uint64
+	sink       func(r *CorpusRecord)
+	sampling   CorpusSampling
+}
+
+// SetCorpusRecorder makes EvalFromBytecode pass sampled evaluated bytecodes to the sink. The bytecode in
+// the record is a copy. nil sink switches recording off.
+// The sink may be called concurrently if the library is used concurrently
+func (lib *Library) SetCorpusRecorder(sink func(r *CorpusRecord), sampling CorpusSampling) {
+	if sink == nil {
+		lib.recorder = nil
+		return
+	}
+	lib.recorder = &corpusRecorder{
+		sink:     sink,
+		sampling: sampling,
+	}
+}
+
+func (r *corpusRecorder) record(code []byte, args [][]byte) {
+	n := atomic.AddUint64(&r.numEvals, 1)
+	if r.sampling.Every > 1 && (n-1)%r.sampling.Every != 0 {
+		return
+	}
+	if r.sampling.MaxRecords > 0 && atomic.AddUint64(&r.numRecords, 1) > r.sampling.MaxRecords {
+		return
+	}
+	argSizes := make([]int, len(args))
+	for i := range args {
+		argSizes[i] = len(args[i])
+	}
+	r.sink(&CorpusRecord{
+		Hash:     blake2b.Sum256(code),
+		Bytecode: append([]byte(nil), code...),
+		ArgSizes: argSizes,
+	})
+}
@@ -0,0 +1,129 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// ReportTopN is the number of largest functions in the LibraryReport
+const ReportTopN = 10
+
+type (
+	// LibraryReport is the layout of the bytecode of the library extended functions. It is used to track
+	// bloat of the library across releases and to decide about inlining and pruning
+	LibraryReport struct {
+		LibraryHash [32]byte
+		// extended functions in the order of function codes
+		Functions []FunctionSizeReport
+		// total size of the bytecode of extended functions
+		TotalSize int
+		// bytes of inline data, including prefixes
+		DataBytes int
+		// bytes of call prefixes
+		CallBytes int
+		// bytes of parameter references
+		ParamBytes int
+		// ReportTopN largest functions, largest first
+		Largest []FunctionSizeReport
+		// Histogram[i] is the number of functions with bytecode size in the range [2^i, 2^(i+1))
+		Histogram []int
+	}
+
+	// FunctionSizeReport is the size of the bytecode of one extended function
+	FunctionSizeReport struct {
+		Sym        string
+		FunCode    uint16
+		Size       int
+		DataBytes  int
+		CallBytes  int
+		ParamBytes int
+		// total size of the bytecode of functions with codes up to this one
+		CumulativeSize int
+	}
+)
+
+// Report makes the size report of extended functions of the library
+func (lib *Library) Report() *LibraryReport {
+	ret := &LibraryReport{
+		LibraryHash: lib.LibraryHash(),
+		Functions:   make([]FunctionSizeReport, 0),
+		Histogram:   make([]int, 0),
+	}
+	descriptors, bodies := lib.extendedBodies()
+	for i, body := range bodies {
+		fr := FunctionSizeReport{
+			Sym:     descriptors[i].sym,
+			FunCode: descriptors[i].funCode,
+			Size:    len(descriptors[i].bytecode),
+		}
+		fr.addBytes(body)
+		ret.TotalSize += fr.Size
+		ret.DataBytes += fr.DataBytes
+		ret.CallBytes += fr.CallBytes
+		ret.ParamBytes += fr.ParamBytes
+		fr.CumulativeSize = ret.TotalSize
+		ret.Functions = append(ret.Functions, fr)
+
+		bucket := 0
+		for s := fr.Size; s > 1; s >>= 1 {
+			bucket++
+		}
+		for len(ret.Histogram) <= bucket {
+			ret.Histogram = append(ret.Histogram, 0)
+		}
+		ret.Histogram[bucket]++
+	}
+	ret.Largest = append([]FunctionSizeReport(nil), ret.Functions...)
+	sort.SliceStable(ret.Largest, func(i, j int) bool {
+		return ret.Largest[i].Size > ret.Largest[j].Size
+	})
+	if len(ret.Largest) > ReportTopN {
+		ret.Largest = ret.Largest[:ReportTopN]
+	}
+	return ret
+}
+
+func (fr *FunctionSizeReport) addBytes(e *Expression) {
+	switch {
+	case IsDataPrefix(e.CallPrefix):
+		fr.DataBytes += len(e.CallPrefix)
+	case isParameterReference(e.CallPrefix):
+		fr.ParamBytes += len(e.CallPrefix)
+	default:
+		fr.CallBytes += len(e.CallPrefix)
+	}
+	for _, arg := range e.Args {
+		fr.addBytes(arg)
+	}
+}
+
+// DataShare is the share of inline data in the bytecode
+func (r *LibraryReport) DataShare() float64 {
+	if r.TotalSize == 0 {
+		return 0
+	}
+	return float64(r.DataBytes) / float64(r.TotalSize)
+}
+
+func (r *LibraryReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "library %x: %d extended functions, %d bytes of bytecode\n", r.LibraryHash[:8], len(r.Functions), r.TotalSize)
+	fmt.Fprintf(&buf, "    inline data: %d bytes (%.1f%%), calls: %d bytes, parameters: %d bytes\n",
+		r.DataBytes, 100*r.DataShare(), r.CallBytes, r.ParamBytes)
+	buf.WriteString("size histogram:\n")
+	for i, n := range r.Histogram {
+		switch {
+		case n == 0:
+		case i == 0:
+			fmt.Fprintf(&buf, "    1 byte: %d\n", n)
+		default:
+			fmt.Fprintf(&buf, "    %d-%d bytes: %d\n", 1<<i, 1<<(i+1)-1, n)
+		}
+	}
+	buf.WriteString("largest functions:\n")
+	for _, fr := range r.Largest {
+		fmt.Fprintf(&buf, "    %s (%d): %d bytes\n", fr.Sym, fr.FunCode, fr.Size)
+	}
+	return buf.String()
+}
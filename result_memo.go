@@ -0,0 +1,97 @@
+package easyfl
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+type memoizedResult struct {
+	result []byte
+	err    error
+}
+
+// SetResultMemo enables bounded memo of evaluation results of EvalFromBytecodeMemoized with the given capacity.
+// 0 disables the memo
+func (lib *Library) SetResultMemo(capacity int) {
+	if capacity <= 0 {
+		lib.resultMemo = nil
+		return
+	}
+	lib.resultMemo = newLRUCache(capacity)
+}
+
+// InvalidateResultMemo removes all memoized results. Counters of the memo are kept
+func (lib *Library) InvalidateResultMemo() {
+	if lib.resultMemo != nil {
+		lib.resultMemo.reset()
+	}
+}
+
+// ResultMemoStats returns counters of the result memo. Zero if the memo is not enabled
+func (lib *Library) ResultMemoStats() CacheStats {
+	if lib.resultMemo == nil {
+		return CacheStats{}
+	}
+	return lib.resultMemo.stats()
+}
+
+// EvalFromBytecodeMemoized is EvalFromBytecode with results memoized by the hash of the library, bytecode, arguments
+// and the context key. The result depends on the global data too, so the context key must identify everything
+// in the global data the script can read, for example hash of the transaction. Results and errors are memoized,
+// except errors which depend on limits of the evaluation, such as *ErrOutOfGas or *ErrEvalRecursion.
+// Gas metered evaluations are never memoized, so that each of them consumes gas.
+// Returned result is shared and must not be modified. If the memo is not enabled, it is EvalFromBytecode
+func (lib *Library) EvalFromBytecodeMemoized(glb GlobalData, contextKey []byte, code []byte, args ...[]byte) ([]byte, error) {
+	memo := lib.resultMemo
+	if memo == nil || gasMeterOf(glb) != nil {
+		return lib.EvalFromBytecode(glb, code, args...)
+	}
+	key := lib.resultMemoKey(contextKey, code, args)
+	if ret, found := memo.get(key); found {
+		r := ret.(*memoizedResult)
+		return r.result, r.err
+	}
+	res, err := lib.EvalFromBytecode(glb, code, args...)
+	if !isLimitError(err) {
+		memo.put(key, &memoizedResult{result: res, err: err})
+	}
+	return res, err
+}
+
+// isLimitError returns if the error is caused by the limit of the evaluation rather than by the script itself.
+// Such errors can be different next time, with another budget or limit
+func isLimitError(err error) bool {
+	var errOutOfGas *ErrOutOfGas
+	var errRecursion *ErrEvalRecursion
+	var errBranchBudget *ErrBranchBudget
+	var errApplyNBudget *ErrApplyNBudget
+	return errors.As(err, &errOutOfGas) ||
+		errors.As(err, &errRecursion) ||
+		errors.As(err, &errBranchBudget) ||
+		errors.As(err, &errApplyNBudget)
+}
+
+// resultMemoKey hashes all parts prefixed with their lengths, so that different splits are different keys.
+// The library hash is included, so results memoized before the library changes are not found after
+func (lib *Library) resultMemoKey(contextKey []byte, code []byte, args [][]byte) [32]byte {
+	h, err := blake2b.New256(nil)
+	AssertNoError(err)
+	libHash := lib.LibraryHash()
+	_, _ = h.Write(libHash[:])
+	var lenBuf [4]byte
+	write := func(data []byte) {
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+		_, _ = h.Write(lenBuf[:])
+		_, _ = h.Write(data)
+	}
+	write(contextKey)
+	write(code)
+	for _, arg := range args {
+		write(arg)
+	}
+	var ret [32]byte
+	copy(ret[:], h.Sum(nil))
+	return ret
+}
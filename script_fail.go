@@ -0,0 +1,52 @@
+package easyfl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrScriptFail is a soft failure: the script is well-formed, but it fails by its own logic, for example with 'fail'
+// or 'requireErr'. All other errors of the evaluation are hard failures: malformed bytecode, wrong size of arguments
+// and similar. Hosts may treat them differently, for example in metrics and ban logic
+type ErrScriptFail struct {
+	Msg string
+	// error code of 'fail' or 'requireErr', if HasCode is true
+	HasCode bool
+	Code    uint16
+	// correlation ID of the evaluation, if any. See Correlated
+	CorrelationID string
+}
+
+func (e *ErrScriptFail) Error() string {
+	return prefixCorrelationID(e.CorrelationID, "SCRIPT FAIL: "+e.Msg)
+}
+
+// IsScriptFail returns true if the error is a soft failure of the script
+func IsScriptFail(err error) bool {
+	var errFail *ErrScriptFail
+	return errors.As(err, &errFail)
+}
+
+// Fail unwinds evaluation of the script with ErrScriptFail. It is a soft failure, unlike TracePanic
+func (p *CallParams) Fail(format string, args ...interface{}) {
+	p.fail(&ErrScriptFail{Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *CallParams) failWithCode(code uint16, format string, args ...interface{}) {
+	p.fail(&ErrScriptFail{Msg: fmt.Sprintf(format, args...), HasCode: true, Code: code})
+}
+
+func (p *CallParams) fail(err *ErrScriptFail) {
+	// trace message is prefixed with the correlation ID by Trace
+	p.Trace("SCRIPT FAIL: %s", err.Msg)
+	err.CorrelationID = p.ctx.state.correlationID
+	panic(err)
+}
+
+// panicIfScriptFail propagates the soft failure of dynamically evaluated bytecode as is, so that it is not
+// turned into the hard failure by wrapping
+func panicIfScriptFail(err error) {
+	if IsScriptFail(err) {
+		panic(err)
+	}
+}
@@ -0,0 +1,148 @@
+package easyfl
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Semantics annotations are executable specification of embedded functions. Both precondition and postcondition
+// are EasyFL expressions:
+// - precondition refers to call arguments as $0, $1, ... It defines the domain of the function
+// - postcondition refers to the result of the call as $0 and to call arguments as $1, $2, ...
+// Whenever precondition holds, the function must not fail and postcondition must hold
+
+type semanticsAnnotation struct {
+	pre, post             *Expression
+	preSource, postSource string
+}
+
+var semanticsBase = []struct{ sym, pre, post string }{
+	{"len", "", "equal(len($0), u64/8)"},
+	{"not", "", "if($1, not($0), equal($0, 0xff))"},
+	{"bitwiseNOT", "", "equal(bitwiseNOT($0), $1)"},
+	{"bitwiseXOR", "equal(len($0), len($1))", "equal(bitwiseXOR($0, $2), $1)"},
+	{"blake2b", "", "equal(len($0), u64/32)"},
+	{"chainHash", "", "equal($0, blake2b(concat($1, $2)))"},
+	{"word32At", "and(isZero(mod(len($0), 32)), not(isZero(len($1))), lessThan(len($1), u64/9), lessThan(uint64Bytes($1), div(len($0), 32)))",
+		"equal(len($0), u64/32)"},
+	{"numWords32", "isZero(mod(len($0), 32))", "equal(mul($0, 32), len($1))"},
+}
+
+func (lib *Library) annotateBase() {
+	for _, a := range semanticsBase {
+		AssertNoError(lib.AnnotateSemantics(a.sym, a.pre, a.post))
+	}
+}
+
+// AnnotateSemantics attaches precondition and postcondition to the function. Empty source means no condition
+func (lib *Library) AnnotateSemantics(sym, pre, post string) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	ret := &semanticsAnnotation{preSource: pre, postSource: post}
+	var err error
+	if pre != "" {
+		if ret.pre, _, _, err = lib.CompileExpression(pre); err != nil {
+			return fmt.Errorf("precondition of '%s': %v", sym, err)
+		}
+	}
+	if post != "" {
+		if ret.post, _, _, err = lib.CompileExpression(post); err != nil {
+			return fmt.Errorf("postcondition of '%s': %v", sym, err)
+		}
+	}
+	fd.semantics = ret
+	return nil
+}
+
+// CheckSemantics evaluates each annotated function on random arguments and checks annotations.
+// Arguments are random byte strings up to 33 bytes long, vararg functions are called with random number of them.
+// Returns error with the first violation found
+func (lib *Library) CheckSemantics(rnd *rand.Rand, samplesPerFunction int) error {
+	for _, fd := range lib.descriptorsByFunCode() {
+		if fd.semantics == nil {
+			continue
+		}
+		for i := 0; i < samplesPerFunction; i++ {
+			if err := lib.checkSemanticsSample(fd, randomArgs(rnd, fd.requiredNumParams)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func randomArgs(rnd *rand.Rand, numParams int) [][]byte {
+	if numParams < 0 {
+		// postcondition refers to the result as $0, so vararg functions are called with less arguments than maximum
+		numParams = rnd.Intn(MaxParameters)
+	}
+	ret := make([][]byte, numParams)
+	for i := range ret {
+		ret[i] = make([]byte, rnd.Intn(34))
+		rnd.Read(ret[i])
+	}
+	return ret
+}
+
+func (lib *Library) checkSemanticsSample(fd *funDescriptor, args [][]byte) error {
+	a := fd.semantics
+	argsStr := func() string {
+		ret := ""
+		for i, arg := range args {
+			if i > 0 {
+				ret += ", "
+			}
+			ret += Fmt(arg)
+		}
+		return ret
+	}
+	if a.pre != nil {
+		var holds []byte
+		err := CatchPanicOrError(func() error {
+			holds = EvalExpression(nil, a.pre, args...)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("precondition '%s' of '%s' failed on (%s): %v", a.preSource, fd.sym, argsStr(), err)
+		}
+		if len(holds) == 0 {
+			return nil
+		}
+	}
+	argExpr := make([]*Expression, len(args))
+	for i, arg := range args {
+		var err error
+		if argExpr[i], err = NewData(arg); err != nil {
+			return err
+		}
+	}
+	callExpr, err := lib.NewCall(fd.sym, argExpr...)
+	if err != nil {
+		return err
+	}
+	var result []byte
+	err = CatchPanicOrError(func() error {
+		result = EvalExpression(nil, callExpr)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("'%s' failed on (%s) within its domain: %v", fd.sym, argsStr(), err)
+	}
+	if a.post == nil {
+		return nil
+	}
+	var holds []byte
+	err = CatchPanicOrError(func() error {
+		holds = EvalExpression(nil, a.post, append([][]byte{result}, args...)...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("postcondition '%s' of '%s' failed on (%s) -> %s: %v", a.postSource, fd.sym, argsStr(), Fmt(result), err)
+	}
+	if len(holds) == 0 {
+		return fmt.Errorf("postcondition '%s' of '%s' does not hold on (%s) -> %s", a.postSource, fd.sym, argsStr(), Fmt(result))
+	}
+	return nil
+}
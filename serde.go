@@ -9,9 +9,23 @@ import (
 	"golang.org/x/crypto/blake2b"
 )
 
+// LibraryHash returns hash of the library. It is computed once and recomputed only after the library changes
 func (lib *Library) LibraryHash() [32]byte {
-	ret := blake2b.Sum256(lib.libraryBytes())
-	return ret
+	lib.hashMutex.Lock()
+	defer lib.hashMutex.Unlock()
+
+	if lib.hash == nil {
+		h := blake2b.Sum256(lib.libraryBytes())
+		lib.hash = &h
+	}
+	return *lib.hash
+}
+
+func (lib *Library) invalidateHash() {
+	lib.hashMutex.Lock()
+	defer lib.hashMutex.Unlock()
+
+	lib.hash = nil
 }
 
 func (lib *Library) libraryBytes() []byte {
@@ -28,17 +42,33 @@ func (lib *Library) write(w io.Writer) {
 	_ = binary.Write(w, binary.BigEndian, lib.numEmbeddedShort)
 	_ = binary.Write(w, binary.BigEndian, lib.numEmbeddedLong)
 	_ = binary.Write(w, binary.BigEndian, lib.numExtended)
+	if lib.arithmetic != ArithmeticDefault {
+		// construction options with default values are not written to keep hashes of existing libraries
+		_, _ = w.Write([]byte{0xff, byte(lib.arithmetic)})
+	}
+	if lib.noLocalLibraries {
+		_, _ = w.Write([]byte{0xfe})
+	}
+	if lib.maxApplyN != 0 {
+		_, _ = w.Write([]byte{0xfd})
+		_ = binary.Write(w, binary.BigEndian, uint32(lib.maxApplyN))
+	}
 
-	funCodes := make([]uint16, 0, len(lib.funByFunCode))
-	for funCode := range lib.funByFunCode {
-		funCodes = append(funCodes, funCode)
+	for _, fd := range lib.descriptorsByFunCode() {
+		fd.write(w)
 	}
-	sort.Slice(funCodes, func(i, j int) bool {
-		return funCodes[i] < funCodes[j]
-	})
-	for _, fc := range funCodes {
-		lib.funByFunCode[fc].write(w)
+}
+
+// descriptorsByFunCode returns all function descriptors sorted by function code
+func (lib *Library) descriptorsByFunCode() []*funDescriptor {
+	ret := make([]*funDescriptor, 0, len(lib.funByFunCode))
+	for _, fd := range lib.funByFunCode {
+		ret = append(ret, fd)
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].funCode < ret[j].funCode
+	})
+	return ret
 }
 
 func (fd *funDescriptor) write(w io.Writer) {
@@ -50,6 +80,10 @@ func (fd *funDescriptor) write(w io.Writer) {
 	if fd.requiredNumParams < 0 {
 		np = 0xff
 	}
+	if fd.internal {
+		// extended functions are never vararg, so the highest bit is free
+		np |= 0x80
+	}
 	_ = binary.Write(w, binary.BigEndian, np)
 
 	// function name
@@ -0,0 +1,167 @@
+// Package servedoc serves documentation of the EasyFL library over HTTP.
+// It renders functions of the library instance: index with search by name or function code,
+// per-function page with parameters, semantics annotations, source and bytecode of the extended functions
+package servedoc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+type handler struct {
+	lib *easyfl.Library
+	mux *http.ServeMux
+}
+
+// Handler returns http.Handler which serves documentation of the library:
+//   - / lists functions. Query parameter 'q' filters them by name substring or by exact function code
+//   - /fun/<name> shows the function
+//   - /functions.json returns all functions in JSON
+//
+// The library is read on each request, so functions added later are served too.
+// The library must not be modified concurrently with requests
+func Handler(lib *easyfl.Library) http.Handler {
+	h := &handler{
+		lib: lib,
+		mux: http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/", h.serveIndex)
+	h.mux.HandleFunc("/fun/", h.serveFunction)
+	h.mux.HandleFunc("/functions.json", h.serveJSON)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Search returns functions of the library which match the query: by the function code if query is a number,
+// otherwise by the case-insensitive name substring. Empty query matches all functions
+func Search(lib *easyfl.Library, query string) []easyfl.FunctionDoc {
+	docs := lib.FunctionDocs()
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return docs
+	}
+	code, err := strconv.Atoi(query)
+	isCode := err == nil
+	ret := make([]easyfl.FunctionDoc, 0)
+	for _, d := range docs {
+		if isCode && int(d.FunCode) == code || !isCode && strings.Contains(strings.ToLower(d.Sym), strings.ToLower(query)) {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+type functionView struct {
+	easyfl.FunctionDoc
+	Kind     string
+	Params   string
+	Bytecode string
+}
+
+func newFunctionView(d easyfl.FunctionDoc) functionView {
+	ret := functionView{
+		FunctionDoc: d,
+		Bytecode:    hex.EncodeToString(d.Bytecode),
+	}
+	switch {
+	case d.IsEmbedded && d.IsShort:
+		ret.Kind = "embedded short"
+	case d.IsEmbedded:
+		ret.Kind = "embedded long"
+	case d.IsInternal:
+		ret.Kind = "extended internal"
+	default:
+		ret.Kind = "extended"
+	}
+	if d.NumParams < 0 {
+		ret.Params = "vararg"
+	} else {
+		ret.Params = strconv.Itoa(d.NumParams)
+	}
+	return ret
+}
+
+func (h *handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	docs := Search(h.lib, query)
+	views := make([]functionView, len(docs))
+	for i := range docs {
+		views[i] = newFunctionView(docs[i])
+	}
+	hash := h.lib.LibraryHash()
+	render(w, indexTemplate, map[string]interface{}{
+		"Hash":      hex.EncodeToString(hash[:]),
+		"Query":     query,
+		"Functions": views,
+	})
+}
+
+func (h *handler) serveFunction(w http.ResponseWriter, r *http.Request) {
+	sym := strings.TrimPrefix(r.URL.Path, "/fun/")
+	d, found := h.lib.FunctionDoc(sym)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	render(w, functionTemplate, newFunctionView(d))
+}
+
+func (h *handler) serveJSON(w http.ResponseWriter, _ *http.Request) {
+	data, err := json.Marshal(h.lib.FunctionDocs())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(buf.String()))
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>EasyFL library</title></head>
+<body>
+<h1>EasyFL library</h1>
+<p>hash: <code>{{.Hash}}</code></p>
+<form action="/" method="get"><input name="q" value="{{.Query}}" placeholder="name or code"><input type="submit" value="search"></form>
+<table>
+<tr><th>code</th><th>name</th><th>params</th><th>kind</th></tr>
+{{range .Functions}}<tr><td>{{.FunCode}}</td><td><a href="/fun/{{.Sym}}">{{.Sym}}</a>{{if .Deprecated}} (deprecated){{end}}</td><td>{{.Params}}</td><td>{{.Kind}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+var functionTemplate = template.Must(template.New("function").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Sym}}</title></head>
+<body>
+<p><a href="/">index</a></p>
+<h1>{{.Sym}}</h1>
+<p>code: {{.FunCode}}, params: {{.Params}}, {{.Kind}}</p>
+{{if .Deprecated}}<p>deprecated: {{.Deprecated}}</p>{{end}}
+{{if .Precondition}}<p>precondition: <code>{{.Precondition}}</code></p>{{end}}
+{{if .Postcondition}}<p>postcondition: <code>{{.Postcondition}}</code></p>{{end}}
+{{if .Source}}<h2>source</h2><pre>{{.Source}}</pre>{{end}}
+{{if .Bytecode}}<h2>bytecode</h2><pre>{{.Bytecode}}</pre>{{end}}
+</body></html>
+`))
@@ -0,0 +1,70 @@
+package servedoc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, err := io.ReadAll(rec.Result().Body)
+	require.NoError(t, err)
+	return rec.Code, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	lib := easyfl.NewBase()
+	_, err := lib.ExtendErr("fun2", "concat($0,$1,0x01)")
+	require.NoError(t, err)
+	require.NoError(t, lib.DeprecateFunction("fun2", "use concat"))
+	h := Handler(lib)
+
+	t.Run("index", func(t *testing.T) {
+		code, body := get(t, h, "/")
+		require.EqualValues(t, http.StatusOK, code)
+		require.True(t, strings.Contains(body, `href="/fun/concat"`))
+		require.True(t, strings.Contains(body, `href="/fun/fun2"`))
+	})
+	t.Run("search", func(t *testing.T) {
+		code, body := get(t, h, "/?q=blake")
+		require.EqualValues(t, http.StatusOK, code)
+		require.True(t, strings.Contains(body, `href="/fun/blake2b"`))
+		require.False(t, strings.Contains(body, `href="/fun/concat"`))
+
+		d, found := lib.FunctionDoc("concat")
+		require.True(t, found)
+		docs := Search(lib, strconv.Itoa(int(d.FunCode)))
+		require.EqualValues(t, 1, len(docs))
+		require.EqualValues(t, "concat", docs[0].Sym)
+	})
+	t.Run("function", func(t *testing.T) {
+		code, body := get(t, h, "/fun/fun2")
+		require.EqualValues(t, http.StatusOK, code)
+		require.True(t, strings.Contains(body, "concat($0,$1,1)"))
+		require.True(t, strings.Contains(body, "deprecated: use concat"))
+
+		code, body = get(t, h, "/fun/blake2b")
+		require.EqualValues(t, http.StatusOK, code)
+		require.True(t, strings.Contains(body, "postcondition"))
+
+		code, _ = get(t, h, "/fun/notExists")
+		require.EqualValues(t, http.StatusNotFound, code)
+	})
+	t.Run("json", func(t *testing.T) {
+		code, body := get(t, h, "/functions.json")
+		require.EqualValues(t, http.StatusOK, code)
+		var docs []easyfl.FunctionDoc
+		require.NoError(t, json.Unmarshal([]byte(body), &docs))
+		require.EqualValues(t, len(lib.FunctionDocs()), len(docs))
+	})
+}
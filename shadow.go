@@ -0,0 +1,83 @@
+package easyfl
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ShadowDivergence describes different outcomes of the same bytecode evaluated by the library and its shadow
+type ShadowDivergence struct {
+	Bytecode     []byte
+	Args         [][]byte
+	Result       []byte
+	Err          error
+	ShadowResult []byte
+	ShadowErr    error
+}
+
+type shadowLibrary struct {
+	lib          *Library
+	onDivergence func(d *ShadowDivergence)
+}
+
+// SetShadow makes EvalFromBytecode evaluate each bytecode with the shadow library too, for example with the
+// upgraded version of the library before switching to it. Results of the shadow never affect results of
+// the library: divergences are only reported to the callback. The shadow evaluates with the data of the global data
+// only, so it does not consume gas of the evaluation, is not traced and is not profiled.
+// nil shadow switches shadow evaluation off. The shadow can be set while the library is used.
+// The callback may be called concurrently if the library is used concurrently
+func (lib *Library) SetShadow(shadow *Library, onDivergence func(d *ShadowDivergence)) error {
+	if shadow == nil {
+		lib.shadow.Store((*shadowLibrary)(nil))
+		return nil
+	}
+	if onDivergence == nil {
+		return fmt.Errorf("SetShadow: callback of divergences must not be nil")
+	}
+	lib.shadow.Store(&shadowLibrary{
+		lib:          shadow,
+		onDivergence: onDivergence,
+	})
+	return nil
+}
+
+// shadowOf returns the shadow library, if any
+func (lib *Library) shadowOf() *shadowLibrary {
+	ret, _ := lib.shadow.Load().(*shadowLibrary)
+	return ret
+}
+
+// shadowGlobalData exposes to the shadow evaluation data of the global data and its correlation ID, so that
+// errors of both evaluations can be compared. Gas meter, tracer and profiler of the global data are not exposed
+func shadowGlobalData(glb GlobalData) GlobalData {
+	if isNil(glb) {
+		return nil
+	}
+	ret := GlobalData(NewGlobalDataNoTrace(glb.Data()))
+	if id := correlationIDOf(glb); id != "" {
+		ret = WithCorrelationID(ret, id)
+	}
+	return ret
+}
+
+func (s *shadowLibrary) compare(glb GlobalData, code []byte, args [][]byte, res []byte, err error) {
+	shadowRes, shadowErr := s.lib.EvalFromBytecode(shadowGlobalData(glb), code, args...)
+	same := false
+	switch {
+	case err == nil && shadowErr == nil:
+		same = bytes.Equal(res, shadowRes)
+	case err != nil && shadowErr != nil:
+		same = err.Error() == shadowErr.Error()
+	}
+	if same {
+		return
+	}
+	s.onDivergence(&ShadowDivergence{
+		Bytecode:     code,
+		Args:         args,
+		Result:       res,
+		Err:          err,
+		ShadowResult: shadowRes,
+		ShadowErr:    shadowErr,
+	})
+}
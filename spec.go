@@ -0,0 +1,91 @@
+package easyfl
+
+import "encoding/json"
+
+//go:generate go run gen_spec.go
+
+// SpecVersion is the version of the bytecode format. It changes with any change of the format constants
+const SpecVersion = 1
+
+type (
+	// CodeRange is the inclusive range of codes
+	CodeRange struct {
+		First uint16 `json:"first"`
+		Last  uint16 `json:"last"`
+	}
+
+	// BytecodeSpec is machine-readable specification of the bytecode format, made from the constants
+	// used by the compiler and the parser. It is published in spec.json
+	BytecodeSpec struct {
+		Version int `json:"version"`
+
+		// inline data: the first byte has DataPrefixMask set, the length of data follows in bits masked by DataLenMask
+		DataPrefixMask    byte `json:"data_prefix_mask"`
+		DataLenMask       byte `json:"data_len_mask"`
+		MaxInlineDataSize int  `json:"max_inline_data_size"`
+
+		// long call: the first byte has LongCallMask set, arity in bits masked by LongCallArityMask,
+		// function code is 2-byte bigendian masked by LongCallCodeMask
+		LongCallMask       byte   `json:"long_call_mask"`
+		LongCallArityMask  byte   `json:"long_call_arity_mask"`
+		LongCallArityShift int    `json:"long_call_arity_shift"`
+		LongCallCodeMask   uint16 `json:"long_call_code_mask"`
+		MaxArity           int    `json:"max_arity"`
+
+		// short codes of parameter references: $i is i, $$i is i with BytecodeParameterFlag
+		Parameters            CodeRange `json:"parameters"`
+		MaxParameters         int       `json:"max_parameters"`
+		BytecodeParameterFlag byte      `json:"bytecode_parameter_flag"`
+
+		// function codes
+		EmbeddedShort CodeRange `json:"embedded_short"`
+		EmbeddedLong  CodeRange `json:"embedded_long"`
+		Extended      CodeRange `json:"extended"`
+		// part of the extended range for codes derived from function names: blake2b of the name, the first
+		// 4 bytes bigendian modulo the size of the range
+		HashedExtended CodeRange `json:"hashed_extended"`
+		// code of the metadata header call, not assigned to any function
+		MetadataFunCode uint16 `json:"metadata_fun_code"`
+
+		// local library call: long call with LocalCallCode, followed by 1-byte local index or by
+		// LocalFunIndexEscape and 2-byte bigendian index
+		LocalCallCode        uint16 `json:"local_call_code"`
+		LocalFunIndexEscape  byte   `json:"local_fun_index_escape"`
+		MaxNumLocalFunctions int    `json:"max_num_local_functions"`
+	}
+)
+
+// Spec returns specification of the bytecode format
+func Spec() BytecodeSpec {
+	return BytecodeSpec{
+		Version:               SpecVersion,
+		DataPrefixMask:        FirstByteDataMask,
+		DataLenMask:           FirstByteDataLenMask,
+		MaxInlineDataSize:     MaxInlineDataSize,
+		LongCallMask:          FirstByteLongCallMask,
+		LongCallArityMask:     FirstByteLongCallArityMask,
+		LongCallArityShift:    2,
+		LongCallCodeMask:      Uint16LongCallCodeMask,
+		MaxArity:              int(FirstByteLongCallArityMask >> 2),
+		Parameters:            CodeRange{First: FirstEmbeddedReserved, Last: LastEmbeddedReserved},
+		MaxParameters:         MaxParameters,
+		BytecodeParameterFlag: BytecodeParameterFlag,
+		EmbeddedShort:         CodeRange{First: FirstEmbeddedShort, Last: LastEmbeddedShort},
+		EmbeddedLong:          CodeRange{First: FirstEmbeddedLongFun, Last: LastEmbeddedLongFun},
+		Extended:              CodeRange{First: FirstExtendedFun, Last: MetadataFunCode - 1},
+		HashedExtended:        CodeRange{First: FirstHashedExtendedFun, Last: LastHashedExtendedFun},
+		MetadataFunCode:       MetadataFunCode,
+		LocalCallCode:         FirstLocalFunCode,
+		LocalFunIndexEscape:   LocalFunIndexEscape,
+		MaxNumLocalFunctions:  MaxNumLocalFunctions,
+	}
+}
+
+// SpecJSON returns the specification in JSON, as published in spec.json
+func SpecJSON() ([]byte, error) {
+	ret, err := json.MarshalIndent(Spec(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, '\n'), nil
+}
@@ -0,0 +1,114 @@
+// Package stdlocks contains reference implementations of common lock scripts as EasyFL library extensions.
+// Each lock is an extended function. Leading parameters are provided when the lock is unlocked, trailing ones
+// are constants of the particular lock. Helpers instantiate locks with the constants into bytecode
+// which takes only the unlock parameters.
+// The locks are a starting point: the message to sign, the current time and other values are taken from
+// the host data model, so hosts pass them as parameters or wrap the locks into their own functions
+package stdlocks
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/lunfardo314/easyfl"
+)
+
+// Source of the locks in the format of Library.ExtendMany
+const Source = `
+// $0 - message, $1 - signature, $2 - public key, $3 - blake2b hash of the public key
+func sigLockED25519: and(
+	equal(blake2b($2), $3),
+	validSignatureED25519($0, $1, $2)
+)
+
+// $0 - preimage, $1 - blake2b hash of the preimage
+func hashLock: equal(blake2b($0), $1)
+
+// $0 - message, $1 - signature entries, $2 - 1-byte m, $3 - concatenated public keys. See validMultiSigED25519
+func multiSigLockED25519: validMultiSigED25519($0, $2, $3, $1)
+
+// skeleton of time lock: $0 - current time, $1 - time when the lock opens. Both are big-endian integers up to 8 bytes
+func timeLock: not(lessThan(uint64Bytes($0), uint64Bytes($1)))
+`
+
+// Extend adds the locks to the library
+func Extend(lib *easyfl.Library) error {
+	return lib.ExtendMany(Source)
+}
+
+// SigLockED25519 is the signature lock of the public key with the given hash.
+// Unlock parameters: $0 - message, $1 - signature, $2 - public key
+func SigLockED25519(lib *easyfl.Library, pubKeyHash []byte) ([]byte, error) {
+	if len(pubKeyHash) != 32 {
+		return nil, fmt.Errorf("SigLockED25519: public key hash must be 32 bytes")
+	}
+	return instantiate(lib, "sigLockED25519", 3, pubKeyHash)
+}
+
+// HashLock opens with the preimage of the given blake2b hash. Unlock parameter: $0 - preimage
+func HashLock(lib *easyfl.Library, hash []byte) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("HashLock: hash must be 32 bytes")
+	}
+	return instantiate(lib, "hashLock", 1, hash)
+}
+
+// MultiSigLockED25519 requires signatures of at least m of the public keys. m must be from 1 to the number of keys.
+// Unlock parameters: $0 - message, $1 - signature entries, each 1-byte index of the key followed by the signature
+func MultiSigLockED25519(lib *easyfl.Library, m byte, pubKeys []ed25519.PublicKey) ([]byte, error) {
+	if m == 0 || len(pubKeys) == 0 || len(pubKeys) > 256 || int(m) > len(pubKeys) {
+		return nil, fmt.Errorf("MultiSigLockED25519: wrong m-of-n: %d of %d", m, len(pubKeys))
+	}
+	keys := make([]byte, 0, len(pubKeys)*ed25519.PublicKeySize)
+	for _, pk := range pubKeys {
+		if len(pk) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("MultiSigLockED25519: wrong public key length %d", len(pk))
+		}
+		keys = append(keys, pk...)
+	}
+	return instantiate(lib, "multiSigLockED25519", 2, []byte{m}, keys)
+}
+
+// TimeLock opens at the given time. Unlock parameter: $0 - current time
+func TimeLock(lib *easyfl.Library, unlockTime uint64) ([]byte, error) {
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], unlockTime)
+	return instantiate(lib, "timeLock", 1, t[:])
+}
+
+// instantiate compiles call of the lock with unlock parameters passed through and constants bound
+func instantiate(lib *easyfl.Library, sym string, numUnlockParams int, constants ...[]byte) ([]byte, error) {
+	args := make([]string, 0, numUnlockParams+len(constants))
+	for i := 0; i < numUnlockParams; i++ {
+		args = append(args, fmt.Sprintf("$%d", i))
+	}
+	for _, c := range constants {
+		args = append(args, dataSource(c))
+	}
+	_, _, code, err := lib.CompileExpression(fmt.Sprintf("%s(%s)", sym, strings.Join(args, ",")))
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// dataSource is source of the constant. Inline data is up to 127 bytes long, longer constants are concatenated
+func dataSource(data []byte) string {
+	const maxInline = easyfl.MaxInlineDataSize
+	if len(data) <= maxInline {
+		return "0x" + hex.EncodeToString(data)
+	}
+	chunks := make([]string, 0)
+	for len(data) > 0 {
+		n := maxInline
+		if len(data) < n {
+			n = len(data)
+		}
+		chunks = append(chunks, "0x"+hex.EncodeToString(data[:n]))
+		data = data[n:]
+	}
+	return fmt.Sprintf("concat(%s)", strings.Join(chunks, ","))
+}
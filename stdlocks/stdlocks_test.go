@@ -0,0 +1,107 @@
+package stdlocks
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"testing"
+
+	"github.com/lunfardo314/easyfl"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestLocks(t *testing.T) {
+	lib := easyfl.NewBase()
+	require.NoError(t, Extend(lib))
+
+	t.Run("signature", func(t *testing.T) {
+		pubKey, privKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		pubKeyHash := blake2b.Sum256(pubKey)
+		code, err := SigLockED25519(lib, pubKeyHash[:])
+		require.NoError(t, err)
+
+		msg := []byte("transaction essence")
+		sig := ed25519.Sign(privKey, msg)
+		ok, err := lib.EvalBool(nil, code, msg, sig, pubKey)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = lib.EvalBool(nil, code, []byte("other"), sig, pubKey)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		ok, err = lib.EvalBool(nil, code, msg, ed25519.Sign(otherPriv, msg), otherPub)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = SigLockED25519(lib, []byte{1})
+		require.Error(t, err)
+	})
+	t.Run("multisig", func(t *testing.T) {
+		const n = 5
+		pubKeys := make([]ed25519.PublicKey, n)
+		privKeys := make([]ed25519.PrivateKey, n)
+		for i := range pubKeys {
+			var err error
+			pubKeys[i], privKeys[i], err = ed25519.GenerateKey(nil)
+			require.NoError(t, err)
+		}
+		code, err := MultiSigLockED25519(lib, 3, pubKeys)
+		require.NoError(t, err)
+
+		msg := []byte("transaction essence")
+		entries := func(idx ...int) []byte {
+			ret := make([]byte, 0)
+			for _, i := range idx {
+				ret = append(ret, byte(i))
+				ret = append(ret, ed25519.Sign(privKeys[i], msg)...)
+			}
+			return ret
+		}
+		ok, err := lib.EvalBool(nil, code, msg, entries(4, 0, 2))
+		require.NoError(t, err)
+		require.True(t, ok)
+		ok, err = lib.EvalBool(nil, code, msg, entries(4, 0, 4))
+		require.NoError(t, err)
+		require.False(t, ok)
+		ok, err = lib.EvalBool(nil, code, msg, entries(1, 3))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = MultiSigLockED25519(lib, 6, pubKeys)
+		require.Error(t, err)
+		_, err = MultiSigLockED25519(lib, 0, pubKeys)
+		require.Error(t, err)
+	})
+	t.Run("hash", func(t *testing.T) {
+		h := blake2b.Sum256([]byte("secret"))
+		code, err := HashLock(lib, h[:])
+		require.NoError(t, err)
+		ok, err := lib.EvalBool(nil, code, []byte("secret"))
+		require.NoError(t, err)
+		require.True(t, ok)
+		ok, err = lib.EvalBool(nil, code, []byte("guess"))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	t.Run("time", func(t *testing.T) {
+		code, err := TimeLock(lib, 1000)
+		require.NoError(t, err)
+		var now [8]byte
+		for _, tc := range []struct {
+			now  uint64
+			open bool
+		}{{999, false}, {1000, true}, {1001, true}} {
+			binary.BigEndian.PutUint64(now[:], tc.now)
+			ok, err := lib.EvalBool(nil, code, now[:])
+			require.NoError(t, err)
+			require.EqualValues(t, tc.open, ok)
+		}
+		ok, err := lib.EvalBool(nil, code, []byte{0x03, 0xe8})
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !easyfl_minimal
+
+package easyfl
+
+// Test helpers. They pull testing and testify into the binary, so they are excluded by the 'easyfl_minimal'
+// build tag, which is intended for size-sensitive targets such as wasm and tinygo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func RequireErrorWith(t *testing.T, err error, s string) {
+	require.Error(t, err)
+	require.Contains(t, err.Error(), s)
+}
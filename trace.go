@@ -1,6 +1,8 @@
 package easyfl
 
-import "fmt"
+import (
+	"sync"
+)
 
 // GlobalDataNoTrace does not trace
 type GlobalDataNoTrace struct {
@@ -23,10 +25,12 @@ func (t *GlobalDataNoTrace) PutTrace(s string) {
 	panic("inconsistency: PutTrace should not be called for GlobalDataNoTrace")
 }
 
-// GlobalDataLog saves trace into the log
+// GlobalDataLog saves trace into the log. It can be shared by concurrent evaluations,
+// messages of different evaluations are interleaved in the log
 type GlobalDataLog struct {
-	glb interface{}
-	log []string
+	glb   interface{}
+	mutex sync.Mutex
+	log   []string
 }
 
 func NewGlobalDataLog(glb interface{}) *GlobalDataLog {
@@ -45,25 +49,48 @@ func (t *GlobalDataLog) Trace() bool {
 }
 
 func (t *GlobalDataLog) PutTrace(s string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	t.log = append(t.log, s)
 }
 
+// Log returns copy of the trace messages saved so far
+func (t *GlobalDataLog) Log() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return append([]string(nil), t.log...)
+}
+
 func (t *GlobalDataLog) PrintLog() {
-	fmt.Printf("--- trace begin ---\n")
-	for i, s := range t.log {
-		fmt.Printf("%d: %s\n", i, s)
+	t.PrintLogTo(DefaultLogger)
+}
+
+// PrintLogTo prints the trace messages to the logger
+func (t *GlobalDataLog) PrintLogTo(logger Logger) {
+	logger.Printf("--- trace begin ---\n")
+	for i, s := range t.Log() {
+		logger.Printf("%d: %s\n", i, s)
 	}
-	fmt.Printf("--- trace end ---\n")
+	logger.Printf("--- trace end ---\n")
 }
 
 // GlobalDataTracePrint just prints all trace messages
 type GlobalDataTracePrint struct {
-	glb interface{}
+	glb    interface{}
+	logger Logger
 }
 
 func NewGlobalDataTracePrint(glb interface{}) *GlobalDataTracePrint {
+	return NewGlobalDataTracePrintTo(glb, DefaultLogger)
+}
+
+// NewGlobalDataTracePrintTo prints trace messages to the logger
+func NewGlobalDataTracePrintTo(glb interface{}, logger Logger) *GlobalDataTracePrint {
 	return &GlobalDataTracePrint{
-		glb: glb,
+		glb:    glb,
+		logger: logger,
 	}
 }
 
@@ -76,5 +103,5 @@ func (t *GlobalDataTracePrint) Trace() bool {
 }
 
 func (t *GlobalDataTracePrint) PutTrace(s string) {
-	fmt.Printf("%s\n", s)
+	t.logger.Printf("%s\n", s)
 }
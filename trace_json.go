@@ -0,0 +1,167 @@
+package easyfl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	// TraceEvent is a structured trace record of one evaluated call
+	TraceEvent struct {
+		Fun string
+		// values of the arguments if they were evaluated before the call, otherwise nil.
+		// Lazily evaluated arguments are reported as separate events with bigger depth
+		Args     [][]byte
+		Result   []byte
+		Err      error
+		Duration time.Duration
+		Depth    int
+		// false for inline data and parameter references
+		IsCall bool
+	}
+
+	// EventTracer is optionally implemented by GlobalData. If Trace() returns true, the evaluator
+	// reports each call as TraceEvent upon its completion
+	EventTracer interface {
+		PutTraceEvent(e *TraceEvent)
+	}
+)
+
+func eventTracerOf(glb GlobalData) EventTracer {
+	if isNil(glb) || !glb.Trace() {
+		return nil
+	}
+	ret, _ := glb.(EventTracer)
+	return ret
+}
+
+// evalWithTraceEvent evaluates the expression and reports it to the event tracer. Panics are reported
+// with the error and propagated
+func (ctx *evalContext) evalWithTraceEvent(f *Expression) []byte {
+	c := newCall(f.EvalFunc, f.Args, ctx)
+	e := &TraceEvent{
+		Fun:    f.FunctionName,
+		Depth:  ctx.state.depth,
+		IsCall: !IsDataPrefix(f.CallPrefix) && !isParameterReference(f.CallPrefix),
+	}
+	ctx.state.depth++
+	start := time.Now()
+	defer func() {
+		ctx.state.depth--
+		e.Duration = time.Since(start)
+		e.Args = c.params.argValues
+		if r := recover(); r != nil {
+			var ok bool
+			if e.Err, ok = r.(error); !ok {
+				e.Err = fmt.Errorf("%v", r)
+			}
+			ctx.state.eventTracer.PutTraceEvent(e)
+			panic(r)
+		}
+		e.Result = c.cache
+		ctx.state.eventTracer.PutTraceEvent(e)
+	}()
+	return c.Eval()
+}
+
+// GlobalDataJSONTrace writes trace events and trace messages as JSON Lines
+type GlobalDataJSONTrace struct {
+	glb interface{}
+	w   io.Writer
+	// max number of bytes of each value written in hex. 0 means no limit
+	maxBytes  int
+	formatter ResultFormatter
+	mutex     sync.Mutex
+	err       error
+}
+
+// jsonTraceLine is one line of the JSON trace: either call event or trace message
+type jsonTraceLine struct {
+	Fun        string   `json:"fun,omitempty"`
+	Args       []string `json:"args,omitempty"`
+	Result     string   `json:"result,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	DurationNs int64    `json:"durationNs,omitempty"`
+	Depth      int      `json:"depth"`
+	Msg        string   `json:"msg,omitempty"`
+}
+
+func NewGlobalDataJSONTrace(glb interface{}, w io.Writer, maxBytes int) *GlobalDataJSONTrace {
+	return &GlobalDataJSONTrace{
+		glb:      glb,
+		w:        w,
+		maxBytes: maxBytes,
+	}
+}
+
+func (t *GlobalDataJSONTrace) Data() interface{} {
+	return t.glb
+}
+
+func (t *GlobalDataJSONTrace) Trace() bool {
+	return true
+}
+
+func (t *GlobalDataJSONTrace) PutTrace(s string) {
+	t.write(&jsonTraceLine{Msg: s})
+}
+
+func (t *GlobalDataJSONTrace) PutTraceEvent(e *TraceEvent) {
+	line := &jsonTraceLine{
+		Fun:        e.Fun,
+		DurationNs: e.Duration.Nanoseconds(),
+		Depth:      e.Depth,
+	}
+	if e.Args != nil {
+		line.Args = make([]string, len(e.Args))
+		for i, a := range e.Args {
+			line.Args[i] = t.hex(a)
+		}
+	}
+	if e.Err != nil {
+		line.Error = e.Err.Error()
+	} else {
+		line.Result = t.formatResult(e.Fun, e.Result)
+	}
+	t.write(line)
+}
+
+// SetResultFormatter makes tracer render results of functions with the formatter, usually the library
+func (t *GlobalDataJSONTrace) SetResultFormatter(f ResultFormatter) {
+	t.formatter = f
+}
+
+func (t *GlobalDataJSONTrace) formatResult(sym string, data []byte) string {
+	if t.formatter != nil {
+		if s, ok := t.formatter.FormatResult(sym, data); ok {
+			return s
+		}
+	}
+	return t.hex(data)
+}
+
+// Err returns first error occurred while writing the trace
+func (t *GlobalDataJSONTrace) Err() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.err
+}
+
+func (t *GlobalDataJSONTrace) hex(data []byte) string {
+	return hexBounded(data, t.maxBytes)
+}
+
+func (t *GlobalDataJSONTrace) write(line *jsonTraceLine) {
+	data, err := json.Marshal(line)
+	AssertNoError(err)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.err != nil {
+		return
+	}
+	_, t.err = t.w.Write(append(data, '\n'))
+}
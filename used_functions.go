@@ -0,0 +1,122 @@
+package easyfl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FunctionInfo describes function called in the bytecode
+type FunctionInfo struct {
+	Sym        string
+	FunCode    uint16
+	IsEmbedded bool
+	IsShort    bool
+	IsLocal    bool
+	IsInternal bool
+	// number of parameters of the library function or, for local library calls, number of arguments
+	NumParams int
+}
+
+// UsedFunctions lists all functions called in the bytecode, each once, in the order of the first call.
+// Function codes, unknown to the library, are reported as an error together with the list of known ones.
+// Short codes are one-byte calls with the arity known only to the library, so the scanning stops at the
+// unknown short code. Local library calls are reported with the local index, they are not resolved
+func (lib *Library) UsedFunctions(code []byte) ([]FunctionInfo, error) {
+	ret := make([]FunctionInfo, 0)
+	code, err := StripMetadata(code)
+	if err != nil {
+		return ret, err
+	}
+	seen := make(map[uint16]bool)
+	unknown := make([]uint16, 0)
+
+	pos := 0
+	for pending := 1; pending > 0; pending-- {
+		if pos >= len(code) {
+			return ret, io.EOF
+		}
+		dataPrefix, itIsData, err := ParseBytecodeInlineDataPrefix(code[pos:])
+		if err != nil {
+			return ret, err
+		}
+		if itIsData {
+			pos += len(dataPrefix)
+			continue
+		}
+		var funCode uint16
+		var arity int
+		isLocal := false
+		if code[pos]&FirstByteLongCallMask == 0 {
+			if code[pos] <= LastEmbeddedReserved {
+				// parameter reference
+				pos++
+				continue
+			}
+			funCode = uint16(code[pos])
+			fd := lib.funCodeTable[funCode]
+			if fd == nil {
+				return ret, fmt.Errorf("UsedFunctions: unknown short function code %d at position %d", funCode, pos)
+			}
+			arity = fd.requiredNumParams
+			pos++
+		} else {
+			if pos+2 > len(code) {
+				return ret, io.EOF
+			}
+			arity = ArityFromPrefix(code[pos:])
+			funCode = binary.BigEndian.Uint16(code[pos:pos+2]) & Uint16LongCallCodeMask
+			pos += 2
+			if funCode > FirstLocalFunCode {
+				return ret, fmt.Errorf("UsedFunctions: wrong call prefix at position %d", pos-2)
+			}
+			if funCode == FirstLocalFunCode {
+				if lib.noLocalLibraries {
+					return ret, fmt.Errorf("UsedFunctions: local library call at position %d: %w", pos-2, ErrLocalLibrariesDisabled)
+				}
+				localIdx, n, err := parseLocalFunIndex(code[pos:])
+				if err != nil {
+					return ret, err
+				}
+				funCode += localIdx
+				isLocal = true
+				pos += n
+			}
+		}
+		pending += arity
+		if seen[funCode] {
+			continue
+		}
+		seen[funCode] = true
+		if isLocal {
+			ret = append(ret, FunctionInfo{
+				Sym:       fmt.Sprintf("lib#%d", funCode-FirstLocalFunCode),
+				FunCode:   funCode,
+				IsLocal:   true,
+				NumParams: arity,
+			})
+			continue
+		}
+		fd := lib.funCodeTable[funCode]
+		if fd == nil {
+			unknown = append(unknown, funCode)
+			continue
+		}
+		isEmbedded, isShort := fd.isEmbeddedOrShort()
+		ret = append(ret, FunctionInfo{
+			Sym:        fd.sym,
+			FunCode:    funCode,
+			IsEmbedded: isEmbedded,
+			IsShort:    isShort,
+			IsInternal: fd.internal,
+			NumParams:  fd.requiredNumParams,
+		})
+	}
+	if pos != len(code) {
+		return ret, fmt.Errorf("UsedFunctions: not all bytes have been consumed. Remaining: %s", Fmt(code[pos:]))
+	}
+	if len(unknown) > 0 {
+		return ret, fmt.Errorf("UsedFunctions: unknown function codes %v", unknown)
+	}
+	return ret, nil
+}
@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
-	"testing"
-
-	"github.com/stretchr/testify/require"
+	"sync/atomic"
 )
 
 func concat(data ...interface{}) []byte {
@@ -49,11 +47,6 @@ func CatchPanicOrError(f func() error) error {
 	return err
 }
 
-func RequireErrorWith(t *testing.T, err error, s string) {
-	require.Error(t, err)
-	require.Contains(t, err.Error(), s)
-}
-
 func Assert(cond bool, format string, args ...interface{}) {
 	if !cond {
 		panic(fmt.Sprintf("assertion failed:: "+format, args...))
@@ -68,6 +61,40 @@ func Hex(data []byte) string {
 	return fmt.Sprintf("%dx%s", len(data), hex.EncodeToString(data))
 }
 
+var (
+	fmtMaxBytes int32
+	fmtRedactor atomic.Value
+)
+
+// SetFmtMaxBytes limits number of bytes shown by Fmt in traces and messages. The rest is replaced by the
+// number of omitted bytes. 0 means no limit (default)
+func SetFmtMaxBytes(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&fmtMaxBytes, int32(n))
+}
+
+// SetFmtRedactor installs hook for sensitive values. If it returns true, Fmt shows returned string instead
+// of the data. nil removes the hook
+func SetFmtRedactor(redact func(data []byte) (string, bool)) {
+	fmtRedactor.Store(redact)
+}
+
+// Fmt formats data for traces and messages, taking into account the limit and redactor
 func Fmt(data []byte) string {
-	return Hex(data)
+	if redact, _ := fmtRedactor.Load().(func([]byte) (string, bool)); redact != nil {
+		if s, ok := redact(data); ok {
+			return s
+		}
+	}
+	return hexBounded(data, int(atomic.LoadInt32(&fmtMaxBytes)))
+}
+
+// hexBounded is Hex which shows at most maxBytes bytes. 0 means no limit
+func hexBounded(data []byte, maxBytes int) string {
+	if maxBytes == 0 || len(data) <= maxBytes {
+		return Hex(data)
+	}
+	return fmt.Sprintf("%dx%s..(+%d)", len(data), hex.EncodeToString(data[:maxBytes]), len(data)-maxBytes)
 }
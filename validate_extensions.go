@@ -0,0 +1,86 @@
+package easyfl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExtensionProblem is a problem of one function in the source of extensions
+type ExtensionProblem struct {
+	Sym string
+	// line of the function definition in the source, starting from 1
+	Line int
+	Err  error
+}
+
+func (p ExtensionProblem) String() string {
+	return fmt.Sprintf("'%s' (line %d): %v", p.Sym, p.Line, p.Err)
+}
+
+// ExtensionProblems is the aggregated report of all problems found in the source of extensions
+type ExtensionProblems []ExtensionProblem
+
+func (p ExtensionProblems) Error() string {
+	lines := make([]string, len(p))
+	for i := range p {
+		lines[i] = p[i].String()
+	}
+	return fmt.Sprintf("%d problem(s) in extensions:\n%s", len(p), strings.Join(lines, "\n"))
+}
+
+// ValidateExtensions compiles functions of the source in the format of ExtendMany, each after the previous one,
+// without modifying the library. Unlike ExtendMany, it does not stop at the first problem. It reports
+// each function which can't be compiled, for example because of too long inline literals, and each function with
+// bytecode longer than maxBytecodeSize. 0 means no limit of the bytecode size.
+// Returns nil if there are no problems, otherwise ExtensionProblems.
+// Functions which depend on the function with the problem are reported too
+func (lib *Library) ValidateExtensions(source string, maxBytecodeSize int) error {
+	parsed, err := parseFunctions(source)
+	if err != nil {
+		return err
+	}
+	tmp := lib.clone()
+	ret := make(ExtensionProblems, 0)
+	for _, pf := range parsed {
+		err = CatchPanicOrError(func() error {
+			_, err := tmp.ExtendErr(pf.Sym, pf.SourceCode)
+			return err
+		})
+		if err != nil {
+			ret = append(ret, ExtensionProblem{Sym: pf.Sym, Line: pf.Line, Err: err})
+			continue
+		}
+		if size := len(tmp.funByName[pf.Sym].bytecode); maxBytecodeSize > 0 && size > maxBytecodeSize {
+			ret = append(ret, ExtensionProblem{
+				Sym:  pf.Sym,
+				Line: pf.Line,
+				Err:  fmt.Errorf("bytecode is %d bytes long, more than %d", size, maxBytecodeSize),
+			})
+		}
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// clone makes a copy of the library, which can be extended independently. Function descriptors are shared,
+// optional settings, such as caches and recorders, are not copied
+func (lib *Library) clone() *Library {
+	ret := newLibrary()
+	for sym, fd := range lib.funByName {
+		ret.funByName[sym] = fd
+	}
+	for funCode, fd := range lib.funByFunCode {
+		ret.funByFunCode[funCode] = fd
+	}
+	copy(ret.funCodeTable, lib.funCodeTable)
+	ret.numEmbeddedShort = lib.numEmbeddedShort
+	ret.numEmbeddedLong = lib.numEmbeddedLong
+	ret.numExtended = lib.numExtended
+	ret.numHashedExtended = lib.numHashedExtended
+	ret.arithmetic = lib.arithmetic
+	ret.noLocalLibraries = lib.noLocalLibraries
+	ret.hashedExtensionCodes = lib.hashedExtensionCodes
+	return ret
+}
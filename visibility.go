@@ -0,0 +1,55 @@
+package easyfl
+
+import "fmt"
+
+// MarkInternal makes extended function internal: it can be called only from other library functions.
+// Sources compiled outside the library can't call it and bytecode calling it is rejected by CheckExternalBytecode.
+// Bytecode parsed during evaluation, by 'eval' and similar functions, and local libraries can't call it either.
+// Visibility is part of the library hash
+func (lib *Library) MarkInternal(sym string) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	if fd.bytecode == nil {
+		return fmt.Errorf("only extended functions can be internal: '%s'", sym)
+	}
+	fd.internal = true
+	lib.hasInternal = true
+	lib.invalidateHash()
+	return nil
+}
+
+// CheckExternalBytecode checks if the bytecode coming from outside the library, for example from a transaction,
+// calls only known and not internal library functions
+func (lib *Library) CheckExternalBytecode(code []byte) error {
+	used, err := lib.UsedFunctions(code)
+	if err != nil {
+		return err
+	}
+	for _, fi := range used {
+		if fi.IsInternal {
+			return fmt.Errorf("internal library function can't be called outside the library: '%s'", fi.Sym)
+		}
+	}
+	return nil
+}
+
+// checkNotInternal checks if the expression, parsed from the bytecode coming from outside the library,
+// calls internal library functions. Calls of local library functions are not checked
+func (lib *Library) checkNotInternal(expr *Expression) error {
+	if !lib.hasInternal {
+		return nil
+	}
+	if !IsDataPrefix(expr.CallPrefix) && !isParameterReference(expr.CallPrefix) {
+		if fd := lib.descriptorOfCall(expr.CallPrefix); fd != nil && fd.internal {
+			return fmt.Errorf("internal library function can't be called outside the library: '%s'", fd.sym)
+		}
+	}
+	for _, arg := range expr.Args {
+		if err := lib.checkNotInternal(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package easyfl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// CompileWarningKind classifies compiler warnings
+	CompileWarningKind string
+
+	// CompileWarning is a problem in the source, which does not prevent compilation
+	CompileWarning struct {
+		Kind    CompileWarningKind
+		Message string
+	}
+)
+
+const (
+	WarningUnusedParameter     = CompileWarningKind("unused parameter")
+	WarningNonCanonicalLiteral = CompileWarningKind("non-canonical literal")
+	WarningDeprecatedFunction  = CompileWarningKind("deprecated function")
+)
+
+func (w *CompileWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Kind, w.Message)
+}
+
+// CompileExpressionWithWarnings is CompileExpression which also returns warnings about the source
+func (lib *Library) CompileExpressionWithWarnings(source string, localLib ...*LocalLibrary) (*Expression, int, []byte, []*CompileWarning, error) {
+	expr, numParams, bytecode, err := lib.CompileExpression(source, localLib...)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	parsed, err := parseExpression(stripSpaces(strings.Join(splitLinesStripComments(source), "")))
+	AssertNoError(err)
+	return expr, numParams, bytecode, lib.sourceWarnings(parsed, numParams), nil
+}
+
+// DeprecateFunction marks function as deprecated. Calls to it produce compiler warnings
+func (lib *Library) DeprecateFunction(sym string, note string) error {
+	fd, found := lib.funByName[sym]
+	if !found {
+		return fmt.Errorf("no such function in the library: '%s'", sym)
+	}
+	if note == "" {
+		note = "deprecated"
+	}
+	fd.deprecated = note
+	return nil
+}
+
+func (lib *Library) sourceWarnings(f *parsedExpression, numParams int) []*CompileWarning {
+	ret := make([]*CompileWarning, 0)
+	used := make([]bool, numParams)
+	f.walk(func(e *parsedExpression) {
+		if len(e.params) == 0 {
+			if n, ok := parameterReference(e.sym); ok {
+				used[n] = true
+				return
+			}
+			if w := literalWarning(e.sym); w != nil {
+				ret = append(ret, w)
+				return
+			}
+		}
+		if fd, found := lib.funByName[e.sym]; found && fd.deprecated != "" {
+			ret = append(ret, &CompileWarning{
+				Kind:    WarningDeprecatedFunction,
+				Message: fmt.Sprintf("'%s': %s", e.sym, fd.deprecated),
+			})
+		}
+	})
+	for i, u := range used {
+		if !u {
+			ret = append(ret, &CompileWarning{
+				Kind:    WarningUnusedParameter,
+				Message: fmt.Sprintf("$%d is not used while $%d is", i, numParams-1),
+			})
+		}
+	}
+	return ret
+}
+
+func (f *parsedExpression) walk(fun func(e *parsedExpression)) {
+	fun(f)
+	for _, p := range f.params {
+		p.walk(fun)
+	}
+}
+
+// parameterReference returns number of the parameter if the symbol is '$i' or '$$i'
+func parameterReference(sym string) (int, bool) {
+	if !strings.HasPrefix(sym, "$") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(sym, "$"), "$"))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// literalWarning checks if the literal has the same form as it is decompiled
+func literalWarning(sym string) *CompileWarning {
+	if !strings.HasPrefix(sym, "0x") {
+		return nil
+	}
+	var canonical string
+	switch len(sym) {
+	case 2:
+		canonical = "nil"
+	case 4:
+		n, err := strconv.ParseUint(sym[2:], 16, 8)
+		if err != nil {
+			return nil
+		}
+		canonical = strconv.Itoa(int(n))
+	default:
+		return nil
+	}
+	return &CompileWarning{
+		Kind:    WarningNonCanonicalLiteral,
+		Message: fmt.Sprintf("'%s' is canonically written as '%s'", sym, canonical),
+	}
+}
+
+// checkParameterGaps returns error if any of parameters below the biggest referenced one is not referenced
+// in the compiled expression. Such a function requires arguments it never uses, which is most likely a mistake
+func checkParameterGaps(expr *Expression, numParams int) error {
+	used := make([]bool, numParams)
+	markUsedParameters(expr, used)
+	for i, u := range used {
+		if !u {
+			return fmt.Errorf("parameter $%d is not used while $%d is", i, numParams-1)
+		}
+	}
+	return nil
+}
+
+func markUsedParameters(expr *Expression, used []bool) {
+	if len(expr.CallPrefix) == 1 && expr.CallPrefix[0] <= LastEmbeddedReserved {
+		if n := int(expr.CallPrefix[0] &^ BytecodeParameterFlag); n < len(used) {
+			used[n] = true
+		}
+		return
+	}
+	for _, arg := range expr.Args {
+		markUsedParameters(arg, used)
+	}
+}